@@ -0,0 +1,381 @@
+package addrmgr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	mrand "math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const newBucketCount = 64
+const triedBucketCount = 16
+const bucketSize = 32
+
+// Addresses that keep failing are forgotten once they have to compete for a
+// bucket slot and are never handed out for new connections.
+const maxFailedAttempts = 10
+const retryInterval = time.Minute
+const staleAge = 30 * 24 * time.Hour
+
+const getAddrMax = 1000
+const getAddrPercent = 23
+
+var ErrCorrupt = errors.New("addrmgr: corrupt address file")
+
+type KnownAddress struct {
+	Addr        string
+	Source      string
+	LastSeen    time.Time
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Attempts    int
+	Tried       bool
+}
+
+func (ka *KnownAddress) isBad(now time.Time) bool {
+	if ka.LastSuccess.IsZero() && ka.Attempts >= maxFailedAttempts {
+		return true
+	}
+	return now.Sub(ka.LastSeen) > staleAge
+}
+
+// AddrManager is the node's address book. Addresses learned from peers go
+// into "new" buckets chosen by the network group of the address and of the
+// peer that told us about it, so a single source cannot flood the table.
+// Addresses we have connected to successfully move to "tried" buckets.
+type AddrManager struct {
+	mu     sync.Mutex
+	path   string
+	key    [32]byte
+	rand   *mrand.Rand
+	index  map[string]*KnownAddress
+	new    [newBucketCount]map[string]*KnownAddress
+	tried  [triedBucketCount]map[string]*KnownAddress
+	nNew   int
+	nTried int
+}
+
+type savedAddrManager struct {
+	Key       string
+	Addresses []*KnownAddress
+}
+
+// New returns an address book persisted at path, loading it if the file
+// exists. An empty path keeps the book in memory only.
+func New(path string) (*AddrManager, error) {
+	a := &AddrManager{path: path}
+	if _, err := rand.Read(a.key[:]); err != nil {
+		return nil, err
+	}
+	a.reset()
+	if path == "" {
+		return a, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := a.load(data); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AddrManager) reset() {
+	a.rand = mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(a.key[:]))))
+	a.index = make(map[string]*KnownAddress)
+	for i := range a.new {
+		a.new[i] = make(map[string]*KnownAddress)
+	}
+	for i := range a.tried {
+		a.tried[i] = make(map[string]*KnownAddress)
+	}
+	a.nNew = 0
+	a.nTried = 0
+}
+
+func (a *AddrManager) load(data []byte) error {
+	var saved savedAddrManager
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return ErrCorrupt
+	}
+	key, err := hex.DecodeString(saved.Key)
+	if err != nil || len(key) != len(a.key) {
+		return ErrCorrupt
+	}
+	copy(a.key[:], key)
+	a.reset()
+	for _, ka := range saved.Addresses {
+		if ka == nil || ka.Addr == "" {
+			return ErrCorrupt
+		}
+		if _, exists := a.index[ka.Addr]; exists {
+			continue
+		}
+		if ka.Tried {
+			a.addTried(ka)
+		} else {
+			a.addNew(ka)
+		}
+	}
+	return nil
+}
+
+// Save writes the address book to disk.
+func (a *AddrManager) Save() error {
+	if a.path == "" {
+		return nil
+	}
+	a.mu.Lock()
+	saved := savedAddrManager{Key: hex.EncodeToString(a.key[:])}
+	for _, ka := range a.index {
+		copied := *ka
+		saved.Addresses = append(saved.Addresses, &copied)
+	}
+	a.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
+
+// AddAddresses records addrs as advertised by source.
+func (a *AddrManager) AddAddresses(addrs []string, source string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for _, addr := range addrs {
+		a.addAddress(addr, source, now)
+	}
+}
+
+func (a *AddrManager) AddAddress(addr, source string) {
+	a.AddAddresses([]string{addr}, source)
+}
+
+func (a *AddrManager) addAddress(addr, source string, now time.Time) {
+	if addr == "" {
+		return
+	}
+	if ka, exists := a.index[addr]; exists {
+		if now.After(ka.LastSeen) {
+			ka.LastSeen = now
+		}
+		return
+	}
+	a.addNew(&KnownAddress{Addr: addr, Source: source, LastSeen: now})
+}
+
+// Attempt records that we are about to dial addr.
+func (a *AddrManager) Attempt(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ka, exists := a.index[addr]; exists {
+		ka.Attempts++
+		ka.LastAttempt = time.Now()
+	}
+}
+
+// Good records a successful handshake with addr and moves it to a tried
+// bucket, pushing the oldest entry of a full bucket back to new.
+func (a *AddrManager) Good(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	ka, exists := a.index[addr]
+	if !exists {
+		ka = &KnownAddress{Addr: addr, Source: addr}
+	}
+	ka.LastSeen = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+	if ka.Tried {
+		return
+	}
+	if exists {
+		a.removeNew(ka)
+	}
+	a.addTried(ka)
+}
+
+// GetAddress picks an address to dial, choosing between tried and new
+// evenly when both are available. Addresses attempted within the last
+// minute or deemed bad are skipped.
+func (a *AddrManager) GetAddress() (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	var candidates []*KnownAddress
+	useTried := a.nTried > 0 && (a.nNew == 0 || a.rand.Intn(2) == 0)
+	if useTried {
+		for _, bucket := range a.tried {
+			candidates = appendUsable(candidates, bucket, now)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, bucket := range a.new {
+			candidates = appendUsable(candidates, bucket, now)
+		}
+	}
+	if len(candidates) == 0 && !useTried {
+		for _, bucket := range a.tried {
+			candidates = appendUsable(candidates, bucket, now)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	return candidates[a.rand.Intn(len(candidates))].Addr, true
+}
+
+func appendUsable(list []*KnownAddress, bucket map[string]*KnownAddress, now time.Time) []*KnownAddress {
+	for _, ka := range bucket {
+		if ka.isBad(now) || now.Sub(ka.LastAttempt) < retryInterval {
+			continue
+		}
+		list = append(list, ka)
+	}
+	return list
+}
+
+// AddressCache returns a random sample of good addresses to answer a
+// getaddr request.
+func (a *AddrManager) AddressCache() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	var addrs []string
+	for addr, ka := range a.index {
+		if !ka.isBad(now) {
+			addrs = append(addrs, addr)
+		}
+	}
+	a.rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	n := len(addrs) * getAddrPercent / 100
+	if n == 0 && len(addrs) > 0 {
+		n = 1
+	}
+	if n > getAddrMax {
+		n = getAddrMax
+	}
+	return addrs[:n]
+}
+
+func (a *AddrManager) NumAddresses() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.index)
+}
+
+func (a *AddrManager) addNew(ka *KnownAddress) {
+	bucket := a.new[a.newBucket(ka.Addr, ka.Source)]
+	if len(bucket) >= bucketSize {
+		a.evict(bucket)
+	}
+	ka.Tried = false
+	bucket[ka.Addr] = ka
+	a.index[ka.Addr] = ka
+	a.nNew++
+}
+
+func (a *AddrManager) removeNew(ka *KnownAddress) {
+	bucket := a.new[a.newBucket(ka.Addr, ka.Source)]
+	if _, exists := bucket[ka.Addr]; exists {
+		delete(bucket, ka.Addr)
+		a.nNew--
+	}
+}
+
+func (a *AddrManager) addTried(ka *KnownAddress) {
+	bucket := a.tried[a.triedBucket(ka.Addr)]
+	if len(bucket) >= bucketSize {
+		oldest := oldestEntry(bucket, func(ka *KnownAddress) time.Time { return ka.LastSuccess })
+		delete(bucket, oldest.Addr)
+		a.nTried--
+		a.addNew(oldest)
+	}
+	ka.Tried = true
+	bucket[ka.Addr] = ka
+	a.index[ka.Addr] = ka
+	a.nTried++
+}
+
+// evict drops a bad entry from a full new bucket, or the least recently
+// seen one if none are bad.
+func (a *AddrManager) evict(bucket map[string]*KnownAddress) {
+	now := time.Now()
+	var victim *KnownAddress
+	for _, ka := range bucket {
+		if ka.isBad(now) {
+			victim = ka
+			break
+		}
+	}
+	if victim == nil {
+		victim = oldestEntry(bucket, func(ka *KnownAddress) time.Time { return ka.LastSeen })
+	}
+	delete(bucket, victim.Addr)
+	delete(a.index, victim.Addr)
+	a.nNew--
+}
+
+func oldestEntry(bucket map[string]*KnownAddress, when func(*KnownAddress) time.Time) *KnownAddress {
+	var oldest *KnownAddress
+	for _, ka := range bucket {
+		if oldest == nil || when(ka).Before(when(oldest)) || (when(ka).Equal(when(oldest)) && ka.Addr < oldest.Addr) {
+			oldest = ka
+		}
+	}
+	return oldest
+}
+
+func (a *AddrManager) newBucket(addr, source string) int {
+	return a.bucketHash(groupKey(addr), groupKey(source)) % newBucketCount
+}
+
+func (a *AddrManager) triedBucket(addr string) int {
+	return a.bucketHash(addr) % triedBucketCount
+}
+
+func (a *AddrManager) bucketHash(parts ...string) int {
+	h := sha256.New()
+	h.Write(a.key[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return int(binary.LittleEndian.Uint32(h.Sum(nil)) & 0x7fffffff)
+}
+
+// groupKey maps an address to its network group: the /16 for IPv4, the
+// /32 for IPv6 and the host name otherwise.
+func groupKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
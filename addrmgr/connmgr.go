@@ -0,0 +1,126 @@
+package addrmgr
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"blockchain-hello-golang/peer"
+)
+
+const connectInterval = 5 * time.Second
+const saveInterval = 5 * time.Minute
+
+type Config struct {
+	// MinOutbound is the number of outbound connections to keep open.
+	MinOutbound int
+	// MaxPeers caps inbound plus outbound connections; zero means no limit.
+	MaxPeers int
+}
+
+// ConnManager keeps a PeerManager at its outbound target using addresses
+// from the book, and exchanges addr/getaddr messages with peers.
+type ConnManager struct {
+	addrs *AddrManager
+	peers *peer.PeerManager
+	cfg   Config
+	quit  chan struct{}
+}
+
+func NewConnManager(addrs *AddrManager, peers *peer.PeerManager, cfg Config) *ConnManager {
+	cm := &ConnManager{addrs: addrs, peers: peers, cfg: cfg, quit: make(chan struct{})}
+	peers.SetMaxPeers(cfg.MaxPeers)
+	peers.AddListener(cm)
+	return cm
+}
+
+func (cm *ConnManager) Start() {
+	go cm.connectLoop()
+}
+
+func (cm *ConnManager) Stop() {
+	close(cm.quit)
+	if err := cm.addrs.Save(); err != nil {
+		log.Println("Error saving addresses:", err)
+	}
+}
+
+func (cm *ConnManager) connectLoop() {
+	connectTicker := time.NewTicker(connectInterval)
+	defer connectTicker.Stop()
+	saveTicker := time.NewTicker(saveInterval)
+	defer saveTicker.Stop()
+	for {
+		cm.fillOutbound()
+		select {
+		case <-connectTicker.C:
+		case <-saveTicker.C:
+			if err := cm.addrs.Save(); err != nil {
+				log.Println("Error saving addresses:", err)
+			}
+		case <-cm.quit:
+			return
+		}
+	}
+}
+
+func (cm *ConnManager) fillOutbound() {
+	self := cm.peers.ListenAddr()
+	for tries := 0; tries < 2*cm.cfg.MinOutbound; tries++ {
+		if cm.peers.OutboundCount() >= cm.cfg.MinOutbound {
+			return
+		}
+		addr, ok := cm.addrs.GetAddress()
+		if !ok {
+			return
+		}
+		cm.addrs.Attempt(addr)
+		if _, connected := cm.peers.GetPeer(addr); connected || addr == self {
+			continue
+		}
+		if _, err := cm.peers.Connect(addr); err != nil {
+			log.Printf("Error connecting to %s: %v\n", addr, err)
+		}
+	}
+}
+
+func (cm *ConnManager) OnConnect(p *peer.Peer) {
+	if p.Inbound {
+		if addr, ok := inboundListenAddr(p.Address, p.ListenAddr()); ok {
+			cm.addrs.AddAddress(addr, p.Address)
+		}
+		return
+	}
+	cm.addrs.Good(p.Address)
+	p.Send(&peer.MsgGetAddr{})
+}
+
+func (cm *ConnManager) OnMessage(p *peer.Peer, msg peer.Message) {
+	switch m := msg.(type) {
+	case *peer.MsgGetAddr:
+		p.Send(&peer.MsgAddr{Addresses: cm.addrs.AddressCache()})
+	case *peer.MsgAddr:
+		cm.addrs.AddAddresses(m.Addresses, p.Address)
+	}
+}
+
+func (cm *ConnManager) OnDisconnect(p *peer.Peer) {}
+
+// inboundListenAddr pairs the IP an inbound connection came from with the
+// port the peer says it listens on. The host it reports is often a wildcard
+// such as [::] and cannot be dialled.
+func inboundListenAddr(remote, listen string) (string, bool) {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return "", false
+	}
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil || port == "" || port == "0" {
+		return "", false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return "", false
+	}
+	return net.JoinHostPort(ip.String(), port), true
+}
@@ -0,0 +1,26 @@
+package addrmgr
+
+import "testing"
+
+func TestInboundListenAddr(t *testing.T) {
+	tests := []struct {
+		remote, listen string
+		want           string
+		ok             bool
+	}{
+		{"203.0.113.5:51234", "[::]:9333", "203.0.113.5:9333", true},
+		{"203.0.113.5:51234", "0.0.0.0:9333", "203.0.113.5:9333", true},
+		{"[2001:db8::1]:51234", ":9333", "[2001:db8::1]:9333", true},
+		{"127.0.0.1:51234", "127.0.0.1:9333", "", false},
+		{"[::1]:51234", "[::]:9333", "", false},
+		{"0.0.0.0:51234", "[::]:9333", "", false},
+		{"203.0.113.5:51234", "", "", false},
+		{"203.0.113.5:51234", "[::]:0", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := inboundListenAddr(tt.remote, tt.listen)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("inboundListenAddr(%q, %q) = %q, %v; want %q, %v", tt.remote, tt.listen, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"blockchain-hello-golang/transaction"
 	"crypto/sha256"
 	"fmt"
-	"strconv"
 )
 
 type Block struct {
@@ -15,10 +14,10 @@ type Block struct {
 	MerkleRoot   string
 	Nonce        int
 	Hash         string
-	Difficulty   int
+	Bits         uint32
 }
 
-func calculateMerkleRoot(transactions []transaction.Transaction) string {
+func CalculateMerkleRoot(transactions []transaction.Transaction) string {
 	var txHashes []string
 	for _, tx := range transactions {
 		txHashes = append(txHashes, tx.ID)
@@ -27,6 +26,9 @@ func calculateMerkleRoot(transactions []transaction.Transaction) string {
 }
 
 func merkle(hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
 	if len(hashes) == 1 {
 		return hashes[0]
 	}
@@ -44,34 +46,18 @@ func merkle(hashes []string) string {
 	return merkle(newLevel)
 }
 
-func createBlockHeader(index int, prevHash string, merkleRoot string, timestamp int64, difficulty int, nonce int) Block {
+func createBlockHeader(index int, prevHash string, merkleRoot string, timestamp int64, bits uint32, nonce int) Block {
 	return Block{
 		Index:      index,
 		PrevHash:   prevHash,
 		Timestamp:  timestamp,
 		MerkleRoot: merkleRoot,
 		Nonce:      nonce,
-		Difficulty: difficulty,
-	}
-}
-
-func validateBlock(block, prevBlock Block) bool {
-	if block.PrevHash != prevBlock.Hash {
-		return false
-	}
-	if calculateHash(block) != block.Hash {
-		return false
-	}
-	if calculateMerkleRoot(block.Transactions) != block.MerkleRoot {
-		return false
+		Bits:       bits,
 	}
-	return true
 }
 
-func calculateHash(b Block) string {
-	record := strconv.Itoa(b.Index) + b.PrevHash + strconv.FormatInt(b.Timestamp, 10) + b.MerkleRoot + strconv.Itoa(b.Nonce)
-	h := sha256.New()
-	h.Write([]byte(record))
-	hashed := h.Sum(nil)
+func CalculateHash(b Block) string {
+	hashed := sha256.Sum256(EncodeHeader(b))
 	return fmt.Sprintf("%x", hashed)
 }
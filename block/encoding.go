@@ -0,0 +1,119 @@
+package block
+
+import (
+	"fmt"
+
+	"blockchain-hello-golang/codec"
+	"blockchain-hello-golang/transaction"
+)
+
+const EncodingVersion = 1
+
+func EncodeHeader(b Block) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeHeader(w, b)
+	return w.Bytes()
+}
+
+func DecodeHeader(data []byte) (Block, error) {
+	r := codec.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return Block{}, err
+	}
+	b, err := decodeHeader(r)
+	if err != nil {
+		return Block{}, err
+	}
+	if err := r.Done(); err != nil {
+		return Block{}, err
+	}
+	b.Hash = CalculateHash(b)
+	return b, nil
+}
+
+func Encode(b Block) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeHeader(w, b)
+	w.WriteVarInt(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		transaction.EncodeTo(w, tx)
+	}
+	return w.Bytes()
+}
+
+func Decode(data []byte) (Block, error) {
+	r := codec.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return Block{}, err
+	}
+	b, err := decodeHeader(r)
+	if err != nil {
+		return Block{}, err
+	}
+	n, err := r.ReadLength()
+	if err != nil {
+		return Block{}, err
+	}
+	b.Transactions = make([]transaction.Transaction, 0, n)
+	for i := 0; i < n; i++ {
+		tx, err := transaction.DecodeFrom(r)
+		if err != nil {
+			return Block{}, err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+	if err := r.Done(); err != nil {
+		return Block{}, err
+	}
+	b.Hash = CalculateHash(b)
+	return b, nil
+}
+
+func encodeHeader(w *codec.Writer, b Block) {
+	w.WriteUint64(uint64(b.Index))
+	w.WriteString(b.PrevHash)
+	w.WriteInt64(b.Timestamp)
+	w.WriteString(b.MerkleRoot)
+	w.WriteUint64(uint64(b.Nonce))
+	w.WriteUint32(b.Bits)
+}
+
+func decodeHeader(r *codec.Reader) (Block, error) {
+	var b Block
+	index, err := r.ReadUint64()
+	if err != nil {
+		return b, err
+	}
+	b.Index = int(index)
+	if b.PrevHash, err = r.ReadString(); err != nil {
+		return b, err
+	}
+	if b.Timestamp, err = r.ReadInt64(); err != nil {
+		return b, err
+	}
+	if b.MerkleRoot, err = r.ReadString(); err != nil {
+		return b, err
+	}
+	nonce, err := r.ReadUint64()
+	if err != nil {
+		return b, err
+	}
+	b.Nonce = int(nonce)
+	if b.Bits, err = r.ReadUint32(); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func readVersion(r *codec.Reader) error {
+	version, err := r.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if version != EncodingVersion {
+		return fmt.Errorf("block: unsupported encoding version %d", version)
+	}
+	return nil
+}
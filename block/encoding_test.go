@@ -0,0 +1,51 @@
+package block
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"blockchain-hello-golang/transaction"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	coinbase := transaction.Transaction{ID: "cb", Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 50, ScriptPubKey: "miner"}}}
+	tests := []struct {
+		name string
+		blk  Block
+	}{
+		{"genesis", Block{PrevHash: "0", Timestamp: 1700000000, Bits: 0x1f00ffff, Transactions: []transaction.Transaction{coinbase}}},
+		{"no transactions", Block{Index: 5, PrevHash: "parent", Nonce: 1 << 40, Bits: 0x1d00ffff, Transactions: []transaction.Transaction{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blk := tt.blk
+			blk.MerkleRoot = CalculateMerkleRoot(blk.Transactions)
+			blk.Hash = CalculateHash(blk)
+			data := Encode(blk)
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, blk) {
+				t.Fatalf("got %+v, want %+v", got, blk)
+			}
+			if !bytes.Equal(Encode(got), data) {
+				t.Fatal("re-encoding differs")
+			}
+			header, err := DecodeHeader(EncodeHeader(blk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Hash != blk.Hash || len(header.Transactions) != 0 {
+				t.Fatalf("header %+v", header)
+			}
+			if _, err := Decode(data[:len(data)-1]); err == nil {
+				t.Fatal("truncated block decoded")
+			}
+			if _, err := Decode(append(data, 0)); err == nil {
+				t.Fatal("trailing byte accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"blockchain-hello-golang/config"
+	"blockchain-hello-golang/rpc"
+)
+
+// jsonParams lists the parameters that are not plain strings. Everything
+// else is passed through as typed, so hashes and hex never get mangled by
+// JSON parsing.
+var jsonParams = map[string][]string{
+	"getblockhash":      {"height"},
+	"getblock":          {"verbosity"},
+	"getrawtransaction": {"verbose"},
+	"walletpassphrase":  {"timeout"},
+	"sendtoaddress":     {"amount", "fee_rate", "conf_target"},
+	"sendmany":          {"amounts", "fee_rate", "conf_target"},
+	"estimatefee":       {"nblocks"},
+}
+
+// paramNames gives the position of each named parameter for known methods,
+// so that a JSON parameter is recognised whichever way it is passed.
+var paramNames = map[string][]string{
+	"getblockhash":           {"height"},
+	"getblock":               {"blockhash", "verbosity"},
+	"getrawtransaction":      {"txid", "verbose", "blockhash"},
+	"sendrawtransaction":     {"hexstring"},
+	"addnode":                {"node", "command"},
+	"createwallet":           {"passphrase", "mnemonic", "seedpassphrase"},
+	"restorewallet":          {"backup_file"},
+	"backupwallet":           {"destination"},
+	"walletpassphrase":       {"passphrase", "timeout"},
+	"walletpassphrasechange": {"oldpassphrase", "newpassphrase"},
+	"sendtoaddress":          {"address", "amount", "fee_rate", "coin_selection", "conf_target"},
+	"sendmany":               {"amounts", "fee_rate", "coin_selection", "conf_target"},
+	"estimatefee":            {"nblocks"},
+}
+
+func main() {
+	dataDir := flag.String("datadir", config.DefaultDataDir(), "node data directory, used to find the cookie file")
+	connect := flag.String("rpcconnect", rpc.DefaultListen, "address of the node's RPC server")
+	user := flag.String("rpcuser", "", "RPC user name")
+	password := flag.String("rpcpassword", "", "RPC password")
+	cookieFile := flag.String("rpccookiefile", "", "cookie file to read credentials from (default <datadir>/.cookie)")
+	named := flag.Bool("named", false, "pass parameters by name as name=value")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <method> [params...]\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *user == "" {
+		path := *cookieFile
+		if path == "" {
+			path = rpc.CookiePath(*dataDir)
+		}
+		var err error
+		*user, *password, err = rpc.ReadCookie(path)
+		if err != nil {
+			fail(fmt.Errorf("reading cookie file (is the node running?): %w", err))
+		}
+	}
+
+	method := flag.Arg(0)
+	var params interface{}
+	var err error
+	if *named {
+		params, err = namedParams(method, flag.Args()[1:])
+	} else {
+		params, err = positionalParams(method, flag.Args()[1:])
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	result, err := rpc.NewClient(*connect, *user, *password).Call(method, params)
+	if err != nil {
+		fail(err)
+	}
+	printResult(result)
+}
+
+func positionalParams(method string, args []string) ([]interface{}, error) {
+	params := make([]interface{}, 0, len(args))
+	names := paramNames[method]
+	for i, arg := range args {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		value, err := convert(method, name, arg)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, value)
+	}
+	return params, nil
+}
+
+func namedParams(method string, args []string) (map[string]interface{}, error) {
+	params := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		name, raw, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("named parameter %q is not name=value", arg)
+		}
+		value, err := convert(method, name, raw)
+		if err != nil {
+			return nil, err
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// convert parses arg as JSON if the parameter is listed in jsonParams or
+// the method is unknown to us and arg is valid JSON; otherwise it stays a
+// string.
+func convert(method, name, arg string) (interface{}, error) {
+	isJSON := false
+	if names, known := jsonParams[method]; known {
+		for _, n := range names {
+			isJSON = isJSON || n == name
+		}
+	} else if _, known := paramNames[method]; !known {
+		isJSON = json.Valid([]byte(arg))
+	}
+	if !isJSON {
+		return arg, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(arg), &value); err != nil {
+		return nil, fmt.Errorf("parameter %s: %v", name, err)
+	}
+	return value, nil
+}
+
+// printResult prints strings bare and everything else as indented JSON.
+func printResult(result json.RawMessage) {
+	if bytes.Equal(bytes.TrimSpace(result), []byte("null")) {
+		return
+	}
+	var s string
+	if err := json.Unmarshal(result, &s); err == nil {
+		fmt.Println(s)
+		return
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		fmt.Println(string(result))
+		return
+	}
+	fmt.Println(out.String())
+}
+
+func fail(err error) {
+	var rpcErr *rpc.Error
+	if errors.As(err, &rpcErr) {
+		fmt.Fprintf(os.Stderr, "error code: %d\nerror message:\n%s\n", rpcErr.Code, rpcErr.Message)
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	os.Exit(1)
+}
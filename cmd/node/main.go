@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"blockchain-hello-golang/addrmgr"
+	"blockchain-hello-golang/config"
+	"blockchain-hello-golang/fees"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/netsync"
+	"blockchain-hello-golang/network"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/relay"
+	"blockchain-hello-golang/rpc"
+	"blockchain-hello-golang/storage"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/wallet"
+)
+
+const confName = "node.conf"
+const defaultListen = ":9333"
+const walletName = "wallet.json"
+
+func main() {
+	dataDir := flag.String("datadir", config.DefaultDataDir(), "directory for the chain, peer and ban data (command line only)")
+	confFile := flag.String("conf", "", "config file of name=value options (default <datadir>/"+confName+", command line only)")
+	listen := flag.String("listen", defaultListen, "address to accept peers on; empty disables inbound connections")
+	connect := flag.String("addnode", "", "comma-separated peer addresses to connect to and remember")
+	maxPeers := flag.Int("maxpeers", 125, "maximum number of inbound plus outbound peers")
+	minOutbound := flag.Int("outbound", 8, "number of outbound connections to maintain")
+	maxMempool := flag.Int("maxmempool", mempool.DefaultMaxSize, "mempool size limit in bytes")
+	rpcListen := flag.String("rpclisten", rpc.DefaultListen, "address of the JSON-RPC server; empty disables it")
+	rpcUser := flag.String("rpcuser", "", "RPC user name; without it a cookie file is written to the data directory")
+	rpcPassword := flag.String("rpcpassword", "", "RPC password")
+	mine := flag.Bool("mine", false, "mine blocks")
+	minerAddress := flag.String("mineraddress", "", "address the block subsidy is paid to when mining")
+	mineInterval := flag.Duration("mineinterval", 10*time.Second, "time between mined blocks")
+	walletFile := flag.String("wallet", "", "wallet file (default <datadir>/"+walletName+"); create it with the createwallet RPC")
+	disableWallet := flag.Bool("disablewallet", false, "do not load a wallet or offer the wallet RPC methods")
+	flag.Parse()
+
+	path := *confFile
+	if path == "" {
+		path = filepath.Join(*dataDir, confName)
+	}
+	if err := config.Load(flag.CommandLine, path); err != nil && (*confFile != "" || !errors.Is(err, os.ErrNotExist)) {
+		log.Fatal(err)
+	}
+	if *mine && *minerAddress == "" {
+		log.Fatal("-mine needs -mineraddress")
+	}
+	// A wallet address is paid with its script; anything else is used as
+	// the output script as given.
+	if scriptPubKey, err := wallet.DecodeAddress(*minerAddress); err == nil {
+		*minerAddress = scriptPubKey
+	}
+	if err := os.MkdirAll(*dataDir, 0o700); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := storage.Open(filepath.Join(*dataDir, "blocks"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	utxos, err := utxo.Open(filepath.Join(*dataDir, "chainstate"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := &node{utxos: utxos, pool: mempool.New(utxos, *maxMempool), quit: make(chan struct{})}
+	n.fees, err = fees.New(filepath.Join(*dataDir, "fee_estimates.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.chain, err = fork.NewChainManager(store, utxos, n.pool)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, height := n.chain.Tip(); height < 0 {
+		if _, err := n.chain.ProcessBlock(genesisBlock()); err != nil {
+			log.Fatal(fmt.Errorf("adding genesis block: %w", err))
+		}
+	}
+
+	n.peers = peer.NewPeerManager(network.TCPTransport{}, n)
+	bans, err := peer.OpenBanList(filepath.Join(*dataDir, "banlist.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	n.peers.SetBanList(bans)
+	orphans := fork.NewOrphanPool(n.chain, func(peerID, hash string) {
+		if p, ok := n.peers.GetPeer(peerID); ok {
+			p.Send(&peer.MsgGetData{Items: []peer.InvVect{{Type: peer.InvBlock, Hash: hash}}})
+		}
+	})
+	n.sync = netsync.New(n.chain, orphans)
+	n.relay = relay.New(n.peers, n, relay.Config{})
+	n.pool.OnAccept(func(tx transaction.Transaction) { n.relay.RelayTransaction(tx.ID) })
+	n.pool.OnAccept(n.observeTransaction)
+	n.chain.SetMempool(n)
+
+	addrs, err := addrmgr.New(filepath.Join(*dataDir, "peers.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	conns := addrmgr.NewConnManager(addrs, n.peers, addrmgr.Config{MinOutbound: *minOutbound, MaxPeers: *maxPeers})
+
+	walletPath := ""
+	if !*disableWallet {
+		walletPath = *walletFile
+		if walletPath == "" {
+			walletPath = filepath.Join(*dataDir, walletName)
+		}
+		w, err := wallet.Open(walletPath)
+		switch {
+		case err == nil:
+			n.setWallet(w, false)
+			log.Println("Loaded wallet", walletPath)
+		case !errors.Is(err, os.ErrNotExist):
+			log.Fatal(err)
+		}
+	}
+
+	var once sync.Once
+	shutdown := func() { once.Do(func() { close(n.quit) }) }
+	var server *rpc.Server
+	if *rpcListen != "" {
+		server = rpc.NewServer(rpc.Config{
+			Listen:     *rpcListen,
+			User:       *rpcUser,
+			Password:   *rpcPassword,
+			CookieFile: rpc.CookiePath(*dataDir),
+			Chain:      n.chain,
+			Mempool:    n.pool,
+			Peers:      n.peers,
+			Addrs:      addrs,
+			Fees:       n.fees,
+			Shutdown:   shutdown,
+			WalletPath: walletPath,
+			Wallet:     n.wallet,
+			OnWalletLoaded: func(w *wallet.Wallet) {
+				n.setWallet(w, true)
+			},
+		})
+		if err := server.Start(); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("RPC server listening on", server.Addr())
+	}
+
+	if *listen != "" {
+		if err := n.peers.Listen(*listen); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Accepting peers on", n.peers.ListenAddr())
+	}
+	n.sync.Start()
+	n.relay.Start()
+	for _, addr := range strings.Split(*connect, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs.AddAddress(addr, addr)
+			if _, err := n.peers.Connect(addr); err != nil {
+				log.Printf("Connecting to %s: %v", addr, err)
+			}
+		}
+	}
+	conns.Start()
+	if *mine {
+		go n.mine(*minerAddress, *mineInterval)
+	}
+	_, height := n.chain.Tip()
+	log.Printf("Node started at height %d", height)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		shutdown()
+	}()
+	<-n.quit
+
+	log.Println("Shutting down")
+	if server != nil {
+		server.Stop()
+	}
+	conns.Stop()
+	n.relay.Stop()
+	n.sync.Stop()
+	n.peers.Close()
+	if err := n.fees.Save(); err != nil {
+		log.Printf("Saving fee estimates: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/fees"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/mining"
+	"blockchain-hello-golang/netsync"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/relay"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/wallet"
+)
+
+// genesisTime is fixed so that every node solves the same genesis block.
+const genesisTime = 1700000000
+
+// node ties the chain, mempool and network together. It is the peer
+// handler, the relay's store and the chain's mempool updater.
+type node struct {
+	chain *fork.ChainManager
+	utxos *utxo.DB
+	pool  *mempool.Pool
+	sync  *netsync.Manager
+	peers *peer.PeerManager
+	relay *relay.Relay
+	fees  *fees.Estimator
+	quit  chan struct{}
+
+	walletMu sync.Mutex
+	wallet   *wallet.Wallet
+}
+
+func genesisBlock() block.Block {
+	coinbase := transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 0}}}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	blk := block.Block{
+		Index:        0,
+		PrevHash:     "0",
+		Timestamp:    genesisTime,
+		Transactions: []transaction.Transaction{coinbase},
+		Bits:         concensus.PowLimitBits,
+	}
+	blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+	return concensus.SolveBlock(blk)
+}
+
+func (n *node) BestHeight() int {
+	return n.sync.BestHeight()
+}
+
+func (n *node) OnConnect(p *peer.Peer) {
+	n.sync.PeerConnected(p)
+}
+
+func (n *node) OnMessage(p *peer.Peer, msg peer.Message) {
+	if m, ok := msg.(*peer.MsgTx); ok {
+		n.acceptTransaction(p, m.Tx)
+		return
+	}
+	n.sync.HandleMessage(p, msg)
+}
+
+func (n *node) OnDisconnect(p *peer.Peer) {
+	n.sync.PeerDisconnected(p)
+}
+
+func (n *node) acceptTransaction(p *peer.Peer, tx transaction.Transaction) {
+	// Missing inputs usually mean the parent has not reached us yet, so
+	// only other rejections are worth a log line.
+	err := n.pool.AddTransaction(tx)
+	if err != nil && !errors.Is(err, mempool.ErrAlreadyHave) && !errors.Is(err, mempool.ErrMissingInputs) {
+		log.Printf("Rejected transaction %s from %s: %v", tx.ID, p.ID(), err)
+	}
+}
+
+func (n *node) Have(iv peer.InvVect) bool {
+	switch iv.Type {
+	case peer.InvTx:
+		return n.pool.Has(iv.Hash)
+	case peer.InvBlock:
+		return n.chain.HaveBlock(iv.Hash)
+	}
+	return false
+}
+
+func (n *node) Get(iv peer.InvVect) (peer.Message, bool) {
+	switch iv.Type {
+	case peer.InvTx:
+		if desc, ok := n.pool.Get(iv.Hash); ok {
+			return &peer.MsgTx{Tx: desc.Tx}, true
+		}
+	case peer.InvBlock:
+		if blk, err := n.chain.GetBlock(iv.Hash); err == nil {
+			return &peer.MsgBlock{Block: blk}, true
+		}
+	}
+	return nil, false
+}
+
+// BlockConnected is called with the chain locked, so the announcement runs
+// on its own goroutine.
+func (n *node) BlockConnected(blk block.Block) {
+	n.pool.BlockConnected(blk)
+	txIDs := make([]string, len(blk.Transactions))
+	for i, tx := range blk.Transactions {
+		txIDs[i] = tx.ID
+	}
+	n.fees.BlockConnected(blk.Index, txIDs)
+	n.scanBlock(blk)
+	go n.announceBlock(blk.Hash)
+}
+
+func (n *node) BlockDisconnected(blk block.Block) {
+	n.pool.BlockDisconnected(blk)
+	if w := n.getWallet(); w != nil {
+		w.DisconnectBlock(blk, n.utxos)
+	}
+}
+
+// setWallet starts tracking w, scanning the chain for addresses that were
+// used before it was loaded.
+func (n *node) setWallet(w *wallet.Wallet, rescan bool) {
+	w.SetFeeEstimator(n.fees)
+	n.walletMu.Lock()
+	n.wallet = w
+	n.walletMu.Unlock()
+	if !rescan {
+		return
+	}
+	if err := w.Rescan(n.chain); err != nil {
+		log.Printf("Wallet rescan: %v", err)
+	}
+	if err := w.Save(); err != nil {
+		log.Printf("Saving wallet: %v", err)
+	}
+}
+
+func (n *node) getWallet() *wallet.Wallet {
+	n.walletMu.Lock()
+	defer n.walletMu.Unlock()
+	return n.wallet
+}
+
+// scanBlock records the wallet's coins created and spent by blk.
+func (n *node) scanBlock(blk block.Block) {
+	w := n.getWallet()
+	if w == nil || w.ScanBlock(blk) == 0 {
+		return
+	}
+	if err := w.Save(); err != nil {
+		log.Printf("Saving wallet: %v", err)
+	}
+}
+
+// observeTransaction times a new mempool transaction for fee estimation.
+// During initial sync the pool sees transactions long after they were sent,
+// which would make their waits meaningless.
+func (n *node) observeTransaction(tx transaction.Transaction) {
+	if n.sync.IsSyncing() {
+		return
+	}
+	if desc, ok := n.pool.Get(tx.ID); ok {
+		_, height := n.chain.Tip()
+		n.fees.ObserveTransaction(tx.ID, desc.FeeRate(), height)
+	}
+}
+
+// announceBlock tells peers about a new tip once we are caught up; during
+// initial sync they would only be flooded with blocks they already have.
+func (n *node) announceBlock(hash string) {
+	if n.sync.IsSyncing() {
+		return
+	}
+	n.relay.RelayBlock(hash)
+}
+
+// mine extends the tip with a new block every interval until quit closes.
+func (n *node) mine(address string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-ticker.C:
+		}
+		if n.sync.IsSyncing() {
+			continue
+		}
+		blk := mining.NewBlock(n.chain, n.pool, address, time.Now())
+		if _, err := n.chain.ProcessBlock(blk); err != nil {
+			log.Printf("Mined block %s rejected: %v", blk.Hash, err)
+			continue
+		}
+		log.Printf("Mined block %d %s with %d transactions", blk.Index, blk.Hash, len(blk.Transactions))
+	}
+}
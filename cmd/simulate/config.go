@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"time"
+
+	"blockchain-hello-golang/config"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/sim"
+)
+
+// Config describes one simulation run. Every field can be set with a flag
+// of the same lower-case name or from a config file.
+type Config struct {
+	Nodes         int
+	Topology      string
+	Difficulty    int
+	Blocks        int
+	Seed          int64
+	MinerFraction float64
+	MaxPeers      int
+	Outbound      int
+	// Realtime runs the goroutine simulator over the real peer, relay,
+	// address manager, chain and mempool code instead of the deterministic
+	// event simulator.
+	Realtime      bool
+	CompactBlocks bool
+	// Blocks come every few seconds in the realtime simulator instead of
+	// every ten minutes, so the transaction trickle delay is scaled down
+	// to match.
+	TrickleInterval time.Duration
+
+	// Event simulator only.
+	BlockInterval time.Duration
+	Latency       time.Duration
+	Jitter        time.Duration
+	Bandwidth     int
+	Loss          float64
+	Trace         string
+	Replay        string
+
+	bits uint32
+}
+
+var cfg Config
+
+func parseConfig(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	conf := fs.String("conf", "", "config file of name=value options")
+	fs.IntVar(&cfg.Nodes, "nodes", 100, "number of nodes")
+	fs.StringVar(&cfg.Topology, "topology", sim.TopologyRandom, "how nodes are linked: random, ring or star")
+	fs.IntVar(&cfg.Difficulty, "difficulty", 16, "leading zero bits required of block hashes")
+	fs.IntVar(&cfg.Blocks, "blocks", 4, "stop after this many blocks have been mined")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "random seed; 0 picks one from the clock")
+	fs.Float64Var(&cfg.MinerFraction, "miners", 0.5, "fraction of nodes that mine")
+	fs.IntVar(&cfg.MaxPeers, "maxpeers", 5, "maximum peers per node")
+	fs.IntVar(&cfg.Outbound, "outbound", 3, "outbound connections each node keeps")
+	fs.BoolVar(&cfg.CompactBlocks, "compact", true, "relay blocks as compact blocks")
+	fs.BoolVar(&cfg.Realtime, "realtime", false, "run the goroutine simulator in wall-clock time")
+	fs.DurationVar(&cfg.TrickleInterval, "trickle", 500*time.Millisecond, "mean delay of transaction announcements (realtime only)")
+	fs.DurationVar(&cfg.BlockInterval, "interval", 10*time.Minute, "mean virtual time between blocks")
+	fs.DurationVar(&cfg.Latency, "latency", 100*time.Millisecond, "one-way link latency")
+	fs.DurationVar(&cfg.Jitter, "jitter", 0, "maximum random extra delay per message")
+	fs.IntVar(&cfg.Bandwidth, "bandwidth", 0, "link bandwidth in bytes per second; 0 is unlimited")
+	fs.Float64Var(&cfg.Loss, "loss", 0, "probability that a message is lost")
+	fs.StringVar(&cfg.Trace, "trace", "", "write the event trace of the run to this file")
+	fs.StringVar(&cfg.Replay, "replay", "", "rerun the run recorded in this trace file and check it matches")
+	fs.Parse(args)
+	if *conf != "" {
+		if err := config.Load(fs, *conf); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Topology {
+	case sim.TopologyRandom, sim.TopologyRing, sim.TopologyStar:
+	default:
+		return fmt.Errorf("unknown topology %q", cfg.Topology)
+	}
+	if cfg.Nodes < 2 {
+		return fmt.Errorf("need at least 2 nodes, got %d", cfg.Nodes)
+	}
+	bits, err := difficultyBits(cfg.Difficulty)
+	if err != nil {
+		return err
+	}
+	cfg.bits = bits
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return nil
+}
+
+// difficultyBits converts a number of leading zero bits into the compact
+// target a block header carries.
+func difficultyBits(zeroBits int) (uint32, error) {
+	if zeroBits < 0 || zeroBits > 255 {
+		return 0, fmt.Errorf("difficulty %d out of range", zeroBits)
+	}
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-zeroBits))
+	target.Sub(target, big.NewInt(1))
+	bits := concensus.BigToCompact(target)
+	if concensus.CompactToBig(bits).Cmp(concensus.CompactToBig(concensus.PowLimitBits)) > 0 {
+		return 0, fmt.Errorf("difficulty %d is below the proof-of-work limit", zeroBits)
+	}
+	return bits, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"blockchain-hello-golang/sim"
+)
+
+// A trace file starts with the run's configuration so it can be replayed.
+const traceHeader = "# config "
+
+func simConfig() sim.Config {
+	return sim.Config{
+		Nodes:         cfg.Nodes,
+		Topology:      cfg.Topology,
+		Outbound:      cfg.Outbound,
+		MinerFraction: cfg.MinerFraction,
+		Bits:          cfg.bits,
+		Blocks:        cfg.Blocks,
+		BlockInterval: cfg.BlockInterval,
+		Seed:          cfg.Seed,
+		Link: sim.Link{
+			Latency:   cfg.Latency,
+			Jitter:    cfg.Jitter,
+			Bandwidth: cfg.Bandwidth,
+			Loss:      cfg.Loss,
+		},
+	}
+}
+
+// runEvents runs the deterministic event simulator.
+func runEvents() error {
+	simCfg := simConfig()
+	fmt.Printf("Simulating %d nodes (%s topology, difficulty %d, seed %d)\n", cfg.Nodes, cfg.Topology, cfg.Difficulty, cfg.Seed)
+	var trace io.Writer
+	if cfg.Trace != "" {
+		f, err := os.Create(cfg.Trace)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		defer w.Flush()
+		header, err := json.Marshal(simCfg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s%s\n", traceHeader, header)
+		trace = w
+	}
+	start := time.Now()
+	result, err := sim.Run(simCfg, trace)
+	if err != nil {
+		return err
+	}
+	printResult(simCfg, result, time.Since(start))
+	return nil
+}
+
+// replay reruns the configuration recorded in a trace file and compares
+// the new trace with the recorded one line by line.
+func replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), traceHeader) {
+		return fmt.Errorf("%s: not a simulator trace", path)
+	}
+	var simCfg sim.Config
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), traceHeader)), &simCfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	fmt.Printf("Replaying %s (seed %d)\n", path, simCfg.Seed)
+	check := &traceChecker{expected: scanner, line: 1}
+	start := time.Now()
+	result, err := sim.Run(simCfg, check)
+	if err != nil {
+		return err
+	}
+	if check.mismatch == "" && scanner.Scan() {
+		check.mismatch = fmt.Sprintf("line %d: recorded trace continues with %q", check.line+1, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if check.mismatch != "" {
+		return fmt.Errorf("replay diverged at %s", check.mismatch)
+	}
+	printResult(simCfg, result, time.Since(start))
+	fmt.Printf("Replay matches all %d recorded trace lines.\n", check.line-1)
+	return nil
+}
+
+// traceChecker compares trace lines against a recorded trace and keeps the
+// first difference. The simulator writes exactly one line per Write.
+type traceChecker struct {
+	expected *bufio.Scanner
+	line     int
+	mismatch string
+}
+
+func (c *traceChecker) Write(p []byte) (int, error) {
+	if c.mismatch != "" {
+		return len(p), nil
+	}
+	c.line++
+	got := strings.TrimSuffix(string(p), "\n")
+	if !c.expected.Scan() {
+		c.mismatch = fmt.Sprintf("line %d: recorded trace ended, got %q", c.line, got)
+	} else if want := c.expected.Text(); want != got {
+		c.mismatch = fmt.Sprintf("line %d:\n  recorded %s\n  got      %s", c.line, want, got)
+	}
+	return len(p), nil
+}
+
+func printResult(simCfg sim.Config, r sim.Result, wall time.Duration) {
+	fmt.Printf("Simulated %v of network time in %v (%d events)\n", r.Elapsed.Round(time.Second), wall.Round(time.Millisecond), r.Events)
+	fmt.Printf("Blocks: %d mined, %d stale, height %d, %d reorgs, converged %v\n", r.Mined, r.Stale, r.Height, r.Reorgs, r.Converged)
+	fmt.Printf("Messages lost: %d\n", r.Dropped)
+	commands := make([]string, 0, len(r.Traffic))
+	for cmd := range r.Traffic {
+		commands = append(commands, cmd)
+	}
+	sort.Strings(commands)
+	fmt.Println("Bandwidth:")
+	for _, cmd := range commands {
+		t := r.Traffic[cmd]
+		fmt.Printf("  %-12s %6d msgs %10d bytes\n", cmd, t.Messages, t.Bytes)
+	}
+	fmt.Printf("Trace digest: %s\n", r.Digest)
+}
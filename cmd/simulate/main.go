@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/mining"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/validation"
+)
+
+func logNetworkStructure() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		mu.Lock()
+		fmt.Println("Network Structure:")
+		nodeIDs := make([]int, 0, len(nodes))
+		for id := range nodes {
+			nodeIDs = append(nodeIDs, id)
+		}
+		sort.Ints(nodeIDs)
+		for _, id := range nodeIDs {
+			node := nodes[id]
+			peerIDs := []int{}
+			for _, p := range node.peers.Peers() {
+				peerIDs = append(peerIDs, nodeID(p.Address))
+			}
+			sort.Ints(peerIDs)
+			tip, height := node.chain.Tip()
+			fmt.Printf("node %d -> %v\n", id, peerIDs)
+			fmt.Printf("Tip: %d %s, transactions: %d pending\n", height, tip.Hash, node.pool.Count())
+		}
+		fmt.Println()
+		mu.Unlock()
+	}
+}
+
+// logBandwidth prints the bytes all nodes sent for block relay, so runs
+// with and without compact blocks can be compared.
+func logBandwidth() {
+	totals := make(map[string]peer.Traffic)
+	for _, node := range nodes {
+		sent, _ := node.peers.TrafficStats()
+		for cmd, t := range sent {
+			total := totals[cmd]
+			total.Messages += t.Messages
+			total.Bytes += t.Bytes
+			totals[cmd] = total
+		}
+	}
+	fmt.Printf("Bandwidth (compact blocks %v):\n", cfg.CompactBlocks)
+	blockBytes := 0
+	for _, cmd := range []string{peer.CmdBlock, peer.CmdCmpctBlock, peer.CmdGetBlkTxn, peer.CmdBlkTxn, peer.CmdInv, peer.CmdGetData, peer.CmdTx} {
+		t := totals[cmd]
+		fmt.Printf("  %-12s %6d msgs %10d bytes\n", cmd, t.Messages, t.Bytes)
+		if cmd == peer.CmdBlock || cmd == peer.CmdCmpctBlock || cmd == peer.CmdGetBlkTxn || cmd == peer.CmdBlkTxn {
+			blockBytes += t.Bytes
+		}
+	}
+	fmt.Printf("  block relay total: %d bytes\n", blockBytes)
+}
+
+var colors = []string{
+	"\033[31m", // Red
+	"\033[32m", // Green
+	"\033[33m", // Yellow
+	"\033[34m", // Blue
+	"\033[35m", // Magenta
+	"\033[36m", // Cyan
+}
+
+const resetColor = "\033[0m"
+const seedCount = 2
+
+func main() {
+	if err := parseConfig(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	var err error
+	switch {
+	case cfg.Replay != "":
+		err = replay(cfg.Replay)
+	case cfg.Realtime:
+		err = runRealtime()
+	default:
+		err = runEvents()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runRealtime runs every node on its own goroutines over a ChannelNetwork.
+// It exercises the real peer, relay, address manager, chain and mempool
+// code but, being driven by the scheduler and the wall clock, does not
+// repeat exactly.
+func runRealtime() error {
+	rand.Seed(cfg.Seed)
+	fmt.Printf("Simulating %d nodes (%s topology, difficulty %d, seed %d)\n", cfg.Nodes, cfg.Topology, cfg.Difficulty, cfg.Seed)
+	dir, err := os.MkdirTemp("", "blockchain-simulate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Initialize nodes
+	genesis := genesisBlock(cfg.bits)
+	for i := 0; i < cfg.Nodes; i++ {
+		color := colors[i%len(colors)]
+		mining := rand.Float64() < cfg.MinerFraction
+		node, err := newNode(i, color, mining, filepath.Join(dir, nodeAddress(i)), genesis)
+		if err != nil {
+			return err
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+	if err := premine(); err != nil {
+		return err
+	}
+
+	// Start the network
+	for id, node := range nodes {
+		if err := node.peers.Listen(nodeAddress(id)); err != nil {
+			return err
+		}
+	}
+	for _, node := range nodes {
+		node.seedAddresses()
+		node.conns.Start()
+		node.relay.Start()
+		go node.dropPeers()
+		go node.sendTransactions()
+		go node.mineBlocks()
+	}
+
+	// Log network structure periodically
+	go logNetworkStructure()
+
+	// Run until the target number of blocks are mined
+	for {
+		mu.Lock()
+		if minedBlocks >= cfg.Blocks {
+			mu.Unlock()
+			break
+		}
+		mu.Unlock()
+		time.Sleep(1 * time.Second)
+	}
+
+	logTips(waitForConsensus(time.Minute))
+	logBandwidth()
+	for _, node := range nodes {
+		node.conns.Stop()
+		node.relay.Stop()
+		node.peers.Close()
+	}
+	fmt.Println("Blockchain simulation complete.")
+	return nil
+}
+
+// premine gives every node a coin to spend from the start: one block pays
+// each node in turn, then enough blocks follow for the last of those
+// coinbases to mature. Node 0 mines them and the others connect the same
+// blocks in parallel.
+func premine() error {
+	count := len(nodes) + validation.CoinbaseMaturity - 1
+	fmt.Printf("Premining %d blocks\n", count)
+	blocks := make([]block.Block, count)
+	for i := range blocks {
+		blocks[i] = mining.NewBlock(nodes[0].chain, nodes[0].pool, nodes[i%len(nodes)].script, time.Now())
+		if _, err := nodes[0].chain.ProcessBlock(blocks[i]); err != nil {
+			return fmt.Errorf("premined block %d: %w", blocks[i].Index, err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for id, node := range nodes {
+		if id == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			for _, blk := range blocks {
+				if _, err := node.chain.ProcessBlock(blk); err != nil {
+					errs <- fmt.Errorf("premined block %d: %w", blk.Index, err)
+					return
+				}
+			}
+		}(node)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// waitForConsensus waits up to timeout for the last blocks to reach every
+// node and returns how many nodes are at each tip.
+func waitForConsensus(timeout time.Duration) map[string]int {
+	deadline := time.Now().Add(timeout)
+	for {
+		tips := make(map[string]int)
+		for _, node := range nodes {
+			tip, _ := node.chain.Tip()
+			tips[tip.Hash]++
+		}
+		if len(tips) == 1 || time.Now().After(deadline) {
+			return tips
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func logTips(tips map[string]int) {
+	if len(tips) == 1 {
+		for hash := range tips {
+			fmt.Printf("All nodes agree on tip %s\n", hash)
+		}
+		return
+	}
+	fmt.Println("Nodes disagree on the tip:")
+	for hash, count := range tips {
+		fmt.Printf("  %s: %d nodes\n", hash, count)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"blockchain-hello-golang/addrmgr"
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/mining"
+	"blockchain-hello-golang/network"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/relay"
+	"blockchain-hello-golang/sim"
+	"blockchain-hello-golang/storage"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var nodes = make(map[int]*Node)
+var mu sync.Mutex
+var minedBlocks = 0
+var simNetwork = network.NewChannelNetwork()
+
+// Node is a full node of the realtime simulator. Blocks go through the
+// real chain manager and orphan pool, so forks reorganize onto the most
+// work, and transactions through the real mempool. It is the peer handler,
+// the relay's store and the chain's mempool updater.
+type Node struct {
+	id      int
+	color   string
+	mining  bool
+	key     *ecdsa.PrivateKey
+	script  string
+	store   *storage.BlockStore
+	chain   *fork.ChainManager
+	orphans *fork.OrphanPool
+	pool    *mempool.Pool
+	peers   *peer.PeerManager
+	addrs   *addrmgr.AddrManager
+	conns   *addrmgr.ConnManager
+	relay   *relay.Relay
+
+	coinsMu sync.Mutex
+	coins   map[utxo.OutPoint]coin
+}
+
+// coin is an output paying the node. height is that of its block, if it
+// has one.
+type coin struct {
+	output   transaction.Output
+	coinbase bool
+	height   int
+}
+
+// newNode opens a chain under dir that starts at genesis.
+func newNode(id int, color string, mining bool, dir string, genesis block.Block) (*Node, error) {
+	key, pubKey, err := transaction.GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		id:     id,
+		color:  color,
+		mining: mining,
+		key:    key,
+		script: transaction.PayToPubKeyHash(pubKey),
+		coins:  make(map[utxo.OutPoint]coin),
+	}
+	n.peers = peer.NewPeerManager(simNetwork.Transport(nodeAddress(id)), n)
+	bans, err := peer.OpenBanList("")
+	if err != nil {
+		return nil, err
+	}
+	n.peers.SetBanList(bans)
+	n.addrs, err = addrmgr.New("")
+	if err != nil {
+		return nil, err
+	}
+	n.conns = addrmgr.NewConnManager(n.addrs, n.peers, addrmgr.Config{MinOutbound: cfg.Outbound, MaxPeers: cfg.MaxPeers})
+	var store relay.Store = n
+	if !cfg.CompactBlocks {
+		// Hide the CompactStore methods so the relay sends full blocks.
+		store = struct{ relay.Store }{n}
+	}
+	n.relay = relay.New(n.peers, store, relay.Config{TrickleInterval: cfg.TrickleInterval, Blocks: true, HighBandwidthPeers: 1})
+
+	n.store, err = storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := utxo.Open(filepath.Join(dir, "chainstate"))
+	if err != nil {
+		n.store.Close()
+		return nil, err
+	}
+	n.pool = mempool.New(utxos, mempool.DefaultMaxSize)
+	n.pool.OnAccept(func(tx transaction.Transaction) { n.scan(tx, -1) })
+	n.pool.OnAccept(func(tx transaction.Transaction) { n.relay.RelayTransaction(tx.ID) })
+	n.chain, err = fork.NewChainManager(n.store, utxos, n)
+	if err != nil {
+		n.store.Close()
+		return nil, err
+	}
+	if _, err := n.chain.ProcessBlock(genesis); err != nil {
+		n.store.Close()
+		return nil, fmt.Errorf("genesis: %w", err)
+	}
+	n.orphans = fork.NewOrphanPool(n.chain, func(peerID, hash string) {
+		if p, ok := n.peers.GetPeer(peerID); ok {
+			p.Send(&peer.MsgGetData{Items: []peer.InvVect{{Type: peer.InvBlock, Hash: hash}}})
+		}
+	})
+	return n, nil
+}
+
+// genesisBlock is the block every node starts from, at the run's
+// difficulty.
+func genesisBlock(bits uint32) block.Block {
+	coinbase := transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 0}}}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	blk := block.Block{
+		Index:        0,
+		PrevHash:     "0",
+		Timestamp:    sim.Epoch.Unix(),
+		Transactions: []transaction.Transaction{coinbase},
+		Bits:         bits,
+	}
+	blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+	return concensus.SolveBlock(blk)
+}
+
+func nodeAddress(id int) string {
+	return fmt.Sprintf("node-%d", id)
+}
+
+func nodeID(address string) int {
+	id, _ := strconv.Atoi(strings.TrimPrefix(address, "node-"))
+	return id
+}
+
+func (n *Node) log(action string) {
+	log.Printf("%s[node-%d] %s%s\n", n.color, n.id, action, resetColor)
+}
+
+func (n *Node) Close() error {
+	return n.store.Close()
+}
+
+func (n *Node) BestHeight() int {
+	_, height := n.chain.Tip()
+	return height
+}
+
+func (n *Node) OnConnect(p *peer.Peer) {
+	n.log(fmt.Sprintf("Connected to peer %d", nodeID(p.Address)))
+	// Blocks are only announced when they arrive, so a peer that is behind
+	// is told our tip and fetches what it lacks of the branch from there.
+	if tip, height := n.chain.Tip(); height > p.StartHeight() {
+		p.Send(&peer.MsgInv{Items: []peer.InvVect{{Type: peer.InvBlock, Hash: tip.Hash}}})
+	}
+}
+
+func (n *Node) OnMessage(p *peer.Peer, msg peer.Message) {
+	switch m := msg.(type) {
+	case *peer.MsgBlock:
+		n.AcceptBlock(p, m.Block)
+	case *peer.MsgTx:
+		n.acceptTransaction(p, m.Tx)
+	}
+}
+
+func (n *Node) OnDisconnect(p *peer.Peer) {
+	n.log(fmt.Sprintf("Dropped peer %d", nodeID(p.Address)))
+}
+
+func (n *Node) acceptTransaction(p *peer.Peer, tx transaction.Transaction) {
+	// Missing inputs usually mean the parent has not reached us yet.
+	err := n.pool.AddTransaction(tx)
+	if err != nil && !errors.Is(err, mempool.ErrAlreadyHave) && !errors.Is(err, mempool.ErrMissingInputs) {
+		n.log(fmt.Sprintf("Rejected transaction %s from %d: %v", tx.ID, nodeID(p.Address), err))
+	}
+}
+
+func (n *Node) Have(iv peer.InvVect) bool {
+	switch iv.Type {
+	case peer.InvTx:
+		return n.pool.Has(iv.Hash)
+	case peer.InvBlock:
+		return n.chain.HaveBlock(iv.Hash)
+	}
+	return false
+}
+
+func (n *Node) Get(iv peer.InvVect) (peer.Message, bool) {
+	switch iv.Type {
+	case peer.InvTx:
+		if desc, ok := n.pool.Get(iv.Hash); ok {
+			return &peer.MsgTx{Tx: desc.Tx}, true
+		}
+	case peer.InvBlock:
+		if blk, err := n.chain.GetBlock(iv.Hash); err == nil {
+			return &peer.MsgBlock{Block: blk}, true
+		}
+	}
+	return nil, false
+}
+
+func (n *Node) MempoolTransactions() []transaction.Transaction {
+	var txs []transaction.Transaction
+	for _, id := range n.pool.TxIDs() {
+		if desc, ok := n.pool.Get(id); ok {
+			txs = append(txs, desc.Tx)
+		}
+	}
+	return txs
+}
+
+// AcceptBlock takes blocks received in full and rebuilt from compact
+// blocks alike. One whose parent we lack waits in the orphan pool while
+// the parent is fetched from the same peer.
+func (n *Node) AcceptBlock(p *peer.Peer, blk block.Block) {
+	changed, err := n.orphans.ProcessBlock(blk, p.ID())
+	if err == nil {
+		if changed {
+			tip, height := n.chain.Tip()
+			n.log(fmt.Sprintf("New tip %d %s from %d", height, tip.Hash, nodeID(p.Address)))
+		}
+		return
+	}
+	n.log(fmt.Sprintf("Rejected block %s from %d: %v", blk.Hash, nodeID(p.Address), err))
+	if validation.IsRuleError(err) || errors.Is(err, fork.ErrInvalidBlock) {
+		p.Misbehaving(peer.BanThreshold, err.Error())
+	}
+}
+
+// BlockConnected is called with the chain locked, so the announcement runs
+// on its own goroutine.
+func (n *Node) BlockConnected(blk block.Block) {
+	n.pool.BlockConnected(blk)
+	for _, tx := range blk.Transactions {
+		n.scan(tx, blk.Index)
+	}
+	go n.relay.RelayBlock(blk.Hash)
+}
+
+// BlockDisconnected is a reorg. The block's transactions go back to the
+// mempool but its coinbase is gone.
+func (n *Node) BlockDisconnected(blk block.Block) {
+	n.pool.BlockDisconnected(blk)
+	n.coinsMu.Lock()
+	for i := range blk.Transactions[0].Outputs {
+		delete(n.coins, utxo.OutPoint{TxID: blk.Transactions[0].ID, Index: i})
+	}
+	n.coinsMu.Unlock()
+	n.log(fmt.Sprintf("Disconnected block %d %s", blk.Index, blk.Hash))
+}
+
+// scan records the coins tx spends and creates for the node. height is
+// that of the block holding tx, or -1 for the mempool.
+func (n *Node) scan(tx transaction.Transaction, height int) {
+	n.coinsMu.Lock()
+	defer n.coinsMu.Unlock()
+	for _, in := range tx.Inputs {
+		delete(n.coins, utxo.OutPoint{TxID: in.PrevTxID, Index: in.OutputIndex})
+	}
+	for i, out := range tx.Outputs {
+		if out.ScriptPubKey == n.script && out.Value > 0 {
+			n.coins[utxo.OutPoint{TxID: tx.ID, Index: i}] = coin{output: out, coinbase: validation.IsCoinbase(tx), height: height}
+		}
+	}
+}
+
+// takeCoin removes and returns a coin the next block could spend.
+func (n *Node) takeCoin() (utxo.OutPoint, coin, bool) {
+	_, height := n.chain.Tip()
+	n.coinsMu.Lock()
+	defer n.coinsMu.Unlock()
+	for op, c := range n.coins {
+		if validation.IsMature(utxo.Entry{Coinbase: c.coinbase, Height: c.height}, height+1) {
+			delete(n.coins, op)
+			return op, c, true
+		}
+	}
+	return utxo.OutPoint{}, coin{}, false
+}
+
+// seedAddresses gives a node the addresses it bootstraps from, the way a
+// real node would use its seed list; the rest arrive via addr. The topology
+// decides who seeds whom: a few random nodes, the next node in a ring or
+// node 0 as the hub of a star.
+func (n *Node) seedAddresses() {
+	switch cfg.Topology {
+	case sim.TopologyRing:
+		n.addrs.AddAddress(nodeAddress((n.id+1)%len(nodes)), "seed")
+	case sim.TopologyStar:
+		if n.id != 0 {
+			n.addrs.AddAddress(nodeAddress(0), "seed")
+		}
+	default:
+		for i := 0; i < seedCount; i++ {
+			if id := rand.Intn(len(nodes)); id != n.id {
+				n.addrs.AddAddress(nodeAddress(id), "seed")
+			}
+		}
+	}
+}
+
+func (n *Node) dropPeers() {
+	for {
+		if peers := n.peers.Peers(); len(peers) > cfg.Outbound {
+			peers[rand.Intn(len(peers))].Disconnect()
+		}
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Second)
+	}
+}
+
+// sendTransactions now and then pays part of one of the node's coins to
+// another node, with the change back to itself.
+func (n *Node) sendTransactions() {
+	for {
+		time.Sleep(time.Duration(rand.Intn(20)+10) * time.Second)
+		to := rand.Intn(len(nodes))
+		if to == n.id {
+			continue
+		}
+		op, c, ok := n.takeCoin()
+		if !ok {
+			continue
+		}
+		amount := rand.Intn(c.output.Value) + 1
+		tx := transaction.Transaction{
+			Inputs:  []transaction.Input{{PrevTxID: op.TxID, OutputIndex: op.Index}},
+			Outputs: []transaction.Output{{Value: amount, ScriptPubKey: nodes[to].script}},
+		}
+		if change := c.output.Value - amount; change > 0 {
+			tx.Outputs = append(tx.Outputs, transaction.Output{Value: change, ScriptPubKey: n.script})
+		}
+		if err := transaction.SignInput(&tx, 0, n.key, c.output, transaction.SigHashAll); err != nil {
+			n.log(fmt.Sprintf("Signing transaction: %v", err))
+			continue
+		}
+		// A coin that no longer exists, after a reorg or a double spend,
+		// stays forgotten.
+		if err := n.pool.AddTransaction(tx); err != nil {
+			n.log(fmt.Sprintf("Transaction to %d rejected: %v", to, err))
+			continue
+		}
+		n.log(fmt.Sprintf("Sent %d to node %d in %s", amount, to, tx.ID))
+	}
+}
+
+func (n *Node) mineBlocks() {
+	for n.mining {
+		time.Sleep(time.Duration(rand.Intn(30)+10) * time.Second)
+		mu.Lock()
+		done := minedBlocks >= cfg.Blocks
+		mu.Unlock()
+		if done {
+			return
+		}
+		blk := mining.NewBlock(n.chain, n.pool, n.script, time.Now())
+		if _, err := n.chain.ProcessBlock(blk); err != nil {
+			n.log(fmt.Sprintf("Mined block %s rejected: %v", blk.Hash, err))
+			continue
+		}
+		n.log(fmt.Sprintf("Mined block %d %s with %d transactions", blk.Index, blk.Hash, len(blk.Transactions)))
+		mu.Lock()
+		minedBlocks++
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const maxSliceLength = 1 << 24
+
+var ErrTrailingBytes = errors.New("codec: trailing bytes after decode")
+
+type Writer struct {
+	buf bytes.Buffer
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+func (w *Writer) WriteUint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *Writer) WriteUint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) WriteUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *Writer) WriteInt64(v int64) {
+	w.WriteUint64(uint64(v))
+}
+
+func (w *Writer) WriteVarInt(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf.Write(b[:n])
+}
+
+func (w *Writer) WriteBytes(data []byte) {
+	w.WriteVarInt(uint64(len(data)))
+	w.buf.Write(data)
+}
+
+func (w *Writer) WriteString(s string) {
+	w.WriteVarInt(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+type Reader struct {
+	r *bytes.Reader
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{r: bytes.NewReader(data)}
+}
+
+func (r *Reader) Len() int {
+	return r.r.Len()
+}
+
+func (r *Reader) ReadUint8() (uint8, error) {
+	return r.r.ReadByte()
+}
+
+func (r *Reader) ReadUint32() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func (r *Reader) ReadUint64() (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (r *Reader) ReadInt64() (int64, error) {
+	v, err := r.ReadUint64()
+	return int64(v), err
+}
+
+func (r *Reader) ReadVarInt() (uint64, error) {
+	return binary.ReadUvarint(r.r)
+}
+
+func (r *Reader) ReadLength() (int, error) {
+	n, err := r.ReadVarInt()
+	if err != nil {
+		return 0, err
+	}
+	if n > maxSliceLength || n > uint64(r.r.Len()) {
+		return 0, fmt.Errorf("codec: length %d exceeds remaining input", n)
+	}
+	return int(n), nil
+}
+
+func (r *Reader) ReadBytes() ([]byte, error) {
+	n, err := r.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *Reader) ReadString() (string, error) {
+	data, err := r.ReadBytes()
+	return string(data), err
+}
+
+func (r *Reader) Done() error {
+	if r.r.Len() != 0 {
+		return ErrTrailingBytes
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *Writer)
+		read  func(r *Reader) (interface{}, error)
+		want  interface{}
+	}{
+		{"uint8", func(w *Writer) { w.WriteUint8(0xab) },
+			func(r *Reader) (interface{}, error) { return r.ReadUint8() }, uint8(0xab)},
+		{"uint32", func(w *Writer) { w.WriteUint32(math.MaxUint32) },
+			func(r *Reader) (interface{}, error) { return r.ReadUint32() }, uint32(math.MaxUint32)},
+		{"uint64", func(w *Writer) { w.WriteUint64(math.MaxUint64) },
+			func(r *Reader) (interface{}, error) { return r.ReadUint64() }, uint64(math.MaxUint64)},
+		{"negative int64", func(w *Writer) { w.WriteInt64(-42) },
+			func(r *Reader) (interface{}, error) { return r.ReadInt64() }, int64(-42)},
+		{"small varint", func(w *Writer) { w.WriteVarInt(127) },
+			func(r *Reader) (interface{}, error) { return r.ReadVarInt() }, uint64(127)},
+		{"large varint", func(w *Writer) { w.WriteVarInt(math.MaxUint64) },
+			func(r *Reader) (interface{}, error) { return r.ReadVarInt() }, uint64(math.MaxUint64)},
+		{"string", func(w *Writer) { w.WriteString("héllo") },
+			func(r *Reader) (interface{}, error) { return r.ReadString() }, "héllo"},
+		{"empty string", func(w *Writer) { w.WriteString("") },
+			func(r *Reader) (interface{}, error) { return r.ReadString() }, ""},
+		{"bytes", func(w *Writer) { w.WriteBytes([]byte{0, 1, 2}) },
+			func(r *Reader) (interface{}, error) { b, err := r.ReadBytes(); return string(b), err }, "\x00\x01\x02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter()
+			tt.write(w)
+			data := w.Bytes()
+			r := NewReader(data)
+			got, err := tt.read(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if err := r.Done(); err != nil {
+				t.Fatal(err)
+			}
+			// Every proper prefix must fail rather than decode short.
+			for n := 0; n < len(data); n++ {
+				if _, err := tt.read(NewReader(data[:n])); err == nil {
+					t.Fatalf("decoded %d of %d bytes without error", n, len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestReadLengthBounds(t *testing.T) {
+	w := NewWriter()
+	w.WriteVarInt(5)
+	w.WriteUint32(0)
+	if _, err := NewReader(w.Bytes()).ReadLength(); err == nil {
+		t.Fatal("length past the end of input accepted")
+	}
+	w = NewWriter()
+	w.WriteVarInt(maxSliceLength + 1)
+	if _, err := NewReader(append(w.Bytes(), make([]byte, maxSliceLength+1)...)).ReadLength(); err == nil {
+		t.Fatal("length over maxSliceLength accepted")
+	}
+}
+
+func TestDoneRejectsTrailingBytes(t *testing.T) {
+	w := NewWriter()
+	w.WriteUint8(1)
+	w.WriteUint8(2)
+	r := NewReader(w.Bytes())
+	if _, err := r.ReadUint8(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Done(); !errors.Is(err, ErrTrailingBytes) {
+		t.Fatalf("Done: %v, want %v", err, ErrTrailingBytes)
+	}
+}
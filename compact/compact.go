@@ -0,0 +1,160 @@
+package compact
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/transaction"
+)
+
+var ErrBadIndex = errors.New("compact: transaction index out of range")
+var ErrShortIDCollision = errors.New("compact: duplicate short ID")
+var ErrIncomplete = errors.New("compact: block still has missing transactions")
+var ErrWrongCount = errors.New("compact: wrong number of transactions supplied")
+var ErrMerkleMismatch = errors.New("compact: reconstructed transactions do not match merkle root")
+
+const shortIDMask = 1<<(8*peer.ShortIDSize) - 1
+
+// keys derives the SipHash key from the header and the sender's nonce, so
+// an attacker cannot precompute colliding transactions for every peer.
+func keys(header block.Block, nonce uint64) (uint64, uint64) {
+	data := block.EncodeHeader(header)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], nonce)
+	sum := sha256.Sum256(append(data, buf[:]...))
+	return binary.LittleEndian.Uint64(sum[0:8]), binary.LittleEndian.Uint64(sum[8:16])
+}
+
+func shortID(k0, k1 uint64, txID string) uint64 {
+	return sipHash24(k0, k1, []byte(txID)) & shortIDMask
+}
+
+// New returns the compact form of blk, sending only the coinbase in full
+// since the receiver can never have it in its mempool.
+func New(blk block.Block, nonce uint64) *peer.MsgCmpctBlock {
+	header := blk
+	header.Transactions = nil
+	msg := &peer.MsgCmpctBlock{Header: header, Nonce: nonce}
+	k0, k1 := keys(header, nonce)
+	for i, tx := range blk.Transactions {
+		if i == 0 {
+			msg.Prefilled = append(msg.Prefilled, peer.PrefilledTx{Index: 0, Tx: tx})
+			continue
+		}
+		msg.ShortIDs = append(msg.ShortIDs, shortID(k0, k1, tx.ID))
+	}
+	return msg
+}
+
+// PartialBlock is a compact block being rebuilt.
+type PartialBlock struct {
+	header block.Block
+	txs    []*transaction.Transaction
+}
+
+// Reconstruct places the prefilled transactions and fills the remaining
+// slots from mempool by short ID. A slot matched by two mempool
+// transactions is left empty so it gets requested instead.
+func Reconstruct(msg *peer.MsgCmpctBlock, mempool []transaction.Transaction) (*PartialBlock, error) {
+	total := len(msg.ShortIDs) + len(msg.Prefilled)
+	pb := &PartialBlock{header: msg.Header, txs: make([]*transaction.Transaction, total)}
+	prefilled := make([]bool, total)
+	for _, p := range msg.Prefilled {
+		if p.Index < 0 || p.Index >= total || prefilled[p.Index] {
+			return nil, ErrBadIndex
+		}
+		tx := p.Tx
+		pb.txs[p.Index] = &tx
+		prefilled[p.Index] = true
+	}
+
+	slots := make(map[uint64]int, len(msg.ShortIDs))
+	next := 0
+	for _, id := range msg.ShortIDs {
+		for prefilled[next] {
+			next++
+		}
+		if _, dup := slots[id]; dup {
+			return nil, ErrShortIDCollision
+		}
+		slots[id] = next
+		next++
+	}
+
+	k0, k1 := keys(msg.Header, msg.Nonce)
+	collided := make(map[int]bool)
+	for i := range mempool {
+		slot, ok := slots[shortID(k0, k1, mempool[i].ID)]
+		if !ok || collided[slot] {
+			continue
+		}
+		if pb.txs[slot] != nil {
+			pb.txs[slot] = nil
+			collided[slot] = true
+			continue
+		}
+		pb.txs[slot] = &mempool[i]
+	}
+	return pb, nil
+}
+
+func (pb *PartialBlock) Hash() string {
+	return pb.header.Hash
+}
+
+// Missing lists the indexes to ask for with getblocktxn.
+func (pb *PartialBlock) Missing() []int {
+	var missing []int
+	for i, tx := range pb.txs {
+		if tx == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Fill puts txs, as returned in blocktxn, into the missing slots in order.
+func (pb *PartialBlock) Fill(txs []transaction.Transaction) error {
+	missing := pb.Missing()
+	if len(txs) != len(missing) {
+		return ErrWrongCount
+	}
+	for i, index := range missing {
+		tx := txs[i]
+		pb.txs[index] = &tx
+	}
+	return nil
+}
+
+// Block returns the rebuilt block once nothing is missing. A merkle
+// mismatch means a short ID matched the wrong mempool transaction and the
+// full block must be fetched instead.
+func (pb *PartialBlock) Block() (block.Block, error) {
+	blk := pb.header
+	blk.Transactions = make([]transaction.Transaction, 0, len(pb.txs))
+	for _, tx := range pb.txs {
+		if tx == nil {
+			return block.Block{}, ErrIncomplete
+		}
+		blk.Transactions = append(blk.Transactions, *tx)
+	}
+	if block.CalculateMerkleRoot(blk.Transactions) != blk.MerkleRoot {
+		return block.Block{}, ErrMerkleMismatch
+	}
+	return blk, nil
+}
+
+// BlockTxn answers a getblocktxn request for blk.
+func BlockTxn(blk block.Block, indexes []int) (*peer.MsgBlockTxn, error) {
+	msg := &peer.MsgBlockTxn{BlockHash: blk.Hash}
+	for _, index := range indexes {
+		if index < 0 || index >= len(blk.Transactions) {
+			return nil, ErrBadIndex
+		}
+		msg.Txs = append(msg.Txs, blk.Transactions[index])
+	}
+	return msg, nil
+}
@@ -0,0 +1,135 @@
+package compact
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/transaction"
+)
+
+const testNonce = 7
+
+func testTx(tag string, value int) transaction.Transaction {
+	tx := transaction.Transaction{
+		Inputs:  []transaction.Input{{PrevTxID: tag}},
+		Outputs: []transaction.Output{{Value: value}},
+	}
+	tx.ID = transaction.CalculateID(tx)
+	return tx
+}
+
+// testBlock returns a block with a coinbase and n other transactions.
+func testBlock(n int) block.Block {
+	coinbase := transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 50}}}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	txs := []transaction.Transaction{coinbase}
+	for i := 0; i < n; i++ {
+		txs = append(txs, testTx(fmt.Sprint("funding", i), i+1))
+	}
+	blk := block.Block{Index: 1, PrevHash: "parent", Timestamp: 1700000000, Transactions: txs}
+	blk.MerkleRoot = block.CalculateMerkleRoot(txs)
+	blk.Hash = block.CalculateHash(blk)
+	return blk
+}
+
+func TestNew(t *testing.T) {
+	blk := testBlock(3)
+	msg := New(blk, testNonce)
+	if msg.Header.Hash != blk.Hash || msg.Header.Transactions != nil {
+		t.Fatal("header not copied without its transactions")
+	}
+	if len(msg.Prefilled) != 1 || msg.Prefilled[0].Index != 0 || msg.Prefilled[0].Tx.ID != blk.Transactions[0].ID {
+		t.Fatalf("prefilled %+v, want only the coinbase", msg.Prefilled)
+	}
+	if len(msg.ShortIDs) != 3 {
+		t.Fatalf("%d short IDs, want 3", len(msg.ShortIDs))
+	}
+	if other := New(blk, testNonce+1); reflect.DeepEqual(other.ShortIDs, msg.ShortIDs) {
+		t.Fatal("short IDs do not depend on the nonce")
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	blk := testBlock(5)
+	txs := blk.Transactions
+	decoy := testTx("decoy", 1)
+	unrelated := testTx("unrelated", 1)
+	withMsg := func(mutate func(m *peer.MsgCmpctBlock)) *peer.MsgCmpctBlock {
+		m := New(blk, testNonce)
+		mutate(m)
+		return m
+	}
+	// collision announces blk with decoy's short ID at index 2, as if the
+	// two collided. The keys depend on the header only, so they match.
+	fake := blk
+	fake.Transactions = append([]transaction.Transaction(nil), txs...)
+	fake.Transactions[2] = decoy
+	collision := New(fake, testNonce)
+
+	tests := []struct {
+		name     string
+		msg      *peer.MsgCmpctBlock
+		mempool  []transaction.Transaction
+		wantErr  error
+		missing  []int
+		blockErr error
+	}{
+		{"all in mempool", New(blk, testNonce), append([]transaction.Transaction{unrelated}, txs[1:]...), nil, nil, nil},
+		{"some in mempool", New(blk, testNonce), []transaction.Transaction{txs[3], unrelated, txs[1]}, nil, []int{2, 4, 5}, nil},
+		{"empty mempool", New(blk, testNonce), nil, nil, []int{1, 2, 3, 4, 5}, nil},
+		{"slot matched twice is requested", New(blk, testNonce), append(append([]transaction.Transaction(nil), txs[1:]...), txs[3]), nil, []int{3}, nil},
+		{"short ID collision", collision, append([]transaction.Transaction{decoy}, txs[1:]...), nil, nil, ErrMerkleMismatch},
+		{"duplicate short IDs", withMsg(func(m *peer.MsgCmpctBlock) { m.ShortIDs[1] = m.ShortIDs[0] }), txs[1:], ErrShortIDCollision, nil, nil},
+		{"prefilled past the end", withMsg(func(m *peer.MsgCmpctBlock) { m.Prefilled[0].Index = 6 }), txs[1:], ErrBadIndex, nil, nil},
+		{"prefilled twice", withMsg(func(m *peer.MsgCmpctBlock) { m.Prefilled = append(m.Prefilled, m.Prefilled[0]) }), txs[1:], ErrBadIndex, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partial, err := Reconstruct(tt.msg, tt.mempool)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reconstruct: %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			missing := partial.Missing()
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Fatalf("missing %v, want %v", missing, tt.missing)
+			}
+			if len(missing) > 0 {
+				if _, err := partial.Block(); !errors.Is(err, ErrIncomplete) {
+					t.Fatalf("Block with slots missing: %v, want %v", err, ErrIncomplete)
+				}
+				if err := partial.Fill(txs[:len(missing)-1]); !errors.Is(err, ErrWrongCount) {
+					t.Fatalf("Fill with too few: %v, want %v", err, ErrWrongCount)
+				}
+				// The getblocktxn/blocktxn round trip.
+				resp, err := BlockTxn(blk, missing)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.BlockHash != blk.Hash {
+					t.Fatalf("blocktxn for %s, want %s", resp.BlockHash, blk.Hash)
+				}
+				if err := partial.Fill(resp.Txs); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := partial.Block()
+			if !errors.Is(err, tt.blockErr) {
+				t.Fatalf("Block: %v, want %v", err, tt.blockErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, blk) {
+				t.Fatal("rebuilt block differs from the original")
+			}
+		})
+	}
+
+	if _, err := BlockTxn(blk, []int{1, 6}); !errors.Is(err, ErrBadIndex) {
+		t.Fatalf("BlockTxn past the end: %v, want %v", err, ErrBadIndex)
+	}
+}
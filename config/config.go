@@ -0,0 +1,63 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dataDirName = ".blockchain-hello"
+
+var ErrUnknownOption = errors.New("config: unknown option")
+
+// DefaultDataDir is where the node keeps its chain, peers and cookie file
+// unless told otherwise.
+func DefaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return dataDirName
+	}
+	return filepath.Join(home, dataDirName)
+}
+
+// Load reads name=value lines from path and sets the flag of the same name
+// in fs, so a config file accepts exactly the command-line options. Flags
+// already given on the command line win. Blank lines and lines starting
+// with # are skipped.
+func Load(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("config: %s:%d: expected name=value", path, line)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%w %q at %s:%d", ErrUnknownOption, name, path, line)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config: %s:%d: %s: %v", path, line, name, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -1,66 +1,76 @@
 package concensus
 
 import (
-	"crypto/sha256"
-	"fmt"
-	"strconv"
+	"math/big"
 	"time"
 
 	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/transaction"
 )
 
 const targetTimePerBlock = 10 * 60 // 10 minutes
 const blocksPerAdjustment = 2016
 
-func calculateHash(b block.Block) string {
-	record := strconv.Itoa(b.Index) + b.PrevHash + strconv.FormatInt(b.Timestamp, 10) + b.MerkleRoot + strconv.Itoa(b.Nonce)
-	h := sha256.New()
-	h.Write([]byte(record))
-	hashed := h.Sum(nil)
-	return fmt.Sprintf("%x", hashed)
-}
-
-func solvePuzzle(b block.Block, difficulty int) int {
-	target := 1 << (256 - difficulty)
-	nonce := 0
-	for {
-		hash := calculateHash(b)
-		hashInt, _ := strconv.ParseUint(hash, 16, 64)
-		if hashInt < uint64(target) {
-			return nonce
-		}
-		nonce++
+// SolveBlock searches for a nonce that satisfies the header's bits and
+// returns the block with Nonce and Hash filled in.
+func SolveBlock(b block.Block) block.Block {
+	target := CompactToBig(b.Bits)
+	for nonce := 0; ; nonce++ {
 		b.Nonce = nonce
+		b.Hash = block.CalculateHash(b)
+		if hashNum, ok := HashToBig(b.Hash); ok && hashNum.Cmp(target) <= 0 {
+			return b
+		}
 	}
 }
 
-func mineBlock(transactions []block.Transaction, prevBlock block.Block, difficulty int) block.Block {
+func MineBlock(transactions []transaction.Transaction, prevBlock block.Block, bits uint32) block.Block {
 	newBlock := block.Block{
 		Index:        prevBlock.Index + 1,
 		PrevHash:     prevBlock.Hash,
 		Timestamp:    time.Now().Unix(),
 		Transactions: transactions,
-		Difficulty:   difficulty,
+		Bits:         bits,
 	}
 	newBlock.MerkleRoot = block.CalculateMerkleRoot(newBlock.Transactions)
-	newBlock.Nonce = solvePuzzle(newBlock, difficulty)
-	newBlock.Hash = calculateHash(newBlock)
-	return newBlock
+	return SolveBlock(newBlock)
 }
 
-func adjustDifficulty(chain []block.Block) int {
-	if len(chain)%blocksPerAdjustment != 0 {
-		return chain[len(chain)-1].Difficulty
+func CalcNextBits(chain []block.Block) uint32 {
+	return NextBits(chain[len(chain)-1], func(height int) (block.Block, bool) {
+		if height < 0 || height >= len(chain) {
+			return block.Block{}, false
+		}
+		return chain[height], true
+	})
+}
+
+// NextBits retargets every blocksPerAdjustment blocks so that blocks
+// arrive every targetTimePerBlock, limiting each step to a factor of four.
+// ancestor looks up headers by height on the branch that parent ends.
+func NextBits(parent block.Block, ancestor func(height int) (block.Block, bool)) uint32 {
+	height := parent.Index + 1
+	if height%blocksPerAdjustment != 0 {
+		return parent.Bits
 	}
 
-	lastAdjustmentBlock := chain[len(chain)-blocksPerAdjustment]
-	expectedTime := targetTimePerBlock * blocksPerAdjustment
-	actualTime := chain[len(chain)-1].Timestamp - lastAdjustmentBlock.Timestamp
+	lastAdjustmentBlock, ok := ancestor(height - blocksPerAdjustment)
+	if !ok {
+		return parent.Bits
+	}
+	expectedTime := int64(targetTimePerBlock * blocksPerAdjustment)
+	actualTime := parent.Timestamp - lastAdjustmentBlock.Timestamp
+	if actualTime < expectedTime/4 {
+		actualTime = expectedTime / 4
+	} else if actualTime > expectedTime*4 {
+		actualTime = expectedTime * 4
+	}
 
-	if actualTime < expectedTime/2 {
-		return lastAdjustmentBlock.Difficulty + 1
-	} else if actualTime > expectedTime*2 {
-		return lastAdjustmentBlock.Difficulty - 1
+	target := CompactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actualTime))
+	target.Div(target, big.NewInt(expectedTime))
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
 	}
-	return lastAdjustmentBlock.Difficulty
+	return BigToCompact(target)
 }
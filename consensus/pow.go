@@ -0,0 +1,101 @@
+package concensus
+
+import (
+	"errors"
+	"math/big"
+
+	"blockchain-hello-golang/block"
+)
+
+// PowLimitBits is the easiest target any block may claim. It is set low so
+// that the simulator can mine in-process without special casing.
+const PowLimitBits = 0x2000ffff
+
+var ErrBadDifficulty = errors.New("consensus: difficulty bits out of range")
+var ErrHighHash = errors.New("consensus: block hash above target")
+var ErrBadHash = errors.New("consensus: block hash does not match header")
+
+var powLimit = CompactToBig(PowLimitBits)
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// CompactToBig expands the 32-bit "bits" encoding (1 byte exponent, 3 byte
+// mantissa with a sign bit) into a 256-bit target.
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := bits & 0x007fffff
+	negative := bits&0x00800000 != 0
+	exponent := uint(bits >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if negative {
+		target.Neg(target)
+	}
+	return target
+}
+
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(target).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		shifted := new(big.Int).Rsh(new(big.Int).Abs(target), 8*(exponent-3))
+		mantissa = uint32(shifted.Uint64())
+	}
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+func HashToBig(hash string) (*big.Int, bool) {
+	return new(big.Int).SetString(hash, 16)
+}
+
+func CheckProofOfWork(header block.Block) error {
+	target := CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return ErrBadDifficulty
+	}
+	if block.CalculateHash(header) != header.Hash {
+		return ErrBadHash
+	}
+	hashNum, ok := HashToBig(header.Hash)
+	if !ok || hashNum.Cmp(target) > 0 {
+		return ErrHighHash
+	}
+	return nil
+}
+
+// CalcWork returns the expected number of hashes needed to meet bits,
+// 2^256 / (target+1).
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Div(oneLsh256, new(big.Int).Add(target, big.NewInt(1)))
+}
+
+func ChainWork(chain []block.Block) *big.Int {
+	work := big.NewInt(0)
+	for _, blk := range chain {
+		work.Add(work, CalcWork(blk.Bits))
+	}
+	return work
+}
@@ -0,0 +1,114 @@
+package concensus
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"blockchain-hello-golang/block"
+)
+
+func hexBig(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 16)
+	return n
+}
+
+func TestCompactToBig(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		want *big.Int
+	}{
+		{0x00000000, big.NewInt(0)},
+		{0x01003456, big.NewInt(0)},
+		{0x01123456, big.NewInt(0x12)},
+		{0x02008000, big.NewInt(0x80)},
+		{0x05009234, big.NewInt(0x92340000)},
+		{0x04123456, big.NewInt(0x12345600)},
+		{0x04923456, big.NewInt(-0x12345600)},
+		{0x1d00ffff, hexBig("ffff0000000000000000000000000000000000000000000000000000")},
+	}
+	for _, tt := range tests {
+		if got := CompactToBig(tt.bits); got.Cmp(tt.want) != 0 {
+			t.Errorf("CompactToBig(%#08x) = %x, want %x", tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	tests := []struct {
+		target *big.Int
+		want   uint32
+	}{
+		{big.NewInt(0), 0},
+		{big.NewInt(-1), 0x01810000},
+		{big.NewInt(0x80), 0x02008000},
+		{big.NewInt(0x12345600), 0x04123456},
+		{big.NewInt(-0x12345600), 0x04923456},
+		{hexBig("ffff0000000000000000000000000000000000000000000000000000"), 0x1d00ffff},
+	}
+	for _, tt := range tests {
+		if got := BigToCompact(tt.target); got != tt.want {
+			t.Errorf("BigToCompact(%x) = %#08x, want %#08x", tt.target, got, tt.want)
+		}
+	}
+	for _, bits := range []uint32{0x1d00ffff, 0x1b0404cb, 0x207fffff, PowLimitBits} {
+		if got := BigToCompact(CompactToBig(bits)); got != bits {
+			t.Errorf("round trip of %#08x gave %#08x", bits, got)
+		}
+	}
+}
+
+func TestCalcWork(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		want *big.Int
+	}{
+		{0x1d00ffff, big.NewInt(0x100010001)},
+		{0x04923456, big.NewInt(0)},
+		{0, big.NewInt(0)},
+	}
+	for _, tt := range tests {
+		if got := CalcWork(tt.bits); got.Cmp(tt.want) != 0 {
+			t.Errorf("CalcWork(%#08x) = %v, want %v", tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestCheckProofOfWork(t *testing.T) {
+	solved := SolveBlock(block.Block{PrevHash: "0", Timestamp: 1700000000, Bits: PowLimitBits})
+	tests := []struct {
+		name   string
+		header func() block.Block
+		want   error
+	}{
+		{"solved", func() block.Block { return solved }, nil},
+		{"easier than the limit", func() block.Block {
+			b := solved
+			b.Bits = 0x2100ffff
+			return b
+		}, ErrBadDifficulty},
+		{"negative target", func() block.Block {
+			b := solved
+			b.Bits = 0x1d80ffff
+			return b
+		}, ErrBadDifficulty},
+		{"hash does not match header", func() block.Block {
+			b := solved
+			b.Nonce++
+			return b
+		}, ErrBadHash},
+		{"hash above target", func() block.Block {
+			b := solved
+			b.Bits = 0x01010000
+			b.Hash = block.CalculateHash(b)
+			return b
+		}, ErrHighHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckProofOfWork(tt.header()); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
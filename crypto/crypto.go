@@ -1,19 +1,22 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
+	"strings"
 
 	"golang.org/x/crypto/ripemd160"
-
-	"fmt"
 )
 
+var ErrBadChecksum = errors.New("crypto: bad base58 checksum")
+
 func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -22,20 +25,45 @@ func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	return privKey, &privKey.PublicKey, nil
 }
 
+// SignMessage signs the SHA-256 of msg, always returning the low-S form of
+// the signature.
 func SignMessage(privKey *ecdsa.PrivateKey, msg []byte) (r, s *big.Int, err error) {
 	hash := sha256.Sum256(msg)
 	r, s, err = ecdsa.Sign(rand.Reader, privKey, hash[:])
 	if err != nil {
 		return nil, nil, err
 	}
+	if !IsLowS(privKey.Curve, s) {
+		s.Sub(privKey.Curve.Params().N, s)
+	}
 	return r, s, nil
 }
 
+// IsLowS reports whether s is at most half the curve order. (r, N-s) is
+// just as valid as (r, s), so only accepting one of them keeps signatures
+// from being altered by third parties.
+func IsLowS(curve elliptic.Curve, s *big.Int) bool {
+	half := new(big.Int).Rsh(curve.Params().N, 1)
+	return s.Cmp(half) <= 0
+}
+
 func VerifySignature(pubKey *ecdsa.PublicKey, msg []byte, r, s *big.Int) bool {
 	hash := sha256.Sum256(msg)
 	return ecdsa.Verify(pubKey, hash[:], r, s)
 }
 
+func MarshalPublicKey(pubKey *ecdsa.PublicKey) []byte {
+	return elliptic.Marshal(elliptic.P256(), pubKey.X, pubKey.Y)
+}
+
+func ParsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), data)
+	if x == nil {
+		return nil, fmt.Errorf("invalid public key encoding")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
 func PublicKeyToAddress(pubKey *ecdsa.PublicKey) string {
 	pubKeyBytes := append(pubKey.X.Bytes(), pubKey.Y.Bytes()...)
 	sha256Hash := sha256.Sum256(pubKeyBytes)
@@ -62,8 +90,28 @@ func EncodeBase58Check(data []byte) string {
 	return base58Encode(fullData)
 }
 
+// DecodeBase58Check reverses EncodeBase58Check, verifying the checksum.
+func DecodeBase58Check(s string) ([]byte, error) {
+	data, err := base58Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrBadChecksum
+	}
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	sum := sha256.Sum256(payload)
+	sum = sha256.Sum256(sum[:])
+	if !bytes.Equal(sum[:4], checksum) {
+		return nil, ErrBadChecksum
+	}
+	return payload, nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
 func base58Encode(data []byte) string {
-	const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	const alphabet = base58Alphabet
 	var result []byte
 	x := new(big.Int).SetBytes(data)
 	base := big.NewInt(58)
@@ -77,6 +125,22 @@ func base58Encode(data []byte) string {
 	return string(result)
 }
 
+// base58Decode mirrors base58Encode, which does not preserve leading zero
+// bytes.
+func base58Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+	base := big.NewInt(58)
+	for _, c := range []byte(s) {
+		i := strings.IndexByte(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		x.Mul(x, base)
+		x.Add(x, big.NewInt(int64(i)))
+	}
+	return x.Bytes(), nil
+}
+
 func reverse(data []byte) {
 	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
 		data[i], data[j] = data[j], data[i]
@@ -0,0 +1,241 @@
+package fees
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MaxTarget is the furthest confirmation target that can be estimated.
+const MaxTarget = 25
+
+// decay is applied to every statistic once per block so that old blocks
+// count for less; after about 350 blocks a confirmation weighs half.
+const decay = 0.998
+
+// successThreshold is the share of transactions in a range of buckets that
+// must have confirmed within the target for the range to pass.
+const successThreshold = 0.85
+
+// sufficientTxs is the (decayed) number of transactions a range of buckets
+// needs before its success rate means anything.
+const sufficientTxs = 2.0
+
+var ErrBadTarget = errors.New("fees: confirmation target out of range")
+var ErrNoEstimate = errors.New("fees: not enough data to estimate")
+
+// buckets are the lower bounds of the fee rate buckets, in fee per 1000
+// bytes, each about a quarter above the last.
+var buckets = func() []int {
+	bounds := []int{0}
+	for b := 1; b <= 10000000; {
+		bounds = append(bounds, b)
+		if next := b * 5 / 4; next > b {
+			b = next
+		} else {
+			b++
+		}
+	}
+	return bounds
+}()
+
+func bucketFor(feeRate int) int {
+	return sort.Search(len(buckets), func(i int) bool { return buckets[i] > feeRate }) - 1
+}
+
+type bucketStats struct {
+	// Confirmed[t] counts transactions confirmed within t+1 blocks.
+	Confirmed [MaxTarget]float64
+	// Total counts every transaction that left the estimator's view,
+	// confirmed or not, and FeeSum adds up their fee rates.
+	Total  float64
+	FeeSum float64
+}
+
+type pendingTx struct {
+	height int
+	rate   int
+	bucket int
+}
+
+// Estimator learns what fee rate gets a transaction confirmed within a
+// number of blocks by watching how long mempool transactions in each fee
+// rate bucket wait for a block.
+type Estimator struct {
+	mu      sync.Mutex
+	path    string
+	height  int
+	stats   []bucketStats
+	pending map[string]pendingTx
+}
+
+type savedEstimator struct {
+	Height  int
+	Buckets []int
+	Stats   []bucketStats
+}
+
+// New returns an estimator persisted at path, loading it if the file
+// exists. An empty path keeps the statistics in memory only.
+func New(path string) (*Estimator, error) {
+	e := &Estimator{path: path, height: -1, stats: make([]bucketStats, len(buckets)), pending: make(map[string]pendingTx)}
+	if path == "" {
+		return e, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved savedEstimator
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	// Statistics for a different set of buckets are of no use; start over.
+	if len(saved.Buckets) == len(buckets) && len(saved.Stats) == len(buckets) {
+		for i := range buckets {
+			if saved.Buckets[i] != buckets[i] {
+				return e, nil
+			}
+		}
+		e.height = saved.Height
+		e.stats = saved.Stats
+	}
+	return e, nil
+}
+
+// Save writes the statistics to disk. Transactions still waiting are not
+// kept; they are forgotten as if they had left the mempool.
+func (e *Estimator) Save() error {
+	if e.path == "" {
+		return nil
+	}
+	e.mu.Lock()
+	data, err := json.MarshalIndent(savedEstimator{Height: e.height, Buckets: buckets, Stats: e.stats}, "", "  ")
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
+
+// ObserveTransaction starts timing a transaction that entered the mempool
+// while the chain was at height. A negative fee rate fits no bucket and is
+// ignored.
+func (e *Estimator) ObserveTransaction(txID string, feeRate, height int) {
+	if feeRate < 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, exists := e.pending[txID]; exists {
+		return
+	}
+	e.pending[txID] = pendingTx{height: height, rate: feeRate, bucket: bucketFor(feeRate)}
+}
+
+// BlockConnected records how long the observed transactions among txIDs
+// took to confirm. Transactions that have waited longer than MaxTarget
+// count as failures for every target and are dropped, which also covers
+// ones that were evicted or replaced.
+func (e *Estimator) BlockConnected(height int, txIDs []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Blocks seen again after a reorg only resolve pending transactions;
+	// counting them twice would skew the decay.
+	newBlock := height > e.height
+	if newBlock {
+		e.height = height
+		for i := range e.stats {
+			s := &e.stats[i]
+			for t := range s.Confirmed {
+				s.Confirmed[t] *= decay
+			}
+			s.Total *= decay
+			s.FeeSum *= decay
+		}
+	}
+	for _, id := range txIDs {
+		tx, ok := e.pending[id]
+		if !ok {
+			continue
+		}
+		delete(e.pending, id)
+		if !newBlock {
+			continue
+		}
+		blocks := height - tx.height
+		if blocks < 1 {
+			blocks = 1
+		}
+		s := &e.stats[tx.bucket]
+		for t := blocks - 1; t < MaxTarget; t++ {
+			s.Confirmed[t]++
+		}
+		s.Total++
+		s.FeeSum += float64(tx.rate)
+	}
+	for id, tx := range e.pending {
+		if height-tx.height > MaxTarget {
+			delete(e.pending, id)
+			s := &e.stats[tx.bucket]
+			s.Total++
+			s.FeeSum += float64(tx.rate)
+		}
+	}
+}
+
+// EstimateFee returns the fee rate per 1000 bytes that has confirmed within
+// target blocks at least 85% of the time. Buckets are considered from the
+// highest fee rate down and merged until they hold enough transactions;
+// the answer is the average fee rate of the lowest range that still
+// passed. Transactions still waiting for longer than target count against
+// their bucket.
+func (e *Estimator) EstimateFee(target int) (int, error) {
+	if target < 1 || target > MaxTarget {
+		return 0, ErrBadTarget
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	waiting := make([]float64, len(buckets))
+	for _, tx := range e.pending {
+		if e.height-tx.height > target {
+			waiting[tx.bucket]++
+		}
+	}
+
+	var confirmed, total, resolved, feeSum float64
+	best := -1.0
+	for b := len(buckets) - 1; b >= 0; b-- {
+		s := e.stats[b]
+		confirmed += s.Confirmed[target-1]
+		total += s.Total + waiting[b]
+		resolved += s.Total
+		feeSum += s.FeeSum
+		if total < sufficientTxs {
+			continue
+		}
+		if confirmed/total < successThreshold {
+			break
+		}
+		if resolved > 0 {
+			best = feeSum / resolved
+		}
+		confirmed, total, resolved, feeSum = 0, 0, 0, 0
+	}
+	if best < 0 {
+		return 0, ErrNoEstimate
+	}
+	// Decay leaves rounding error that would otherwise push a whole average
+	// up by one.
+	return int(math.Ceil(best - 1e-6)), nil
+}
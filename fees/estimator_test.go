@@ -0,0 +1,245 @@
+package fees
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBucketFor(t *testing.T) {
+	tests := []struct {
+		rate int
+		want int
+	}{
+		{0, 0},
+		{1, 1},
+		{buckets[20] - 1, 19},
+		{buckets[20], 20},
+		{buckets[len(buckets)-1], len(buckets) - 1},
+		{math.MaxInt32, len(buckets) - 1},
+	}
+	for _, tt := range tests {
+		if got := bucketFor(tt.rate); got != tt.want {
+			t.Fatalf("bucketFor(%d) = %d, want %d", tt.rate, got, tt.want)
+		}
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			t.Fatalf("bucket %d at %d does not follow %d", i, buckets[i], buckets[i-1])
+		}
+	}
+
+	e, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ObserveTransaction("negative", -1, 0)
+	if len(e.pending) != 0 {
+		t.Fatal("negative fee rate observed")
+	}
+	e.BlockConnected(1, []string{"negative"})
+}
+
+func TestBlockConnected(t *testing.T) {
+	e, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bucketFor(1000)
+	e.ObserveTransaction("a", 1000, 10)
+	e.ObserveTransaction("slow", 1000, 10)
+	e.ObserveTransaction("stuck", 1000, 10)
+	e.BlockConnected(11, []string{"a"})
+	if s := e.stats[b]; s.Confirmed[0] != 1 || s.Confirmed[MaxTarget-1] != 1 || s.Total != 1 || s.FeeSum != 1000 {
+		t.Fatalf("after one block: %+v", s)
+	}
+
+	// slow confirms in the third block, so only counts for targets of
+	// three and up, after two rounds of decay of what was there before.
+	e.BlockConnected(12, nil)
+	e.BlockConnected(13, []string{"slow"})
+	s := e.stats[b]
+	if want := decay * decay; !near(s.Confirmed[0], want) || !near(s.Confirmed[1], want) {
+		t.Fatalf("targets 1 and 2: %v and %v, want %v", s.Confirmed[0], s.Confirmed[1], want)
+	}
+	if want := decay*decay + 1; !near(s.Confirmed[2], want) || !near(s.Total, want) {
+		t.Fatalf("target 3: %v of %v, want %v", s.Confirmed[2], s.Total, want)
+	}
+
+	// A block seen again after a reorg resolves transactions but neither
+	// counts them nor decays anything.
+	e.ObserveTransaction("reorged", 1000, 12)
+	before := e.stats[b]
+	e.BlockConnected(13, []string{"reorged"})
+	if e.stats[b] != before {
+		t.Fatalf("reconnected block changed the statistics: %+v, was %+v", e.stats[b], before)
+	}
+	if _, pending := e.pending["reorged"]; pending {
+		t.Fatal("transaction in a reconnected block still pending")
+	}
+
+	// Waiting past MaxTarget counts as a failure for every target.
+	e.BlockConnected(10+MaxTarget+1, nil)
+	if _, pending := e.pending["stuck"]; pending {
+		t.Fatal("transaction waiting past MaxTarget still pending")
+	}
+	s = e.stats[b]
+	if s.Total-s.Confirmed[MaxTarget-1] < 0.99 {
+		t.Fatalf("expired transaction not counted as a failure: %+v", s)
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// feed runs blocks 1 to n. Every block confirms a transaction paying high
+// that was sent just before it, and one paying low sent wait blocks
+// earlier.
+func feed(e *Estimator, n, high, low, wait int) {
+	due := make(map[int][]string)
+	for h := 1; h <= n; h++ {
+		hi, lo := fmt.Sprint("high", h), fmt.Sprint("low", h)
+		e.ObserveTransaction(hi, high, h-1)
+		e.ObserveTransaction(lo, low, h-1)
+		due[h] = append(due[h], hi)
+		due[h-1+wait] = append(due[h-1+wait], lo)
+		e.BlockConnected(h, due[h])
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	e, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []int{0, MaxTarget + 1} {
+		if _, err := e.EstimateFee(target); !errors.Is(err, ErrBadTarget) {
+			t.Fatalf("target %d: %v, want %v", target, err, ErrBadTarget)
+		}
+	}
+	if _, err := e.EstimateFee(1); !errors.Is(err, ErrNoEstimate) {
+		t.Fatalf("no data: %v, want %v", err, ErrNoEstimate)
+	}
+
+	feed(e, 60, 5000, 100, 10)
+	tests := []struct {
+		target int
+		want   int
+	}{
+		{1, 5000},
+		{9, 5000},
+		{10, 100},
+		{MaxTarget, 100},
+	}
+	for _, tt := range tests {
+		if got, err := e.EstimateFee(tt.target); err != nil || got != tt.want {
+			t.Fatalf("target %d: %d, %v; want %d", tt.target, got, err, tt.want)
+		}
+	}
+
+	// Low fee transactions stuck in the mempool count against their
+	// bucket even before they expire.
+	for i := 0; i < 100; i++ {
+		e.ObserveTransaction(fmt.Sprint("stuck", i), 100, 60)
+	}
+	for h := 61; h <= 72; h++ {
+		e.BlockConnected(h, []string{fmt.Sprint("low", h-9)})
+	}
+	if got, err := e.EstimateFee(10); err != nil || got != 5000 {
+		t.Fatalf("with stuck transactions: %d, %v; want 5000", got, err)
+	}
+
+	// Buckets with too few transactions to judge alone are merged and the
+	// range answers with its average fee rate.
+	e, err = New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucketFor(1000) == bucketFor(1300) {
+		t.Fatal("test rates share a bucket")
+	}
+	e.ObserveTransaction("a", 1000, 0)
+	e.ObserveTransaction("b", 1300, 0)
+	e.BlockConnected(1, []string{"a", "b"})
+	if got, err := e.EstimateFee(1); err != nil || got != 1150 {
+		t.Fatalf("merged buckets: %d, %v; want 1150", got, err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fee_estimates.json")
+	e, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed(e, 30, 5000, 100, 10)
+	if err := e.Save(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := e.EstimateFee(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.height != 30 {
+		t.Fatalf("loaded at height %d, want 30", loaded.height)
+	}
+	if got, err := loaded.EstimateFee(10); err != nil || got != want {
+		t.Fatalf("loaded estimate %d, %v; want %d", got, err, want)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		mutate func(s *savedEstimator)
+	}{
+		{"different bounds", func(s *savedEstimator) { s.Buckets[1]++ }},
+		{"fewer buckets", func(s *savedEstimator) { s.Buckets = s.Buckets[1:]; s.Stats = s.Stats[1:] }},
+		{"fewer stats", func(s *savedEstimator) { s.Stats = s.Stats[1:] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved savedEstimator
+			if err := json.Unmarshal(data, &saved); err != nil {
+				t.Fatal(err)
+			}
+			saved.Buckets = append([]int(nil), saved.Buckets...)
+			tt.mutate(&saved)
+			mismatched, err := json.Marshal(saved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, mismatched, 0644); err != nil {
+				t.Fatal(err)
+			}
+			fresh, err := New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fresh.height != -1 {
+				t.Fatalf("mismatched statistics loaded at height %d", fresh.height)
+			}
+			if _, err := fresh.EstimateFee(10); !errors.Is(err, ErrNoEstimate) {
+				t.Fatalf("estimate from mismatched statistics: %v, want %v", err, ErrNoEstimate)
+			}
+		})
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path); err == nil {
+		t.Fatal("corrupt estimates file accepted")
+	}
+}
@@ -1,62 +1,432 @@
 package fork
 
 import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
 	"blockchain-hello-golang/block"
-	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/storage"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
 )
 
-func selectLongestChain(chains [][]block.Block) []block.Block {
-	var longestChain []block.Block
-	maxPoW := 0
-	for _, chain := range chains {
-		pow := calculatePoW(chain)
-		if pow > maxPoW {
-			longestChain = chain
-			maxPoW = pow
+var ErrUnknownParent = errors.New("fork: parent block unknown")
+var ErrInvalidBlock = errors.New("fork: block previously marked invalid")
+
+type MempoolUpdater interface {
+	BlockConnected(blk block.Block)
+	BlockDisconnected(blk block.Block)
+}
+
+type blockNode struct {
+	header   block.Block
+	parent   *blockNode
+	children []*blockNode
+	// skip points further back than parent so that ancestorOf takes
+	// logarithmic steps, like bitcoind's pskip.
+	skip   *blockNode
+	height int
+	work   *big.Int
+	// seq orders nodes by arrival to break ties between equal work.
+	seq      int
+	haveData bool
+	// haveChain is set once this block and every ancestor have data.
+	haveChain bool
+	invalid   bool
+}
+
+type ChainManager struct {
+	mu    sync.Mutex
+	nodes map[string]*blockNode
+	// candidates holds the connectable nodes with at least the tip's work,
+	// the only ones that can become the next tip.
+	candidates map[*blockNode]bool
+	// bestHeader is the valid node with the most work, data or not.
+	bestHeader *blockNode
+	seq        int
+	tip        *blockNode
+	store      *storage.BlockStore
+	utxos      *utxo.DB
+	mempool    MempoolUpdater
+}
+
+func NewChainManager(store *storage.BlockStore, utxos *utxo.DB, mempool MempoolUpdater) (*ChainManager, error) {
+	cm := &ChainManager{
+		nodes:      make(map[string]*blockNode),
+		candidates: make(map[*blockNode]bool),
+		store:      store,
+		utxos:      utxos,
+		mempool:    mempool,
+	}
+	chain, err := store.BestChain()
+	if err != nil {
+		return nil, err
+	}
+	for _, blk := range chain {
+		node := cm.addNode(blk)
+		node.haveData = true
+		node.haveChain = true
+		cm.tip = node
+	}
+	if cm.tip != nil {
+		cm.candidates[cm.tip] = true
+	}
+	// The store's tip moves before the UTXO set's, so after a crash the
+	// UTXO set lags behind and the missing blocks are replayed. A UTXO set
+	// ahead of the store or off its chain, as older versions could leave
+	// behind, is first rolled back with its undo data.
+	utxoHash, utxoHeight := utxos.Tip()
+	for utxoHeight >= 0 && (utxoHeight >= len(chain) || chain[utxoHeight].Hash != utxoHash) {
+		blk, err := store.GetBlock(utxoHash)
+		if err != nil {
+			return nil, fmt.Errorf("fork: rolling back utxo tip %s: %w", utxoHash, err)
+		}
+		if err := utxos.DisconnectBlock(blk); err != nil {
+			return nil, fmt.Errorf("fork: rolling back utxo tip %s: %w", utxoHash, err)
+		}
+		utxoHash, utxoHeight = utxos.Tip()
+	}
+	for _, blk := range chain[utxoHeight+1:] {
+		if _, err := utxos.ApplyBlock(blk); err != nil {
+			return nil, fmt.Errorf("fork: replaying block %s: %w", blk.Hash, err)
 		}
 	}
-	return longestChain
+	return cm, nil
 }
 
-func calculatePoW(chain []block.Block) int {
-	pow := 0
-	for _, blk := range chain {
-		pow += blk.Difficulty
+func (cm *ChainManager) SetMempool(mempool MempoolUpdater) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.mempool = mempool
+}
+
+func (cm *ChainManager) Tip() (block.Block, int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.tip == nil {
+		return block.Block{}, -1
+	}
+	return cm.tip.header, cm.tip.height
+}
+
+func (cm *ChainManager) HaveBlock(hash string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	node, exists := cm.nodes[hash]
+	return exists && node.haveData
+}
+
+func (cm *ChainManager) HaveHeader(hash string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	_, exists := cm.nodes[hash]
+	return exists
+}
+
+// HeaderHeight returns the height of a known header that has not been
+// found invalid.
+func (cm *ChainManager) HeaderHeight(hash string) (int, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	node, exists := cm.nodes[hash]
+	if !exists || node.invalid {
+		return -1, false
 	}
-	return pow
+	return node.height, true
 }
 
-func handleOrphanedBlocks(orphanedBlocks []block.Block, chain []block.Block, utxoSet map[string]map[int]transaction.Output) []block.Block {
-	for _, blk := range orphanedBlocks {
-		if isValidBlock(blk, chain) {
-			chain = append(chain, blk)
-			updateUTXOSet(blk, utxoSet)
+func (cm *ChainManager) GetBlock(hash string) (block.Block, error) {
+	return cm.store.GetBlock(hash)
+}
+
+func (cm *ChainManager) HashAtHeight(height int) (string, bool) {
+	return cm.store.HashAtHeight(height)
+}
+
+// AddHeader records a header whose body has not been downloaded yet so
+// that its work counts towards branch selection once the data arrives.
+func (cm *ChainManager) AddHeader(header block.Block) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if _, exists := cm.nodes[header.Hash]; exists {
+		return nil
+	}
+	if err := cm.checkLink(header); err != nil {
+		return err
+	}
+	header.Transactions = nil
+	cm.addNode(header)
+	return nil
+}
+
+// ProcessBlock stores blk and, if it makes a branch heavier than the
+// current tip, reorganizes onto that branch. It reports whether the tip
+// changed.
+func (cm *ChainManager) ProcessBlock(blk block.Block) (bool, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	node, exists := cm.nodes[blk.Hash]
+	if exists && node.invalid {
+		return false, ErrInvalidBlock
+	}
+	if exists && node.haveData {
+		return false, nil
+	}
+	if err := validation.CheckBlock(blk, time.Now()); err != nil {
+		return false, err
+	}
+	if !exists {
+		if err := cm.checkLink(blk); err != nil {
+			return false, err
+		}
+		node = cm.addNode(blk)
+	}
+	if err := cm.store.PutBlock(blk); err != nil {
+		return false, err
+	}
+	cm.setHaveData(node)
+
+	best := cm.bestCandidate()
+	if best == cm.tip {
+		return false, nil
+	}
+	if err := cm.reorganize(best); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (cm *ChainManager) checkLink(header block.Block) error {
+	if err := validation.CheckBlockHeader(header, time.Now()); err != nil {
+		return err
+	}
+	parent, exists := cm.nodes[header.PrevHash]
+	if !exists {
+		if len(cm.nodes) == 0 && header.Index == 0 {
+			return nil
 		}
+		return ErrUnknownParent
 	}
-	return chain
+	if parent.invalid {
+		return ErrInvalidBlock
+	}
+	return validation.CheckBlockHeaderContext(header, parent.header, branch{parent})
 }
 
-func isValidBlock(blk block.Block, chain []block.Block) bool {
-	if len(chain) == 0 {
-		return blk.Index == 0
+func (cm *ChainManager) addNode(header block.Block) *blockNode {
+	work := blockWork(header)
+	header.Transactions = nil
+	node := &blockNode{header: header, work: work, seq: cm.seq}
+	cm.seq++
+	if parent, exists := cm.nodes[header.PrevHash]; exists {
+		node.parent = parent
+		node.height = parent.height + 1
+		node.work.Add(node.work, parent.work)
+		node.skip = ancestorOf(parent, skipHeight(node.height))
+		parent.children = append(parent.children, node)
+	}
+	cm.nodes[header.Hash] = node
+	if cm.bestHeader == nil || node.work.Cmp(cm.bestHeader.work) > 0 {
+		cm.bestHeader = node
 	}
-	prevBlock := chain[len(chain)-1]
-	return blk.PrevHash == prevBlock.Hash && block.calculateHash(blk) == blk.Hash
+	return node
 }
 
-func updateUTXOSet(blk block.Block, utxoSet map[string]map[int]transaction.Output) {
-	for _, tx := range blk.Transactions {
-		for index, output := range tx.Outputs {
-			if _, exists := utxoSet[tx.ID]; !exists {
-				utxoSet[tx.ID] = make(map[int]transaction.Output)
+// setHaveData records that node's block is stored. If that completes a
+// branch back to genesis, the node and any descendants waiting on it
+// become candidates.
+func (cm *ChainManager) setHaveData(node *blockNode) {
+	node.haveData = true
+	if node.parent != nil && !node.parent.haveChain {
+		return
+	}
+	queue := []*blockNode{node}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n.invalid {
+			continue
+		}
+		n.haveChain = true
+		if cm.tip == nil || n.work.Cmp(cm.tip.work) >= 0 {
+			cm.candidates[n] = true
+		}
+		for _, child := range n.children {
+			if child.haveData {
+				queue = append(queue, child)
 			}
-			utxoSet[tx.ID][index] = output
 		}
-		for _, input := range tx.Inputs {
-			delete(utxoSet[input.PrevTxID], input.OutputIndex)
-			if len(utxoSet[input.PrevTxID]) == 0 {
-				delete(utxoSet, input.PrevTxID)
+	}
+}
+
+// markInvalid rejects node and every block built on it. If that takes out
+// the best header, the next best is found with a full scan, which only
+// happens when a peer has sent an invalid block.
+func (cm *ChainManager) markInvalid(node *blockNode) {
+	cm.invalidate(node)
+	if cm.bestHeader == nil || !cm.bestHeader.invalid {
+		return
+	}
+	cm.bestHeader = nil
+	for _, n := range cm.nodes {
+		if n.invalid {
+			continue
+		}
+		if cm.bestHeader == nil {
+			cm.bestHeader = n
+		} else if c := n.work.Cmp(cm.bestHeader.work); c > 0 || (c == 0 && n.seq < cm.bestHeader.seq) {
+			cm.bestHeader = n
+		}
+	}
+}
+
+func (cm *ChainManager) invalidate(node *blockNode) {
+	node.invalid = true
+	delete(cm.candidates, node)
+	for _, child := range node.children {
+		cm.invalidate(child)
+	}
+}
+
+// bestCandidate returns the heaviest valid node whose whole branch has
+// block data, dropping candidates the tip has outgrown. Ties keep the
+// current tip and otherwise go to the block that arrived first, so the
+// choice never depends on map order.
+func (cm *ChainManager) bestCandidate() *blockNode {
+	best := cm.tip
+	for node := range cm.candidates {
+		if cm.tip != nil && node.work.Cmp(cm.tip.work) < 0 {
+			delete(cm.candidates, node)
+			continue
+		}
+		if best == nil {
+			best = node
+			continue
+		}
+		if c := node.work.Cmp(best.work); c > 0 || (c == 0 && best != cm.tip && node.seq < best.seq) {
+			best = node
+		}
+	}
+	return best
+}
+
+// reorganize disconnects blocks back to the fork point with newTip and then
+// connects the new branch. If a block on the new branch fails to connect
+// the previous chain is restored, and the block is marked invalid if it
+// broke a consensus rule rather than failing on a local error.
+func (cm *ChainManager) reorganize(newTip *blockNode) error {
+	forkPoint := findFork(cm.tip, newTip)
+	var attach []*blockNode
+	for n := newTip; n != forkPoint; n = n.parent {
+		attach = append([]*blockNode{n}, attach...)
+	}
+	var detach []*blockNode
+	for n := cm.tip; n != forkPoint; n = n.parent {
+		detach = append(detach, n)
+	}
+
+	for _, n := range detach {
+		if err := cm.disconnect(n); err != nil {
+			return err
+		}
+	}
+	for i, n := range attach {
+		if err := cm.connect(n); err != nil {
+			if validation.IsRuleError(err) {
+				cm.markInvalid(n)
+			}
+			for j := i - 1; j >= 0; j-- {
+				if rerr := cm.disconnect(attach[j]); rerr != nil {
+					return rerr
+				}
 			}
+			for j := len(detach) - 1; j >= 0; j-- {
+				if rerr := cm.connect(detach[j]); rerr != nil {
+					return rerr
+				}
+			}
+			return fmt.Errorf("fork: connecting block %s: %w", n.header.Hash, err)
 		}
 	}
+	return nil
+}
+
+func (cm *ChainManager) connect(node *blockNode) error {
+	blk, err := cm.store.GetBlock(node.header.Hash)
+	if err != nil {
+		return err
+	}
+	if err := validation.CheckTransactionInputs(blk, cm.utxos); err != nil {
+		return err
+	}
+	// The store's tip goes first so that a crash in between leaves the
+	// UTXO set behind, which NewChainManager replays.
+	if err := cm.store.SetTip(blk.Hash); err != nil {
+		return err
+	}
+	if _, err := cm.utxos.ApplyBlock(blk); err != nil {
+		if node.parent != nil {
+			cm.store.SetTip(node.parent.header.Hash)
+		}
+		return err
+	}
+	cm.tip = node
+	if cm.mempool != nil {
+		cm.mempool.BlockConnected(blk)
+	}
+	return nil
+}
+
+func (cm *ChainManager) disconnect(node *blockNode) error {
+	blk, err := cm.store.GetBlock(node.header.Hash)
+	if err != nil {
+		return err
+	}
+	if err := cm.utxos.DisconnectBlock(blk); err != nil {
+		return err
+	}
+	cm.tip = node.parent
+	if cm.tip != nil {
+		if err := cm.store.SetTip(cm.tip.header.Hash); err != nil {
+			return err
+		}
+	}
+	if cm.mempool != nil {
+		cm.mempool.BlockDisconnected(blk)
+	}
+	return nil
+}
+
+// branch exposes the ancestry of a node to the validation package.
+type branch struct {
+	tip *blockNode
+}
+
+func (b branch) Ancestor(height int) (block.Block, bool) {
+	if n := ancestorOf(b.tip, height); n != nil && n.height == height {
+		return n.header, true
+	}
+	return block.Block{}, false
+}
+
+func findFork(a, b *blockNode) *blockNode {
+	for a != nil && b != nil && a != b {
+		if a.height > b.height {
+			a = a.parent
+		} else {
+			b = b.parent
+		}
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return a
+}
+
+func blockWork(header block.Block) *big.Int {
+	return concensus.CalcWork(header.Bits)
 }
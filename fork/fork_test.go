@@ -0,0 +1,200 @@
+package fork
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/storage"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
+)
+
+const testEpoch = 1700000000
+
+// mine solves a block on parent paying value to miner, which also keeps
+// sibling blocks apart.
+func mine(parent block.Block, miner string, value int) block.Block {
+	coinbase := transaction.Transaction{
+		Inputs:   []transaction.Input{},
+		Outputs:  []transaction.Output{{Value: value, ScriptPubKey: miner}},
+		LockTime: int64(parent.Index),
+	}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	blk := block.Block{
+		Index:        parent.Index + 1,
+		PrevHash:     parent.Hash,
+		Timestamp:    parent.Timestamp + 60,
+		Transactions: []transaction.Transaction{coinbase},
+		Bits:         concensus.PowLimitBits,
+	}
+	blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+	return concensus.SolveBlock(blk)
+}
+
+func genesis() block.Block {
+	coinbase := transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 0}}}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	blk := block.Block{
+		PrevHash:     "0",
+		Timestamp:    testEpoch,
+		Transactions: []transaction.Transaction{coinbase},
+		Bits:         concensus.PowLimitBits,
+	}
+	blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+	return concensus.SolveBlock(blk)
+}
+
+// chainOf mines n blocks on parent.
+func chainOf(parent block.Block, miner string, n int) []block.Block {
+	var blocks []block.Block
+	for i := 0; i < n; i++ {
+		parent = mine(parent, miner, validation.BlockSubsidy(parent.Index+1))
+		blocks = append(blocks, parent)
+	}
+	return blocks
+}
+
+func newTestChain(t *testing.T, dir string) (*ChainManager, *storage.BlockStore, *utxo.DB) {
+	t.Helper()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	utxos, err := utxo.Open(filepath.Join(dir, "chainstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewChainManager(store, utxos, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cm, store, utxos
+}
+
+func TestReorganize(t *testing.T) {
+	gen := genesis()
+	a := chainOf(gen, "a", 2)
+	b := chainOf(gen, "b", 3)
+	overpaid := mine(b[0], "b", validation.BlockSubsidy(2)+1)
+
+	tests := []struct {
+		name    string
+		blocks  []block.Block
+		wantTip string
+		wantErr error
+	}{
+		{"extends", a, a[1].Hash, nil},
+		{"heavier branch wins", append(append([]block.Block{}, a...), b...), b[2].Hash, nil},
+		{"equal work keeps tip", append(append([]block.Block{}, a...), b[:2]...), a[1].Hash, nil},
+		{"lighter branch ignored", append(append([]block.Block{}, b...), a...), b[2].Hash, nil},
+		{"invalid branch rolled back", append(append([]block.Block{}, a[0]), b[0], overpaid), a[0].Hash, validation.ErrBadCoinbaseValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm, _, utxos := newTestChain(t, t.TempDir())
+			if _, err := cm.ProcessBlock(gen); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			for _, blk := range tt.blocks {
+				if _, perr := cm.ProcessBlock(blk); perr != nil {
+					err = perr
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if tip, _ := cm.Tip(); tip.Hash != tt.wantTip {
+				t.Fatalf("tip %s, want %s", tip.Hash, tt.wantTip)
+			}
+			if hash, _ := utxos.Tip(); hash != tt.wantTip {
+				t.Fatalf("utxo tip %s, want %s", hash, tt.wantTip)
+			}
+		})
+	}
+}
+
+func TestInvalidBlockRejectsDescendants(t *testing.T) {
+	gen := genesis()
+	cm, _, _ := newTestChain(t, t.TempDir())
+	a := chainOf(gen, "a", 1)
+	bad := mine(gen, "b", validation.BlockSubsidy(1)+1)
+	child := mine(bad, "b", validation.BlockSubsidy(2))
+	for _, blk := range []block.Block{gen, a[0]} {
+		if _, err := cm.ProcessBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bad ties with the tip, so it is only connected once child makes its
+	// branch heavier.
+	if _, err := cm.ProcessBlock(bad); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.ProcessBlock(child); !validation.IsRuleError(err) {
+		t.Fatalf("connecting bad branch: %v", err)
+	}
+	if _, err := cm.ProcessBlock(child); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("resubmitting child: %v, want %v", err, ErrInvalidBlock)
+	}
+}
+
+func TestTieGoesToFirstArrival(t *testing.T) {
+	gen := genesis()
+	parent := mine(gen, "p", validation.BlockSubsidy(1))
+	first := mine(parent, "first", validation.BlockSubsidy(2))
+	second := mine(parent, "second", validation.BlockSubsidy(2))
+	// Both children wait on the same parent, so they become candidates in
+	// the same step and only arrival order can separate them.
+	for i := 0; i < 20; i++ {
+		cm, _, _ := newTestChain(t, t.TempDir())
+		if _, err := cm.ProcessBlock(gen); err != nil {
+			t.Fatal(err)
+		}
+		if err := cm.AddHeader(parent); err != nil {
+			t.Fatal(err)
+		}
+		for _, blk := range []block.Block{first, second, parent} {
+			if _, err := cm.ProcessBlock(blk); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tip, _ := cm.Tip(); tip.Hash != first.Hash {
+			t.Fatalf("run %d: tip %s, want %s", i, tip.Hash, first.Hash)
+		}
+	}
+}
+
+func TestRestartRollsBackUTXOAhead(t *testing.T) {
+	dir := t.TempDir()
+	gen := genesis()
+	blocks := chainOf(gen, "a", 3)
+	cm, store, utxos := newTestChain(t, dir)
+	for _, blk := range append([]block.Block{gen}, blocks[:2]...) {
+		if _, err := cm.ProcessBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leave the UTXO set one block ahead of the store's tip.
+	if err := store.PutBlock(blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utxos.ApplyBlock(blocks[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := NewChainManager(store, utxos, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip, _ := cm.Tip(); tip.Hash != blocks[1].Hash {
+		t.Fatalf("tip %s, want %s", tip.Hash, blocks[1].Hash)
+	}
+	if hash, _ := utxos.Tip(); hash != blocks[1].Hash {
+		t.Fatalf("utxo tip %s, want %s", hash, blocks[1].Hash)
+	}
+}
@@ -0,0 +1,159 @@
+package fork
+
+import (
+	"blockchain-hello-golang/block"
+)
+
+// MissingBlock is a block on the best header chain whose data has not been
+// received.
+type MissingBlock struct {
+	Hash   string
+	Height int
+}
+
+// BestHeader returns the tip of the heaviest known header chain, which may
+// run ahead of the connected tip while block bodies are downloading.
+func (cm *ChainManager) BestHeader() (block.Block, int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	best := cm.bestHeaderNode()
+	if best == nil {
+		return block.Block{}, -1
+	}
+	return best.header, best.height
+}
+
+// bestHeaderNode prefers the connected tip when it ties with the best
+// header.
+func (cm *ChainManager) bestHeaderNode() *blockNode {
+	if cm.tip != nil && (cm.bestHeader == nil || cm.tip.work.Cmp(cm.bestHeader.work) >= 0) {
+		return cm.tip
+	}
+	return cm.bestHeader
+}
+
+// BlockLocator lists hashes from the best header back to genesis, dense near
+// the tip and exponentially sparser further back.
+func (cm *ChainManager) BlockLocator() []string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	var locator []string
+	step := 1
+	for n := cm.bestHeaderNode(); n != nil; {
+		locator = append(locator, n.header.Hash)
+		if n.height == 0 {
+			break
+		}
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		target := n.height - step
+		if target < 0 {
+			// Always end the locator with genesis.
+			target = 0
+		}
+		n = ancestorOf(n, target)
+	}
+	return locator
+}
+
+// LocateHeaders returns up to max main-chain headers following the first
+// locator hash we have on our main chain, stopping after stop.
+func (cm *ChainManager) LocateHeaders(locator []string, stop string, max int) []block.Block {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.tip == nil || max <= 0 {
+		return nil
+	}
+	start := 0
+	for _, hash := range locator {
+		if node, exists := cm.nodes[hash]; exists && cm.onMainChain(node) {
+			start = node.height + 1
+			break
+		}
+	}
+	end := start + max - 1
+	if end > cm.tip.height {
+		end = cm.tip.height
+	}
+	if end < start {
+		return nil
+	}
+	// Walk back once from the last header and reverse, rather than looking
+	// up every height from the tip.
+	headers := make([]block.Block, end-start+1)
+	for n := ancestorOf(cm.tip, end); n != nil && n.height >= start; n = n.parent {
+		headers[n.height-start] = n.header
+	}
+	for i, header := range headers {
+		if header.Hash == stop {
+			return headers[:i+1]
+		}
+	}
+	return headers
+}
+
+// MissingBlocks returns up to max blocks, lowest first, on the best header
+// chain within max blocks of where it leaves the main chain and whose data
+// has not been received.
+func (cm *ChainManager) MissingBlocks(max int) []MissingBlock {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	best := cm.bestHeaderNode()
+	if best == nil || max <= 0 {
+		return nil
+	}
+	forkHeight := -1
+	if cm.tip != nil {
+		forkHeight = findFork(cm.tip, ancestorOf(best, cm.tip.height)).height
+	}
+	top := forkHeight + max
+	if top > best.height {
+		top = best.height
+	}
+	var missing []MissingBlock
+	for n := ancestorOf(best, top); n != nil && n.height > forkHeight; n = n.parent {
+		if !n.haveData {
+			missing = append(missing, MissingBlock{Hash: n.header.Hash, Height: n.height})
+		}
+	}
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	return missing
+}
+
+func (cm *ChainManager) onMainChain(node *blockNode) bool {
+	return cm.tip != nil && node.height <= cm.tip.height && ancestorOf(cm.tip, node.height) == node
+}
+
+// ancestorOf follows skip pointers where they do not overshoot, the same
+// way bitcoind's GetAncestor does.
+func ancestorOf(node *blockNode, height int) *blockNode {
+	for node != nil && node.height > height {
+		if node.skip != nil {
+			skip, prevSkip := node.skip.height, skipHeight(node.height-1)
+			if skip == height || (skip > height && !(prevSkip < skip-2 && prevSkip >= height)) {
+				node = node.skip
+				continue
+			}
+		}
+		node = node.parent
+	}
+	return node
+}
+
+// skipHeight picks the height a node's skip pointer targets.
+func skipHeight(height int) int {
+	if height < 2 {
+		return 0
+	}
+	if height&1 != 0 {
+		return invertLowestOne(invertLowestOne(height-1)) + 1
+	}
+	return invertLowestOne(height)
+}
+
+func invertLowestOne(n int) int {
+	return n & (n - 1)
+}
@@ -0,0 +1,103 @@
+package fork
+
+import (
+	"testing"
+
+	"blockchain-hello-golang/block"
+)
+
+func TestAncestorOf(t *testing.T) {
+	gen := genesis()
+	blocks := append([]block.Block{gen}, chainOf(gen, "a", 300)...)
+	cm, _, _ := newTestChain(t, t.TempDir())
+	for _, blk := range blocks {
+		if err := cm.AddHeader(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tip := cm.nodes[blocks[len(blocks)-1].Hash]
+	for h, blk := range blocks {
+		if n := ancestorOf(tip, h); n == nil || n.header.Hash != blk.Hash {
+			t.Fatalf("ancestor at %d is wrong", h)
+		}
+		if header, ok := (branch{tip}).Ancestor(h); !ok || header.Hash != blk.Hash {
+			t.Fatalf("branch ancestor at %d is wrong", h)
+		}
+	}
+	for _, h := range []int{-1, len(blocks)} {
+		if _, ok := (branch{tip}).Ancestor(h); ok {
+			t.Fatalf("branch has an ancestor at %d", h)
+		}
+	}
+}
+
+func TestLocateHeaders(t *testing.T) {
+	gen := genesis()
+	blocks := append([]block.Block{gen}, chainOf(gen, "a", 5)...)
+	cm, _, _ := newTestChain(t, t.TempDir())
+	for _, blk := range blocks {
+		if _, err := cm.ProcessBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		locator []string
+		stop    string
+		max     int
+		want    []block.Block
+	}{
+		{"from locator", []string{"unknown", blocks[2].Hash}, "", 10, blocks[3:]},
+		{"no match starts at genesis", []string{"unknown"}, "", 10, blocks},
+		{"max", []string{blocks[2].Hash}, "", 2, blocks[3:5]},
+		{"stop", []string{blocks[2].Hash}, blocks[3].Hash, 10, blocks[3:4]},
+		{"at tip", []string{blocks[5].Hash}, "", 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cm.LocateHeaders(tt.locator, tt.stop, tt.max)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d headers, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].Hash != tt.want[i].Hash {
+					t.Fatalf("header %d is %s, want %s", i, got[i].Hash, tt.want[i].Hash)
+				}
+			}
+		})
+	}
+}
+
+func TestMissingBlocksOnBranch(t *testing.T) {
+	gen := genesis()
+	a := chainOf(gen, "a", 2)
+	b := chainOf(gen, "b", 4)
+	cm, _, _ := newTestChain(t, t.TempDir())
+	for _, blk := range append([]block.Block{gen}, a...) {
+		if _, err := cm.ProcessBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, blk := range b {
+		if err := cm.AddHeader(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b[1] has arrived but cannot connect until b[0] does.
+	if _, err := cm.ProcessBlock(b[1]); err != nil {
+		t.Fatal(err)
+	}
+	want := []MissingBlock{{b[0].Hash, 1}, {b[2].Hash, 3}, {b[3].Hash, 4}}
+	got := cm.MissingBlocks(10)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if got := cm.MissingBlocks(2); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("window of 2: got %v, want %v", got, want[:1])
+	}
+}
@@ -0,0 +1,193 @@
+package fork
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/block"
+)
+
+const maxOrphans = 100
+const maxOrphansPerPeer = 20
+const orphanExpiry = 20 * time.Minute
+
+var ErrOrphanLimit = errors.New("fork: orphan limit reached for peer")
+
+type orphan struct {
+	blk     block.Block
+	peer    string
+	expires time.Time
+}
+
+// OrphanPool holds blocks whose parent has not been seen yet, indexed by the
+// missing parent hash so they can be connected as soon as it arrives.
+type OrphanPool struct {
+	mu            sync.Mutex
+	chain         *ChainManager
+	orphans       map[string]*orphan
+	byPrev        map[string][]string
+	perPeer       map[string]int
+	requestParent func(peer string, hash string)
+	now           func() time.Time
+}
+
+func NewOrphanPool(chain *ChainManager, requestParent func(peer string, hash string)) *OrphanPool {
+	return &OrphanPool{
+		chain:         chain,
+		orphans:       make(map[string]*orphan),
+		byPrev:        make(map[string][]string),
+		perPeer:       make(map[string]int),
+		requestParent: requestParent,
+		now:           time.Now,
+	}
+}
+
+// ProcessBlock hands blk to the chain manager, parking it if its parent is
+// unknown, and then connects any orphans that were waiting on it.
+func (p *OrphanPool) ProcessBlock(blk block.Block, peer string) (bool, error) {
+	changed, err := p.chain.ProcessBlock(blk)
+	if errors.Is(err, ErrUnknownParent) {
+		if err := p.add(blk, peer); err != nil {
+			return false, err
+		}
+		if p.requestParent != nil {
+			p.requestParent(peer, p.root(blk.PrevHash))
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	queue := []string{blk.Hash}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, child := range p.takeChildren(parent) {
+			c, err := p.chain.ProcessBlock(child.blk)
+			if err != nil {
+				log.Printf("fork: rejected orphan %s from %s: %v", child.blk.Hash, child.peer, err)
+				continue
+			}
+			changed = changed || c
+			queue = append(queue, child.blk.Hash)
+		}
+	}
+	return changed, nil
+}
+
+func (p *OrphanPool) Has(hash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, exists := p.orphans[hash]
+	return exists
+}
+
+func (p *OrphanPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.orphans)
+}
+
+// RemovePeer drops every orphan announced by peer, e.g. after a disconnect.
+func (p *OrphanPool) RemovePeer(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for hash, o := range p.orphans {
+		if o.peer == peer {
+			p.remove(hash)
+		}
+	}
+}
+
+func (p *OrphanPool) add(blk block.Block, peer string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, exists := p.orphans[blk.Hash]; exists {
+		return nil
+	}
+	p.expire()
+	if p.perPeer[peer] >= maxOrphansPerPeer {
+		return ErrOrphanLimit
+	}
+	if len(p.orphans) >= maxOrphans {
+		p.evictOldest()
+	}
+	p.orphans[blk.Hash] = &orphan{blk: blk, peer: peer, expires: p.now().Add(orphanExpiry)}
+	p.byPrev[blk.PrevHash] = append(p.byPrev[blk.PrevHash], blk.Hash)
+	p.perPeer[peer]++
+	return nil
+}
+
+// root walks up through orphans already in the pool so that the parent
+// request targets the block that is actually missing.
+func (p *OrphanPool) root(hash string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		o, exists := p.orphans[hash]
+		if !exists {
+			return hash
+		}
+		hash = o.blk.PrevHash
+	}
+}
+
+func (p *OrphanPool) takeChildren(parent string) []*orphan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var children []*orphan
+	// remove rewrites byPrev[parent] in place, so walk a copy.
+	for _, hash := range append([]string(nil), p.byPrev[parent]...) {
+		if o, exists := p.orphans[hash]; exists {
+			children = append(children, o)
+			p.remove(hash)
+		}
+	}
+	return children
+}
+
+func (p *OrphanPool) remove(hash string) {
+	o, exists := p.orphans[hash]
+	if !exists {
+		return
+	}
+	delete(p.orphans, hash)
+	p.perPeer[o.peer]--
+	if p.perPeer[o.peer] <= 0 {
+		delete(p.perPeer, o.peer)
+	}
+	siblings := p.byPrev[o.blk.PrevHash]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byPrev, o.blk.PrevHash)
+	} else {
+		p.byPrev[o.blk.PrevHash] = siblings
+	}
+}
+
+func (p *OrphanPool) expire() {
+	now := p.now()
+	for hash, o := range p.orphans {
+		if now.After(o.expires) {
+			p.remove(hash)
+		}
+	}
+}
+
+func (p *OrphanPool) evictOldest() {
+	var oldest string
+	var oldestExpiry time.Time
+	for hash, o := range p.orphans {
+		if oldest == "" || o.expires.Before(oldestExpiry) {
+			oldest, oldestExpiry = hash, o.expires
+		}
+	}
+	p.remove(oldest)
+}
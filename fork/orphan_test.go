@@ -0,0 +1,94 @@
+package fork
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/validation"
+)
+
+func TestOrphanPoolConnects(t *testing.T) {
+	gen := genesis()
+	line := chainOf(gen, "a", 4)
+	parent := line[0]
+	var siblings []block.Block
+	for i := 0; i < 4; i++ {
+		siblings = append(siblings, mine(parent, fmt.Sprint("sibling", i), validation.BlockSubsidy(2)))
+	}
+
+	tests := []struct {
+		name        string
+		orphans     []block.Block
+		last        block.Block
+		wantTip     string
+		wantRequest string
+	}{
+		{"reversed chain", []block.Block{line[3], line[2], line[1]}, line[0], line[3].Hash, line[0].Hash},
+		{"siblings of one parent", siblings, parent, siblings[0].Hash, parent.Hash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm, _, _ := newTestChain(t, t.TempDir())
+			if _, err := cm.ProcessBlock(gen); err != nil {
+				t.Fatal(err)
+			}
+			var requested string
+			pool := NewOrphanPool(cm, func(peer, hash string) { requested = hash })
+			for _, blk := range tt.orphans {
+				if _, err := pool.ProcessBlock(blk, "peer"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if requested != tt.wantRequest {
+				t.Fatalf("requested %s, want %s", requested, tt.wantRequest)
+			}
+			if pool.Len() != len(tt.orphans) {
+				t.Fatalf("pool holds %d, want %d", pool.Len(), len(tt.orphans))
+			}
+			changed, err := pool.ProcessBlock(tt.last, "peer")
+			if err != nil || !changed {
+				t.Fatalf("ProcessBlock = %v, %v", changed, err)
+			}
+			if pool.Len() != 0 {
+				t.Fatalf("%d orphans left", pool.Len())
+			}
+			for _, blk := range tt.orphans {
+				if !cm.HaveBlock(blk.Hash) {
+					t.Fatalf("orphan %s not stored", blk.Hash)
+				}
+			}
+			if tip, _ := cm.Tip(); tip.Hash != tt.wantTip {
+				t.Fatalf("tip %s, want %s", tip.Hash, tt.wantTip)
+			}
+		})
+	}
+}
+
+func TestOrphanPoolLimits(t *testing.T) {
+	gen := genesis()
+	missing := mine(gen, "missing", validation.BlockSubsidy(1))
+	cm, _, _ := newTestChain(t, t.TempDir())
+	if _, err := cm.ProcessBlock(gen); err != nil {
+		t.Fatal(err)
+	}
+	pool := NewOrphanPool(cm, nil)
+	for i := 0; i <= maxOrphansPerPeer; i++ {
+		blk := mine(missing, fmt.Sprint("orphan", i), validation.BlockSubsidy(2))
+		_, err := pool.ProcessBlock(blk, "greedy")
+		if i < maxOrphansPerPeer && err != nil {
+			t.Fatalf("orphan %d: %v", i, err)
+		}
+		if i == maxOrphansPerPeer && !errors.Is(err, ErrOrphanLimit) {
+			t.Fatalf("orphan %d: %v, want %v", i, err, ErrOrphanLimit)
+		}
+	}
+	if _, err := pool.ProcessBlock(mine(missing, "other", validation.BlockSubsidy(2)), "other"); err != nil {
+		t.Fatal(err)
+	}
+	pool.RemovePeer("greedy")
+	if pool.Len() != 1 {
+		t.Fatalf("pool holds %d after RemovePeer, want 1", pool.Len())
+	}
+}
@@ -0,0 +1,453 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
+)
+
+const DefaultMaxSize = 32 << 20
+const maxAncestors = 25
+
+var (
+	ErrAlreadyHave      = errors.New("mempool: transaction already in pool")
+	ErrCoinbase         = errors.New("mempool: coinbase transactions are not relayed")
+	ErrDoubleSpend      = errors.New("mempool: input already spent by pool transaction")
+	ErrMissingInputs    = errors.New("mempool: input spends unknown output")
+	ErrNegativeFee      = errors.New("mempool: outputs exceed inputs")
+	ErrTooManyAncestors = errors.New("mempool: too many unconfirmed ancestors")
+	ErrMempoolFull      = errors.New("mempool: fee rate too low to enter full pool")
+	ErrNonFinal         = errors.New("mempool: transaction lock time not reached")
+)
+
+type UTXOView interface {
+	Get(op utxo.OutPoint) (utxo.Entry, bool)
+	Tip() (string, int)
+}
+
+type TxDesc struct {
+	Tx      transaction.Transaction
+	Fee     int
+	Size    int
+	Added   time.Time
+	parents map[string]*TxDesc
+	childs  map[string]*TxDesc
+}
+
+// FeeRate is the fee paid per 1000 bytes.
+func (d *TxDesc) FeeRate() int {
+	return FeeRate(d.Fee, d.Size)
+}
+
+func FeeRate(fee, size int) int {
+	if size == 0 {
+		return 0
+	}
+	return fee * 1000 / size
+}
+
+type Pool struct {
+	mu        sync.Mutex
+	view      UTXOView
+	maxSize   int
+	txs       map[string]*TxDesc
+	spent     map[utxo.OutPoint]string
+	totalSize int
+	listeners []func(tx transaction.Transaction)
+}
+
+func New(view UTXOView, maxSize int) *Pool {
+	return &Pool{
+		view:    view,
+		maxSize: maxSize,
+		txs:     make(map[string]*TxDesc),
+		spent:   make(map[utxo.OutPoint]string),
+	}
+}
+
+// OnAccept registers fn to be called for every transaction that enters the
+// pool, e.g. to announce it to peers.
+func (p *Pool) OnAccept(fn func(tx transaction.Transaction)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners = append(p.listeners, fn)
+}
+
+func (p *Pool) AddTransaction(tx transaction.Transaction) error {
+	p.mu.Lock()
+	desc, err := p.add(tx)
+	listeners := p.listeners
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, fn := range listeners {
+		fn(desc.Tx)
+	}
+	return nil
+}
+
+func (p *Pool) add(tx transaction.Transaction) (*TxDesc, error) {
+	if _, exists := p.txs[tx.ID]; exists {
+		return nil, ErrAlreadyHave
+	}
+	if validation.IsCoinbase(tx) {
+		return nil, ErrCoinbase
+	}
+	// Everything in the pool must be minable in the next block, which will
+	// be stamped no earlier than now.
+	if err := validation.CheckTransaction(tx); err != nil {
+		return nil, err
+	}
+	if !p.isFinal(tx) {
+		return nil, fmt.Errorf("%w: %d", ErrNonFinal, tx.LockTime)
+	}
+	desc := &TxDesc{
+		Tx:      tx,
+		Size:    len(transaction.Encode(tx)),
+		Added:   time.Now(),
+		parents: make(map[string]*TxDesc),
+		childs:  make(map[string]*TxDesc),
+	}
+	inputSum := 0
+	for i, input := range tx.Inputs {
+		op := utxo.OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex}
+		if spender, exists := p.spent[op]; exists {
+			return nil, fmt.Errorf("%w: %s:%d by %s", ErrDoubleSpend, op.TxID, op.Index, spender)
+		}
+		output, parent, err := p.lookup(op)
+		if err != nil {
+			return nil, err
+		}
+		if err := transaction.VerifyInput(tx, i, output); err != nil {
+			return nil, err
+		}
+		if parent != nil {
+			desc.parents[parent.Tx.ID] = parent
+		}
+		inputSum += output.Value
+		if !transaction.MoneyRange(inputSum) {
+			return nil, fmt.Errorf("%w: inputs total more than %d", validation.ErrBadOutputValue, transaction.MaxMoney)
+		}
+	}
+	outputSum := 0
+	for _, output := range tx.Outputs {
+		outputSum += output.Value
+	}
+	if outputSum > inputSum {
+		return nil, ErrNegativeFee
+	}
+	desc.Fee = inputSum - outputSum
+	if len(ancestors(desc)) > maxAncestors {
+		return nil, ErrTooManyAncestors
+	}
+
+	p.txs[tx.ID] = desc
+	p.totalSize += desc.Size
+	for _, input := range tx.Inputs {
+		p.spent[utxo.OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex}] = tx.ID
+	}
+	for _, parent := range desc.parents {
+		parent.childs[tx.ID] = desc
+	}
+	p.trim()
+	if _, exists := p.txs[tx.ID]; !exists {
+		return nil, ErrMempoolFull
+	}
+	return desc, nil
+}
+
+func (p *Pool) isFinal(tx transaction.Transaction) bool {
+	_, height := p.view.Tip()
+	return transaction.IsFinal(tx, height+1, time.Now().Unix())
+}
+
+func (p *Pool) lookup(op utxo.OutPoint) (transaction.Output, *TxDesc, error) {
+	if parent, exists := p.txs[op.TxID]; exists {
+		if op.Index < 0 || op.Index >= len(parent.Tx.Outputs) {
+			return transaction.Output{}, nil, ErrMissingInputs
+		}
+		return parent.Tx.Outputs[op.Index], parent, nil
+	}
+	entry, exists := p.view.Get(op)
+	if !exists {
+		return transaction.Output{}, nil, fmt.Errorf("%w: %s:%d", ErrMissingInputs, op.TxID, op.Index)
+	}
+	if _, height := p.view.Tip(); !validation.IsMature(entry, height+1) {
+		return transaction.Output{}, nil, fmt.Errorf("%w: %s:%d", validation.ErrImmatureSpend, op.TxID, op.Index)
+	}
+	return entry.Output, nil, nil
+}
+
+// trim evicts the package with the lowest descendant fee rate until the pool
+// fits in maxSize again.
+func (p *Pool) trim() {
+	for p.totalSize > p.maxSize && len(p.txs) > 0 {
+		var worst *TxDesc
+		worstRate := 0
+		for _, desc := range p.txs {
+			fee, size := desc.Fee, desc.Size
+			for _, d := range descendants(desc) {
+				fee += d.Fee
+				size += d.Size
+			}
+			if rate := FeeRate(fee, size); worst == nil || rate < worstRate {
+				worst, worstRate = desc, rate
+			}
+		}
+		p.removeWithDescendants(worst)
+	}
+}
+
+func (p *Pool) Remove(txID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if desc, exists := p.txs[txID]; exists {
+		p.removeWithDescendants(desc)
+	}
+}
+
+func (p *Pool) removeWithDescendants(desc *TxDesc) {
+	for _, d := range descendants(desc) {
+		p.remove(d)
+	}
+	p.remove(desc)
+}
+
+func (p *Pool) remove(desc *TxDesc) {
+	if _, exists := p.txs[desc.Tx.ID]; !exists {
+		return
+	}
+	delete(p.txs, desc.Tx.ID)
+	p.totalSize -= desc.Size
+	for _, input := range desc.Tx.Inputs {
+		delete(p.spent, utxo.OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex})
+	}
+	for _, parent := range desc.parents {
+		delete(parent.childs, desc.Tx.ID)
+	}
+	for _, child := range desc.childs {
+		delete(child.parents, desc.Tx.ID)
+	}
+}
+
+// BlockConnected drops transactions confirmed by blk along with anything in
+// the pool that conflicts with them.
+func (p *Pool) BlockConnected(blk block.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		if desc, exists := p.txs[tx.ID]; exists {
+			p.remove(desc)
+			continue
+		}
+		for _, input := range tx.Inputs {
+			op := utxo.OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex}
+			if spender, exists := p.spent[op]; exists {
+				p.removeWithDescendants(p.txs[spender])
+			}
+		}
+	}
+}
+
+// BlockDisconnected returns the block's transactions to the pool. Ones that
+// no longer validate are dropped, and so are pool transactions whose lock
+// time the shorter chain has not reached yet or whose inputs are gone.
+func (p *Pool) BlockDisconnected(blk block.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		if !validation.IsCoinbase(tx) {
+			p.add(tx)
+		}
+	}
+	p.relink()
+	for _, desc := range p.txs {
+		if !p.isFinal(desc.Tx) {
+			p.removeWithDescendants(desc)
+		}
+	}
+}
+
+// relink rebuilds the links between pool transactions once a reorg has
+// moved the outputs they spend from the chain back into the pool, and
+// evicts those spending outputs that are gone or coinbases not yet mature.
+func (p *Pool) relink() {
+	for _, desc := range p.txs {
+		desc.parents = make(map[string]*TxDesc)
+		desc.childs = make(map[string]*TxDesc)
+	}
+	var orphans []*TxDesc
+	for _, desc := range p.txs {
+		for _, input := range desc.Tx.Inputs {
+			_, parent, err := p.lookup(utxo.OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex})
+			if err != nil {
+				orphans = append(orphans, desc)
+				break
+			}
+			if parent != nil {
+				desc.parents[parent.Tx.ID] = parent
+				parent.childs[desc.Tx.ID] = desc
+			}
+		}
+	}
+	for _, desc := range orphans {
+		p.removeWithDescendants(desc)
+	}
+}
+
+func (p *Pool) Has(txID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, exists := p.txs[txID]
+	return exists
+}
+
+// IsSpent reports whether a pool transaction spends op.
+func (p *Pool) IsSpent(op utxo.OutPoint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, spent := p.spent[op]
+	return spent
+}
+
+func (p *Pool) Get(txID string) (TxDesc, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	desc, exists := p.txs[txID]
+	if !exists {
+		return TxDesc{}, false
+	}
+	return *desc, true
+}
+
+func (p *Pool) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.txs)
+}
+
+func (p *Pool) Bytes() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.totalSize
+}
+
+func (p *Pool) MaxSize() int {
+	return p.maxSize
+}
+
+func (p *Pool) TxIDs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.txs))
+	for id := range p.txs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SelectTransactions picks transactions for a block template, best ancestor
+// package fee rate first, always placing parents before their children. It
+// also returns the fees they pay.
+func (p *Pool) SelectTransactions(maxBytes int) ([]transaction.Transaction, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	type candidate struct {
+		desc *TxDesc
+		rate int
+	}
+	candidates := make([]candidate, 0, len(p.txs))
+	for _, desc := range p.txs {
+		fee, size := desc.Fee, desc.Size
+		for _, a := range ancestors(desc) {
+			fee += a.Fee
+			size += a.Size
+		}
+		candidates = append(candidates, candidate{desc: desc, rate: FeeRate(fee, size)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].rate != candidates[j].rate {
+			return candidates[i].rate > candidates[j].rate
+		}
+		return candidates[i].desc.Tx.ID < candidates[j].desc.Tx.ID
+	})
+
+	included := make(map[string]bool)
+	var selected []transaction.Transaction
+	size, fees := 0, 0
+	for _, c := range candidates {
+		if included[c.desc.Tx.ID] {
+			continue
+		}
+		var pkg []*TxDesc
+		pkgSize := 0
+		for _, a := range ancestors(c.desc) {
+			if !included[a.Tx.ID] {
+				pkg = append(pkg, a)
+				pkgSize += a.Size
+			}
+		}
+		pkg = append(pkg, c.desc)
+		pkgSize += c.desc.Size
+		if size+pkgSize > maxBytes {
+			continue
+		}
+		for _, d := range pkg {
+			included[d.Tx.ID] = true
+			selected = append(selected, d.Tx)
+			fees += d.Fee
+		}
+		size += pkgSize
+	}
+	return selected, fees
+}
+
+// ancestors returns the in-pool ancestors of desc, parents before children.
+func ancestors(desc *TxDesc) []*TxDesc {
+	var result []*TxDesc
+	seen := make(map[string]bool)
+	var visit func(d *TxDesc)
+	visit = func(d *TxDesc) {
+		ids := make([]string, 0, len(d.parents))
+		for id := range d.parents {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				visit(d.parents[id])
+				result = append(result, d.parents[id])
+			}
+		}
+	}
+	visit(desc)
+	return result
+}
+
+func descendants(desc *TxDesc) []*TxDesc {
+	var result []*TxDesc
+	seen := make(map[string]bool)
+	queue := []*TxDesc{desc}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		for id, child := range d.childs {
+			if !seen[id] {
+				seen[id] = true
+				result = append(result, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return result
+}
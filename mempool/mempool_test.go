@@ -0,0 +1,357 @@
+package mempool
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
+)
+
+type testView struct {
+	coins  map[utxo.OutPoint]utxo.Entry
+	height int
+}
+
+func (v *testView) Get(op utxo.OutPoint) (utxo.Entry, bool) {
+	entry, ok := v.coins[op]
+	return entry, ok
+}
+
+func (v *testView) Tip() (string, int) {
+	return "tip", v.height
+}
+
+// testKit pays everything to one key and remembers the outputs of the
+// transactions it builds so they can be spent in turn.
+type testKit struct {
+	t       *testing.T
+	priv    *ecdsa.PrivateKey
+	script  string
+	view    *testView
+	outputs map[utxo.OutPoint]transaction.Output
+}
+
+func newTestKit(t *testing.T) *testKit {
+	priv, pub, err := transaction.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKit{
+		t:       t,
+		priv:    priv,
+		script:  transaction.PayToPubKeyHash(pub),
+		view:    &testView{coins: make(map[utxo.OutPoint]utxo.Entry), height: validation.CoinbaseMaturity},
+		outputs: make(map[utxo.OutPoint]transaction.Output),
+	}
+}
+
+// fund adds a confirmed coin of value to the view.
+func (k *testKit) fund(id string, value int) utxo.OutPoint {
+	op := utxo.OutPoint{TxID: id}
+	out := transaction.Output{Value: value, ScriptPubKey: k.script}
+	k.view.coins[op] = utxo.Entry{Output: out, Height: 1}
+	k.outputs[op] = out
+	return op
+}
+
+// spend signs a transaction spending ops into outputs of values.
+func (k *testKit) spend(ops []utxo.OutPoint, values ...int) transaction.Transaction {
+	tx := transaction.Transaction{}
+	for _, op := range ops {
+		tx.Inputs = append(tx.Inputs, transaction.Input{PrevTxID: op.TxID, OutputIndex: op.Index})
+	}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, transaction.Output{Value: value, ScriptPubKey: k.script})
+	}
+	for i, op := range ops {
+		if err := transaction.SignInput(&tx, i, k.priv, k.outputs[op], transaction.SigHashAll); err != nil {
+			k.t.Fatal(err)
+		}
+	}
+	for i, out := range tx.Outputs {
+		k.outputs[utxo.OutPoint{TxID: tx.ID, Index: i}] = out
+	}
+	return tx
+}
+
+// connect moves blk's effects into the view the way the UTXO set would.
+func (k *testKit) connect(blk block.Block) {
+	for _, tx := range blk.Transactions {
+		for _, in := range tx.Inputs {
+			delete(k.view.coins, utxo.OutPoint{TxID: in.PrevTxID, Index: in.OutputIndex})
+		}
+		for i, out := range tx.Outputs {
+			k.view.coins[utxo.OutPoint{TxID: tx.ID, Index: i}] = utxo.Entry{Output: out, Height: blk.Index, Coinbase: validation.IsCoinbase(tx)}
+		}
+	}
+	k.view.height = blk.Index
+}
+
+// disconnect undoes connect for the tip block blk.
+func (k *testKit) disconnect(blk block.Block) {
+	for _, tx := range blk.Transactions {
+		for i := range tx.Outputs {
+			delete(k.view.coins, utxo.OutPoint{TxID: tx.ID, Index: i})
+		}
+		for _, in := range tx.Inputs {
+			op := utxo.OutPoint{TxID: in.PrevTxID, Index: in.OutputIndex}
+			k.view.coins[op] = utxo.Entry{Output: k.outputs[op], Height: 1}
+		}
+	}
+	k.view.height = blk.Index - 1
+}
+
+func (k *testKit) block(txs ...transaction.Transaction) block.Block {
+	coinbase := transaction.Transaction{
+		Inputs:   []transaction.Input{},
+		Outputs:  []transaction.Output{{Value: validation.BlockSubsidy(k.view.height + 1), ScriptPubKey: k.script}},
+		LockTime: validation.CoinbaseLockTime(k.view.height + 1),
+	}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	for i, out := range coinbase.Outputs {
+		k.outputs[utxo.OutPoint{TxID: coinbase.ID, Index: i}] = out
+	}
+	return block.Block{Index: k.view.height + 1, Transactions: append([]transaction.Transaction{coinbase}, txs...)}
+}
+
+func op(tx transaction.Transaction, index int) utxo.OutPoint {
+	return utxo.OutPoint{TxID: tx.ID, Index: index}
+}
+
+func ids(txs []transaction.Transaction) []string {
+	var result []string
+	for _, tx := range txs {
+		result = append(result, tx.ID)
+	}
+	return result
+}
+
+func sameIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAddTransaction(t *testing.T) {
+	k := newTestKit(t)
+	funding := k.fund("funding", 100)
+	young := k.block()
+	k.connect(young)
+	youngCoinbase := op(young.Transactions[0], 0)
+	parent := k.spend([]utxo.OutPoint{funding}, 90)
+
+	chain := []transaction.Transaction{parent}
+	for i := 0; i <= maxAncestors; i++ {
+		chain = append(chain, k.spend([]utxo.OutPoint{op(chain[len(chain)-1], 0)}, 89-i))
+	}
+
+	tests := []struct {
+		name string
+		txs  []transaction.Transaction
+		want error
+	}{
+		{"accepts", []transaction.Transaction{parent}, nil},
+		{"spends pool output", []transaction.Transaction{parent, k.spend([]utxo.OutPoint{op(parent, 0)}, 80)}, nil},
+		{"already have", []transaction.Transaction{parent, parent}, ErrAlreadyHave},
+		{"coinbase", []transaction.Transaction{young.Transactions[0]}, ErrCoinbase},
+		{"conflicts with pool", []transaction.Transaction{parent, k.spend([]utxo.OutPoint{funding}, 80)}, ErrDoubleSpend},
+		{"unknown output", []transaction.Transaction{k.spend([]utxo.OutPoint{{TxID: "unknown"}}, 1)}, ErrMissingInputs},
+		{"outputs exceed inputs", []transaction.Transaction{k.spend([]utxo.OutPoint{funding}, 101)}, ErrNegativeFee},
+		{"immature coinbase", []transaction.Transaction{k.spend([]utxo.OutPoint{youngCoinbase}, 1)}, validation.ErrImmatureSpend},
+		{"too many ancestors", chain, ErrTooManyAncestors},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := New(k.view, DefaultMaxSize)
+			var err error
+			for _, tx := range tt.txs {
+				err = pool.AddTransaction(tx)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddTransactionNonFinal(t *testing.T) {
+	k := newTestKit(t)
+	tx := transaction.Transaction{
+		Inputs:   []transaction.Input{{PrevTxID: k.fund("funding", 10).TxID}},
+		Outputs:  []transaction.Output{{Value: 5, ScriptPubKey: k.script}},
+		LockTime: int64(k.view.height + 1),
+	}
+	if err := transaction.SignInput(&tx, 0, k.priv, k.outputs[utxo.OutPoint{TxID: "funding"}], transaction.SigHashAll); err != nil {
+		t.Fatal(err)
+	}
+	pool := New(k.view, DefaultMaxSize)
+	if err := pool.AddTransaction(tx); !errors.Is(err, ErrNonFinal) {
+		t.Fatalf("got %v, want %v", err, ErrNonFinal)
+	}
+	k.view.height++
+	if err := pool.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectTransactions(t *testing.T) {
+	k := newTestKit(t)
+	// A cheap parent is mined ahead of a middling transaction when its
+	// child pays enough for both.
+	parent := k.spend([]utxo.OutPoint{k.fund("a", 1000)}, 999)
+	child := k.spend([]utxo.OutPoint{op(parent, 0)}, 899)
+	middle := k.spend([]utxo.OutPoint{k.fund("b", 1000)}, 990)
+	pool := New(k.view, DefaultMaxSize)
+	for _, tx := range []transaction.Transaction{child, parent, middle} {
+		if err := pool.AddTransaction(tx); err != nil && !errors.Is(err, ErrMissingInputs) {
+			t.Fatal(err)
+		}
+	}
+	// The child arrived before its parent and was refused; add it again.
+	if err := pool.AddTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, fees := pool.SelectTransactions(DefaultMaxSize)
+	if want := []string{parent.ID, child.ID, middle.ID}; !sameIDs(ids(txs), want) {
+		t.Fatalf("selected %v, want %v", ids(txs), want)
+	}
+	if fees != 1+100+10 {
+		t.Fatalf("fees %d, want %d", fees, 111)
+	}
+	// Without room for the whole package the next best fits alone.
+	txs, _ = pool.SelectTransactions(len(transaction.Encode(middle)))
+	if want := []string{middle.ID}; !sameIDs(ids(txs), want) {
+		t.Fatalf("selected %v, want %v", ids(txs), want)
+	}
+}
+
+func TestTrimEvictsPackages(t *testing.T) {
+	k := newTestKit(t)
+	// The child pays more than its parent, so the pair goes as one package.
+	parent := k.spend([]utxo.OutPoint{k.fund("a", 1000)}, 999)
+	child := k.spend([]utxo.OutPoint{op(parent, 0)}, 989)
+	rich := k.spend([]utxo.OutPoint{k.fund("b", 1000)}, 500)
+	size := len(transaction.Encode(parent)) + len(transaction.Encode(child))
+	pool := New(k.view, size)
+	for _, tx := range []transaction.Transaction{parent, child} {
+		if err := pool.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.AddTransaction(rich); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Has(parent.ID) || pool.Has(child.ID) || !pool.Has(rich.ID) {
+		t.Fatalf("pool holds %v, want only %s", pool.TxIDs(), rich.ID)
+	}
+	if pool.Bytes() > pool.MaxSize() {
+		t.Fatalf("pool uses %d bytes, limit %d", pool.Bytes(), pool.MaxSize())
+	}
+	// A transaction paying less than the pool's worst is refused outright.
+	cheap := k.spend([]utxo.OutPoint{k.fund("c", 1000)}, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99)
+	if err := pool.AddTransaction(cheap); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("got %v, want %v", err, ErrMempoolFull)
+	}
+}
+
+func TestBlockConnectedRemovesConflicts(t *testing.T) {
+	k := newTestKit(t)
+	funding := k.fund("funding", 100)
+	confirmed := k.spend([]utxo.OutPoint{funding}, 90)
+	conflict := k.spend([]utxo.OutPoint{funding}, 80)
+	child := k.spend([]utxo.OutPoint{op(conflict, 0)}, 70)
+	other := k.spend([]utxo.OutPoint{k.fund("other", 100)}, 90)
+	pool := New(k.view, DefaultMaxSize)
+	for _, tx := range []transaction.Transaction{conflict, child, other} {
+		if err := pool.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blk := k.block(confirmed, other)
+	k.connect(blk)
+	pool.BlockConnected(blk)
+	if pool.Count() != 0 {
+		t.Fatalf("pool still holds %v", pool.TxIDs())
+	}
+	if pool.IsSpent(funding) {
+		t.Fatal("conflict's input is still marked spent")
+	}
+}
+
+func TestBlockDisconnected(t *testing.T) {
+	t.Run("relinks spenders of returned transactions", func(t *testing.T) {
+		k := newTestKit(t)
+		confirmed := k.spend([]utxo.OutPoint{k.fund("funding", 100)}, 90)
+		blk := k.block(confirmed)
+		k.connect(blk)
+		pool := New(k.view, DefaultMaxSize)
+		child := k.spend([]utxo.OutPoint{op(confirmed, 0)}, 50)
+		if err := pool.AddTransaction(child); err != nil {
+			t.Fatal(err)
+		}
+		k.disconnect(blk)
+		pool.BlockDisconnected(blk)
+
+		txs, _ := pool.SelectTransactions(DefaultMaxSize)
+		if want := []string{confirmed.ID, child.ID}; !sameIDs(ids(txs), want) {
+			t.Fatalf("selected %v, want %v", ids(txs), want)
+		}
+		pool.Remove(confirmed.ID)
+		if pool.Count() != 0 {
+			t.Fatalf("removing the parent left %v", pool.TxIDs())
+		}
+	})
+
+	t.Run("evicts spenders of a transaction that failed to return", func(t *testing.T) {
+		k := newTestKit(t)
+		confirmed := k.spend([]utxo.OutPoint{k.fund("funding", 100)}, 99)
+		blk := k.block(confirmed)
+		k.connect(blk)
+		child := k.spend([]utxo.OutPoint{op(confirmed, 0)}, 50)
+		rich := k.spend([]utxo.OutPoint{k.fund("rich", 100)}, 50)
+		pool := New(k.view, len(transaction.Encode(child))+len(transaction.Encode(rich)))
+		for _, tx := range []transaction.Transaction{child, rich} {
+			if err := pool.AddTransaction(tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		k.disconnect(blk)
+		pool.BlockDisconnected(blk)
+		if pool.Has(confirmed.ID) || pool.Has(child.ID) || !pool.Has(rich.ID) {
+			t.Fatalf("pool holds %v, want only %s", pool.TxIDs(), rich.ID)
+		}
+	})
+
+	t.Run("evicts spenders of a coinbase that is no longer mature", func(t *testing.T) {
+		k := newTestKit(t)
+		k.view.height = 0
+		mined := k.block()
+		k.connect(mined)
+		for k.view.height < validation.CoinbaseMaturity-1 {
+			k.connect(k.block())
+		}
+		tip := k.block()
+		k.connect(tip)
+		spend := k.spend([]utxo.OutPoint{op(mined.Transactions[0], 0)}, 10)
+		pool := New(k.view, DefaultMaxSize)
+		if err := pool.AddTransaction(spend); err != nil {
+			t.Fatal(err)
+		}
+		k.disconnect(tip)
+		pool.BlockDisconnected(tip)
+		if pool.Has(spend.ID) {
+			t.Fatal("spend of an immature coinbase is still in the pool")
+		}
+	})
+}
@@ -1,73 +1,75 @@
 package mining
 
 import (
-	"sort"
+	"time"
 
 	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/mempool"
 	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/validation"
 )
 
-const initialReward = 50
-const halvingInterval = 210000
-
-func calculateReward(height int) int {
-	halvings := height / halvingInterval
-	return initialReward >> halvings
-}
-
-func createCoinbaseTx(minerAddress string, reward int) transaction.Transaction {
-	return transaction.Transaction{
-		ID:     "coinbase",
+// createCoinbaseTx pays reward to minerAddress, committing to height in
+// the lock time as consensus requires.
+func createCoinbaseTx(minerAddress string, reward, height int) transaction.Transaction {
+	tx := transaction.Transaction{
 		Inputs: []transaction.Input{},
 		Outputs: []transaction.Output{
 			{Value: reward, ScriptPubKey: minerAddress},
 		},
+		LockTime: validation.CoinbaseLockTime(height),
 	}
+	tx.ID = transaction.CalculateID(tx)
+	return tx
 }
 
-func selectTransactions(mempool []transaction.Transaction, blockSize int) []transaction.Transaction {
-	var selectedTxs []transaction.Transaction
-	currentSize := 0
-
-	// Sort transactions by fee in descending order
-	sort.Slice(mempool, func(i, j int) bool {
-		return calculateTxFee(mempool[i]) > calculateTxFee(mempool[j])
-	})
-
-	for _, tx := range mempool {
-		txSize := calculateTxSize(tx)
-		if currentSize+txSize > blockSize {
-			break
-		}
-		selectedTxs = append(selectedTxs, tx)
-		currentSize += txSize
-	}
-	return selectedTxs
+func selectTransactions(pool *mempool.Pool, blockSize int) ([]transaction.Transaction, int) {
+	return pool.SelectTransactions(blockSize)
 }
 
-func calculateTxSize(tx transaction.Transaction) int {
-	// Simplified size calculation
-	return len(tx.ID) + len(tx.Inputs)*100 + len(tx.Outputs)*100
+func includeCoinbaseTx(block *block.Block, minerAddress string, reward int) {
+	coinbaseTx := createCoinbaseTx(minerAddress, reward, block.Index)
+	block.Transactions = append([]transaction.Transaction{coinbaseTx}, block.Transactions...)
 }
 
-func calculateTxFee(tx transaction.Transaction) int {
-	inputSum := 0
-	outputSum := 0
+// maxTemplateTxBytes leaves room in a block for the header and coinbase.
+const maxTemplateTxBytes = validation.MaxBlockSize - 4096
 
-	for _, input := range tx.Inputs {
-		// Assuming utxoSet is globally accessible
-		utxo := utxoSet[input.PrevTxID][input.OutputIndex]
-		inputSum += utxo.Value
+// NewBlock builds a block on chain's tip from the best transactions in pool,
+// paying the subsidy and their fees to minerAddress, and solves its proof of
+// work. The chain must already have a genesis block.
+func NewBlock(chain *fork.ChainManager, pool *mempool.Pool, minerAddress string, now time.Time) block.Block {
+	parent, height := chain.Tip()
+	ancestor := func(h int) (block.Block, bool) {
+		hash, ok := chain.HashAtHeight(h)
+		if !ok {
+			return block.Block{}, false
+		}
+		blk, err := chain.GetBlock(hash)
+		return blk, err == nil
 	}
-
-	for _, output := range tx.Outputs {
-		outputSum += output.Value
+	timestamp := now.Unix()
+	if mtp := validation.MedianTimePast(parent, ancestorFunc(ancestor)); timestamp <= mtp {
+		timestamp = mtp + 1
 	}
-
-	return inputSum - outputSum
+	txs, fees := selectTransactions(pool, maxTemplateTxBytes)
+	blk := block.Block{
+		Index:        height + 1,
+		PrevHash:     parent.Hash,
+		Timestamp:    timestamp,
+		Transactions: txs,
+		Bits:         concensus.NextBits(parent, ancestor),
+	}
+	includeCoinbaseTx(&blk, minerAddress, validation.BlockSubsidy(blk.Index)+fees)
+	blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+	return concensus.SolveBlock(blk)
 }
 
-func includeCoinbaseTx(block *block.Block, minerAddress string, reward int) {
-	coinbaseTx := createCoinbaseTx(minerAddress, reward)
-	block.Transactions = append([]transaction.Transaction{coinbaseTx}, block.Transactions...)
+// ancestorFunc adapts a height lookup to validation.HeaderChain.
+type ancestorFunc func(height int) (block.Block, bool)
+
+func (f ancestorFunc) Ancestor(height int) (block.Block, bool) {
+	return f(height)
 }
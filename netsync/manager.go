@@ -0,0 +1,358 @@
+package netsync
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/validation"
+)
+
+const blockDownloadWindow = 1024
+const maxInFlightPerPeer = 16
+const stallTimeout = 30 * time.Second
+const stallCheckInterval = 5 * time.Second
+
+// SyncPeer is the part of a connection the sync manager needs. Whoever owns
+// the connection reports it through PeerDisconnected once Disconnect has
+// closed it.
+type SyncPeer interface {
+	ID() string
+	StartHeight() int
+	Send(msg peer.Message) error
+	Disconnect()
+	Misbehaving(score int, reason string) bool
+}
+
+// Headers that do not connect may just be a race with a reorg, so they
+// cost less than ones that break a rule.
+const unconnectedHeadersScore = 20
+
+type peerState struct {
+	peer     SyncPeer
+	inFlight map[string]time.Time
+	// bestHeight starts at the height from the version message and rises
+	// with the headers and blocks the peer sends once the chain accepts
+	// them, so that peers keep being asked for blocks mined after they
+	// connected.
+	bestHeight int
+}
+
+// Manager drives headers-first sync: it fetches headers from one peer,
+// then spreads block body requests over all peers within a sliding window
+// above the connected tip.
+type Manager struct {
+	mu          sync.Mutex
+	chain       *fork.ChainManager
+	orphans     *fork.OrphanPool
+	peers       map[string]*peerState
+	requested   map[string]string
+	headersPeer string
+	quit        chan struct{}
+}
+
+func New(chain *fork.ChainManager, orphans *fork.OrphanPool) *Manager {
+	return &Manager{
+		chain:     chain,
+		orphans:   orphans,
+		peers:     make(map[string]*peerState),
+		requested: make(map[string]string),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (m *Manager) Start() {
+	go m.stallLoop()
+}
+
+func (m *Manager) Stop() {
+	close(m.quit)
+}
+
+func (m *Manager) BestHeight() int {
+	_, height := m.chain.Tip()
+	return height
+}
+
+func (m *Manager) IsSyncing() bool {
+	_, tipHeight := m.chain.Tip()
+	_, headerHeight := m.chain.BestHeader()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.headersPeer != "" || headerHeight > tipHeight
+}
+
+func (m *Manager) PeerConnected(p SyncPeer) {
+	m.mu.Lock()
+	m.peers[p.ID()] = &peerState{peer: p, inFlight: make(map[string]time.Time), bestHeight: p.StartHeight()}
+	needHeaders := m.headersPeer == ""
+	m.mu.Unlock()
+
+	_, headerHeight := m.chain.BestHeader()
+	if needHeaders && p.StartHeight() > headerHeight {
+		m.requestHeaders(p)
+	}
+}
+
+func (m *Manager) PeerDisconnected(p SyncPeer) {
+	m.mu.Lock()
+	state, exists := m.peers[p.ID()]
+	if exists {
+		for hash := range state.inFlight {
+			delete(m.requested, hash)
+		}
+		delete(m.peers, p.ID())
+	}
+	if m.headersPeer == p.ID() {
+		m.headersPeer = ""
+	}
+	m.mu.Unlock()
+	if m.orphans != nil {
+		m.orphans.RemovePeer(p.ID())
+	}
+	m.pickHeadersPeer()
+	m.fillWindow()
+}
+
+func (m *Manager) HandleMessage(p SyncPeer, msg peer.Message) {
+	switch msg := msg.(type) {
+	case *peer.MsgHeaders:
+		m.handleHeaders(p, msg.Headers)
+	case *peer.MsgBlock:
+		m.handleBlock(p, msg.Block)
+	case *peer.MsgInv:
+		m.handleInv(p, msg.Items)
+	case *peer.MsgGetHeaders:
+		headers := m.chain.LocateHeaders(msg.Locator, msg.StopHash, peer.MaxHeaders)
+		p.Send(&peer.MsgHeaders{Headers: headers})
+	case *peer.MsgGetData:
+		m.serveData(p, msg.Items)
+	}
+}
+
+func (m *Manager) requestHeaders(p SyncPeer) {
+	m.mu.Lock()
+	m.headersPeer = p.ID()
+	m.mu.Unlock()
+	if err := p.Send(&peer.MsgGetHeaders{Locator: m.chain.BlockLocator()}); err != nil {
+		log.Printf("netsync: getheaders to %s: %v", p.ID(), err)
+	}
+}
+
+func (m *Manager) pickHeadersPeer() {
+	m.mu.Lock()
+	if m.headersPeer != "" {
+		m.mu.Unlock()
+		return
+	}
+	_, headerHeight := m.chain.BestHeader()
+	var best *peerState
+	for _, state := range m.peers {
+		if state.bestHeight > headerHeight && (best == nil || state.bestHeight > best.bestHeight) {
+			best = state
+		}
+	}
+	m.mu.Unlock()
+	if best != nil {
+		m.requestHeaders(best.peer)
+	}
+}
+
+func (m *Manager) handleHeaders(p SyncPeer, headers []block.Block) {
+	for _, header := range headers {
+		if err := m.chain.AddHeader(header); err != nil {
+			log.Printf("netsync: bad header %s from %s: %v", header.Hash, p.ID(), err)
+			m.mu.Lock()
+			if m.headersPeer == p.ID() {
+				m.headersPeer = ""
+			}
+			m.mu.Unlock()
+			switch {
+			case errors.Is(err, fork.ErrUnknownParent):
+				if !p.Misbehaving(unconnectedHeadersScore, err.Error()) {
+					m.requestHeaders(p)
+				}
+			case validation.IsRuleError(err) || errors.Is(err, fork.ErrInvalidBlock):
+				p.Misbehaving(peer.BanThreshold, err.Error())
+			default:
+				p.Disconnect()
+			}
+			return
+		}
+	}
+	if len(headers) > 0 {
+		m.noteHeight(p, headers[len(headers)-1].Hash)
+	}
+	m.mu.Lock()
+	fromHeadersPeer := m.headersPeer == p.ID()
+	if fromHeadersPeer && len(headers) < peer.MaxHeaders {
+		m.headersPeer = ""
+	}
+	m.mu.Unlock()
+	if fromHeadersPeer && len(headers) == peer.MaxHeaders {
+		m.requestHeaders(p)
+	}
+	m.fillWindow()
+}
+
+func (m *Manager) handleBlock(p SyncPeer, blk block.Block) {
+	m.mu.Lock()
+	if peerID, exists := m.requested[blk.Hash]; exists {
+		if state, ok := m.peers[peerID]; ok {
+			delete(state.inFlight, blk.Hash)
+		}
+		delete(m.requested, blk.Hash)
+	}
+	m.mu.Unlock()
+
+	var err error
+	if m.orphans != nil {
+		_, err = m.orphans.ProcessBlock(blk, p.ID())
+	} else {
+		_, err = m.chain.ProcessBlock(blk)
+	}
+	if err != nil && !errors.Is(err, fork.ErrUnknownParent) {
+		log.Printf("netsync: rejected block %s from %s: %v", blk.Hash, p.ID(), err)
+		if validation.IsRuleError(err) || errors.Is(err, fork.ErrInvalidBlock) {
+			p.Misbehaving(peer.BanThreshold, err.Error())
+		}
+	}
+	if err == nil {
+		m.noteHeight(p, blk.Hash)
+	}
+	m.fillWindow()
+}
+
+// handleInv treats an unknown block announcement as a hint that the peer
+// has headers we lack.
+func (m *Manager) handleInv(p SyncPeer, items []peer.InvVect) {
+	for _, item := range items {
+		if item.Type == peer.InvBlock && !m.chain.HaveHeader(item.Hash) {
+			m.requestHeaders(p)
+			return
+		}
+	}
+}
+
+func (m *Manager) serveData(p SyncPeer, items []peer.InvVect) {
+	for _, item := range items {
+		if item.Type != peer.InvBlock {
+			continue
+		}
+		blk, err := m.chain.GetBlock(item.Hash)
+		if err != nil {
+			continue
+		}
+		if err := p.Send(&peer.MsgBlock{Block: blk}); err != nil {
+			return
+		}
+	}
+}
+
+// noteHeight records that p has the block hash, taking its height from the
+// chain rather than from what the peer claims.
+func (m *Manager) noteHeight(p SyncPeer, hash string) {
+	height, ok := m.chain.HeaderHeight(hash)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state, exists := m.peers[p.ID()]; exists && height > state.bestHeight {
+		state.bestHeight = height
+	}
+}
+
+// fillWindow requests missing blocks within blockDownloadWindow of the tip,
+// giving each peer at most maxInFlightPerPeer outstanding requests.
+func (m *Manager) fillWindow() {
+	missing := m.chain.MissingBlocks(blockDownloadWindow)
+
+	m.mu.Lock()
+	states := make([]*peerState, 0, len(m.peers))
+	for _, state := range m.peers {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return len(states[i].inFlight) < len(states[j].inFlight)
+	})
+	batches := make(map[*peerState][]peer.InvVect)
+	for _, blk := range missing {
+		hash := blk.Hash
+		if _, exists := m.requested[hash]; exists {
+			continue
+		}
+		for _, state := range states {
+			if len(state.inFlight) >= maxInFlightPerPeer || state.bestHeight < blk.Height {
+				continue
+			}
+			state.inFlight[hash] = time.Now()
+			m.requested[hash] = state.peer.ID()
+			batches[state] = append(batches[state], peer.InvVect{Type: peer.InvBlock, Hash: hash})
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	for state, items := range batches {
+		if err := state.peer.Send(&peer.MsgGetData{Items: items}); err != nil {
+			log.Printf("netsync: getdata to %s: %v", state.peer.ID(), err)
+		}
+	}
+}
+
+func (m *Manager) stallLoop() {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case now := <-ticker.C:
+			m.checkStalls(now)
+		}
+	}
+}
+
+// checkStalls disconnects peers that have sat on a block request for longer
+// than stallTimeout. Their requests go back into the window when the
+// disconnect comes back through PeerDisconnected.
+func (m *Manager) checkStalls(now time.Time) {
+	var stalled []SyncPeer
+	m.mu.Lock()
+	for _, state := range m.peers {
+		for _, sent := range state.inFlight {
+			if now.Sub(sent) > stallTimeout {
+				stalled = append(stalled, state.peer)
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+	for _, p := range stalled {
+		log.Printf("netsync: peer %s stalled block download", p.ID())
+		p.Disconnect()
+	}
+}
+
+// PeerHandler adapts Manager to the TCP peer package.
+type PeerHandler struct {
+	*Manager
+}
+
+func (h PeerHandler) OnConnect(p *peer.Peer) {
+	h.PeerConnected(p)
+}
+
+func (h PeerHandler) OnMessage(p *peer.Peer, msg peer.Message) {
+	h.HandleMessage(p, msg)
+}
+
+func (h PeerHandler) OnDisconnect(p *peer.Peer) {
+	h.PeerDisconnected(p)
+}
@@ -0,0 +1,261 @@
+package netsync
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/storage"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
+)
+
+type testPeer struct {
+	id    string
+	start int
+
+	mu          sync.Mutex
+	getData     []string
+	disconnects int
+}
+
+func (p *testPeer) ID() string       { return p.id }
+func (p *testPeer) StartHeight() int { return p.start }
+
+func (p *testPeer) Send(msg peer.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if msg, ok := msg.(*peer.MsgGetData); ok {
+		for _, item := range msg.Items {
+			p.getData = append(p.getData, item.Hash)
+		}
+	}
+	return nil
+}
+
+func (p *testPeer) Disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disconnects++
+}
+
+func (p *testPeer) Misbehaving(score int, reason string) bool {
+	return false
+}
+
+// takeRequests returns the blocks requested from p since the last call.
+func (p *testPeer) takeRequests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hashes := p.getData
+	p.getData = nil
+	return hashes
+}
+
+// testBlocks returns a genesis block followed by n blocks on it.
+func testBlocks(n int) []block.Block {
+	var blocks []block.Block
+	parent := block.Block{Index: -1}
+	for i := 0; i <= n; i++ {
+		coinbase := transaction.Transaction{
+			Inputs:   []transaction.Input{},
+			Outputs:  []transaction.Output{{Value: validation.BlockSubsidy(i)}},
+			LockTime: validation.CoinbaseLockTime(i),
+		}
+		if i == 0 {
+			coinbase = transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 0}}}
+		}
+		coinbase.ID = transaction.CalculateID(coinbase)
+		blk := block.Block{
+			Index:        i,
+			PrevHash:     parent.Hash,
+			Timestamp:    1700000000 + int64(60*i),
+			Transactions: []transaction.Transaction{coinbase},
+			Bits:         concensus.PowLimitBits,
+		}
+		if i == 0 {
+			blk.PrevHash = "0"
+		}
+		blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+		parent = concensus.SolveBlock(blk)
+		blocks = append(blocks, parent)
+	}
+	return blocks
+}
+
+func newTestManager(t *testing.T, genesis block.Block) (*Manager, *fork.ChainManager) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	utxos, err := utxo.Open(filepath.Join(dir, "chainstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := fork.NewChainManager(store, utxos, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.ProcessBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+	return New(chain, nil), chain
+}
+
+func byHash(blocks []block.Block) map[string]block.Block {
+	m := make(map[string]block.Block)
+	for _, blk := range blocks {
+		m[blk.Hash] = blk
+	}
+	return m
+}
+
+func (m *Manager) inFlight(p SyncPeer) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state, exists := m.peers[p.ID()]; exists {
+		return len(state.inFlight)
+	}
+	return 0
+}
+
+func TestDownloadWindow(t *testing.T) {
+	blocks := testBlocks(40)
+	all := byHash(blocks)
+	m, chain := newTestManager(t, blocks[0])
+	a := &testPeer{id: "a", start: 40}
+	b := &testPeer{id: "b", start: 40}
+	// c has only seen the first few blocks and must not be asked for more.
+	c := &testPeer{id: "c", start: 4}
+	for _, p := range []*testPeer{a, b, c} {
+		m.PeerConnected(p)
+	}
+	m.HandleMessage(a, &peer.MsgHeaders{Headers: blocks[1:]})
+
+	requested := make(map[string]bool)
+	for {
+		progress := false
+		for _, p := range []*testPeer{a, b, c} {
+			if n := m.inFlight(p); n > maxInFlightPerPeer {
+				t.Fatalf("%s has %d blocks in flight", p.id, n)
+			}
+			for _, hash := range p.takeRequests() {
+				if requested[hash] {
+					t.Fatalf("block %s requested twice", hash)
+				}
+				requested[hash] = true
+				if p == c && all[hash].Index > c.start {
+					t.Fatalf("c asked for height %d beyond its best %d", all[hash].Index, c.start)
+				}
+				m.HandleMessage(p, &peer.MsgBlock{Block: all[hash]})
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	if _, height := chain.Tip(); height != 40 {
+		t.Fatalf("tip at %d after sync, want 40", height)
+	}
+	if len(requested) != 40 {
+		t.Fatalf("requested %d blocks, want 40", len(requested))
+	}
+}
+
+func TestStalledPeerIsDisconnected(t *testing.T) {
+	blocks := testBlocks(20)
+	all := byHash(blocks)
+	m, chain := newTestManager(t, blocks[0])
+	a := &testPeer{id: "a", start: 20}
+	b := &testPeer{id: "b", start: 20}
+	m.PeerConnected(a)
+	m.PeerConnected(b)
+	m.HandleMessage(a, &peer.MsgHeaders{Headers: blocks[1:]})
+
+	stale := time.Now().Add(-stallTimeout - time.Second)
+	m.mu.Lock()
+	for hash := range m.peers["a"].inFlight {
+		m.peers["a"].inFlight[hash] = stale
+	}
+	m.mu.Unlock()
+	m.checkStalls(time.Now())
+	if a.disconnects != 1 || b.disconnects != 0 {
+		t.Fatalf("disconnects: a %d, b %d; want 1 and 0", a.disconnects, b.disconnects)
+	}
+	// Only the connection owner reports the disconnect.
+	if m.inFlight(a) == 0 {
+		t.Fatal("stall check released a's requests before the disconnect was reported")
+	}
+
+	stalled := a.takeRequests()
+	pending := b.takeRequests()
+	m.PeerDisconnected(a)
+	reassigned := make(map[string]bool)
+	for {
+		for _, hash := range b.takeRequests() {
+			reassigned[hash] = true
+			pending = append(pending, hash)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		m.HandleMessage(b, &peer.MsgBlock{Block: all[pending[0]]})
+		pending = pending[1:]
+	}
+	for _, hash := range stalled {
+		if !reassigned[hash] {
+			t.Fatalf("block %s requested from the stalled peer was not asked of b", hash)
+		}
+	}
+	if _, height := chain.Tip(); height != 20 {
+		t.Fatalf("tip at %d, want 20", height)
+	}
+}
+
+func TestPeerHeightFollowsAcceptedData(t *testing.T) {
+	blocks := testBlocks(6)
+	m, chain := newTestManager(t, blocks[0])
+	for _, header := range blocks[1:] {
+		if err := chain.AddHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bestHeight := func(p SyncPeer) int {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.peers[p.ID()].bestHeight
+	}
+
+	// A known hash with a made-up height counts at its real height.
+	liar := &testPeer{id: "liar"}
+	m.PeerConnected(liar)
+	header := blocks[3]
+	header.Index = 1000
+	m.HandleMessage(liar, &peer.MsgHeaders{Headers: []block.Block{header}})
+	if got := bestHeight(liar); got != 3 {
+		t.Fatalf("best height %d after a relabelled header, want 3", got)
+	}
+
+	// A block that does not connect says nothing about the peer's chain.
+	orphan := testBlocks(9)[9]
+	m.HandleMessage(liar, &peer.MsgBlock{Block: orphan})
+	if got := bestHeight(liar); got != 3 {
+		t.Fatalf("best height %d after an unconnected block, want 3", got)
+	}
+
+	m.HandleMessage(liar, &peer.MsgBlock{Block: blocks[1]})
+	m.HandleMessage(liar, &peer.MsgHeaders{Headers: blocks[5:]})
+	if got := bestHeight(liar); got != 6 {
+		t.Fatalf("best height %d, want 6", got)
+	}
+}
@@ -0,0 +1,205 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const channelBuffer = 1024
+
+var ErrListenerClosed = errors.New("network: listener closed")
+
+// ChannelNetwork is an in-memory switchboard. Each simulated node gets its
+// own Transport from it, bound to the node's address.
+type ChannelNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*channelListener
+}
+
+func NewChannelNetwork() *ChannelNetwork {
+	return &ChannelNetwork{listeners: make(map[string]*channelListener)}
+}
+
+func (cn *ChannelNetwork) Transport(localAddr string) Transport {
+	return &channelTransport{network: cn, local: localAddr}
+}
+
+type channelTransport struct {
+	network *ChannelNetwork
+	local   string
+}
+
+func (t *channelTransport) Dial(address string) (net.Conn, error) {
+	t.network.mu.Lock()
+	l, exists := t.network.listeners[address]
+	t.network.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("network: no listener at %s", address)
+	}
+	a := make(chan []byte, channelBuffer)
+	b := make(chan []byte, channelBuffer)
+	closed := make(chan struct{})
+	var once sync.Once
+	shutdown := func() { once.Do(func() { close(closed) }) }
+	client := &channelConn{local: t.local, remote: address, in: b, out: a, closed: closed, shutdown: shutdown}
+	server := &channelConn{local: address, remote: t.local, in: a, out: b, closed: closed, shutdown: shutdown}
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (t *channelTransport) Listen(address string) (net.Listener, error) {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	if _, exists := t.network.listeners[address]; exists {
+		return nil, fmt.Errorf("network: address %s already in use", address)
+	}
+	l := &channelListener{network: t.network, addr: address, accept: make(chan net.Conn), closed: make(chan struct{})}
+	t.network.listeners[address] = l
+	return l, nil
+}
+
+type channelListener struct {
+	network *ChannelNetwork
+	addr    string
+	accept  chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (l *channelListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *channelListener) Close() error {
+	l.once.Do(func() {
+		l.network.mu.Lock()
+		delete(l.network.listeners, l.addr)
+		l.network.mu.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *channelListener) Addr() net.Addr {
+	return channelAddr(l.addr)
+}
+
+type channelAddr string
+
+func (a channelAddr) Network() string { return "chan" }
+func (a channelAddr) String() string  { return string(a) }
+
+// channelConn carries writes as discrete buffers over a buffered channel, so
+// unlike net.Pipe a writer does not wait for the reader.
+type channelConn struct {
+	local, remote string
+	in, out       chan []byte
+	closed        chan struct{}
+	shutdown      func()
+
+	readMu        sync.Mutex
+	pending       []byte
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *channelConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if len(c.pending) == 0 {
+		c.deadlineMu.Lock()
+		deadline := c.readDeadline
+		c.deadlineMu.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case data := <-c.in:
+			c.pending = data
+		case <-c.closed:
+			// What was written before the close is still delivered.
+			select {
+			case data := <-c.in:
+				c.pending = data
+			default:
+				return 0, io.EOF
+			}
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *channelConn) Write(b []byte) (int, error) {
+	data := append([]byte(nil), b...)
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	c.deadlineMu.Lock()
+	deadline := c.writeDeadline
+	c.deadlineMu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case c.out <- data:
+		return len(b), nil
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *channelConn) Close() error {
+	c.shutdown()
+	return nil
+}
+
+func (c *channelConn) LocalAddr() net.Addr  { return channelAddr(c.local) }
+func (c *channelConn) RemoteAddr() net.Addr { return channelAddr(c.remote) }
+
+func (c *channelConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *channelConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *channelConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
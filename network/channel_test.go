@@ -0,0 +1,153 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// dial connects client to the listener at address and returns both ends.
+func dial(t *testing.T, cn *ChannelNetwork, l net.Listener, client, address string) (net.Conn, net.Conn) {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	conn, err := cn.Transport(client).Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, server
+}
+
+func TestChannelDelivery(t *testing.T) {
+	cn := NewChannelNetwork()
+	l, err := cn.Transport("server").Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, server := dial(t, cn, l, "client", "server")
+	if client.LocalAddr().String() != "client" || client.RemoteAddr().String() != "server" ||
+		server.LocalAddr().String() != "server" || server.RemoteAddr().String() != "client" {
+		t.Fatalf("addresses %v->%v and %v->%v", client.LocalAddr(), client.RemoteAddr(), server.LocalAddr(), server.RemoteAddr())
+	}
+
+	// Writes do not wait for the reader and arrive in order, each side
+	// independently.
+	const n = 100
+	for i := 0; i < n; i++ {
+		if _, err := fmt.Fprintf(client, "c%03d", i); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fmt.Fprintf(server, "s%03d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		conn   net.Conn
+		prefix string
+	}{{server, "c"}, {client, "s"}} {
+		buf := make([]byte, 4)
+		for i := 0; i < n; i++ {
+			if _, err := io.ReadFull(tt.conn, buf); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("%s%03d", tt.prefix, i); string(buf) != want {
+				t.Fatalf("read %q, want %q", buf, want)
+			}
+		}
+	}
+
+	// A short read leaves the rest of a write for the next one, and the
+	// writer's buffer may be reused at once.
+	data := []byte("0123456789")
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, "xxxxxxxxxx")
+	head := make([]byte, 4)
+	tail := make([]byte, 6)
+	if _, err := server.Read(head); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Read(tail); err != nil {
+		t.Fatal(err)
+	}
+	if string(head)+string(tail) != "0123456789" {
+		t.Fatalf("read %q then %q", head, tail)
+	}
+}
+
+func TestChannelClose(t *testing.T) {
+	cn := NewChannelNetwork()
+	l, err := cn.Transport("server").Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cn.Transport("other").Listen("server"); err == nil {
+		t.Fatal("second listener on the same address")
+	}
+	if _, err := cn.Transport("client").Dial("nowhere"); err == nil {
+		t.Fatal("dialled an address nobody listens on")
+	}
+
+	// Whatever was written before a disconnect is still read, then EOF.
+	client, server := dial(t, cn, l, "client", "server")
+	if _, err := client.Write([]byte("last words")); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	data, err := io.ReadAll(server)
+	if err != nil || string(data) != "last words" {
+		t.Fatalf("read %q, %v after the peer closed", data, err)
+	}
+	for _, conn := range []net.Conn{client, server} {
+		if _, err := conn.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+			t.Fatalf("write after close: %v, want %v", err, io.ErrClosedPipe)
+		}
+	}
+
+	// Deadlines.
+	client, server = dial(t, cn, l, "client", "server")
+	server.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := server.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read past the deadline: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	for i := 0; i < channelBuffer; i++ {
+		if _, err := client.Write([]byte{1}); err != nil {
+			t.Fatalf("write %d into the buffer: %v", i, err)
+		}
+	}
+	client.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := client.Write([]byte{1}); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("write to a full buffer: %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// A closed listener stops accepting and frees its address.
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, ErrListenerClosed) {
+		t.Fatalf("accept after close: %v, want %v", err, ErrListenerClosed)
+	}
+	if _, err := cn.Transport("client").Dial("server"); err == nil {
+		t.Fatal("dialled a closed listener")
+	}
+	l, err = cn.Transport("server").Listen("server")
+	if err != nil {
+		t.Fatalf("listening again on a freed address: %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,23 @@
+package network
+
+import (
+	"net"
+)
+
+// Transport opens the byte streams peers talk over. The TCP implementation
+// is used by real nodes and the channel one by in-process simulations; the
+// wire protocol on top is the same for both.
+type Transport interface {
+	Dial(address string) (net.Conn, error)
+	Listen(address string) (net.Listener, error)
+}
+
+type TCPTransport struct{}
+
+func (TCPTransport) Dial(address string) (net.Conn, error) {
+	return net.Dial("tcp", address)
+}
+
+func (TCPTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
@@ -0,0 +1,185 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BanThreshold is the misbehavior score at which a peer is disconnected
+// and banned.
+const BanThreshold = 100
+const DefaultBanDuration = 24 * time.Hour
+
+var ErrBanned = errors.New("peer: address is banned")
+var ErrNotBanned = errors.New("peer: no such ban")
+var ErrBadSubnet = errors.New("peer: invalid ban subnet")
+
+type Ban struct {
+	Subnet string
+	Until  time.Time
+	Reason string
+}
+
+// BanList holds time-limited bans by IP, CIDR subnet or, for transports
+// without IP addresses, by host name. It is written to disk on every
+// change so that bans survive a restart.
+type BanList struct {
+	mu   sync.Mutex
+	path string
+	bans map[string]Ban
+}
+
+// OpenBanList loads the bans stored at path. An empty path keeps them in
+// memory only.
+func OpenBanList(path string) (*BanList, error) {
+	b := &BanList{path: path, bans: make(map[string]Ban)}
+	if path == "" {
+		return b, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bans []Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, err
+	}
+	for _, ban := range bans {
+		b.bans[ban.Subnet] = ban
+	}
+	return b, nil
+}
+
+// Ban adds or extends a ban on subnet, which may be an IP, a CIDR range or
+// a host name.
+func (b *BanList) Ban(subnet string, duration time.Duration, reason string) error {
+	key, err := normalizeSubnet(subnet)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until := time.Now().Add(duration)
+	if existing, exists := b.bans[key]; exists && existing.Until.After(until) {
+		return nil
+	}
+	b.bans[key] = Ban{Subnet: key, Until: until, Reason: reason}
+	return b.save()
+}
+
+func (b *BanList) Unban(subnet string) error {
+	key, err := normalizeSubnet(subnet)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.bans[key]; !exists {
+		return ErrNotBanned
+	}
+	delete(b.bans, key)
+	return b.save()
+}
+
+func (b *BanList) Clear() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bans = make(map[string]Ban)
+	return b.save()
+}
+
+// List returns the bans still in force, ordered by subnet.
+func (b *BanList) List() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		list = append(list, ban)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subnet < list[j].Subnet })
+	return list
+}
+
+// IsBanned reports whether address, with or without a port, falls under an
+// active ban.
+func (b *BanList) IsBanned(address string) bool {
+	host := hostOf(address)
+	ip := net.ParseIP(host)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	for key := range b.bans {
+		if key == host {
+			return true
+		}
+		if ip == nil {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(key); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BanList) expire(now time.Time) {
+	for key, ban := range b.bans {
+		if !now.Before(ban.Until) {
+			delete(b.bans, key)
+		}
+	}
+}
+
+func (b *BanList) save() error {
+	if b.path == "" {
+		return nil
+	}
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		list = append(list, ban)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// normalizeSubnet turns a bare IP into a single-address CIDR so that every
+// IP ban is matched the same way.
+func normalizeSubnet(subnet string) (string, error) {
+	if subnet == "" {
+		return "", ErrBadSubnet
+	}
+	if _, ipNet, err := net.ParseCIDR(subnet); err == nil {
+		return ipNet.String(), nil
+	}
+	ip := net.ParseIP(subnet)
+	if ip == nil {
+		return subnet, nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}).String(), nil
+	}
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}).String(), nil
+}
+
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
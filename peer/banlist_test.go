@@ -0,0 +1,147 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist.json")
+	b, err := OpenBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subnet := range []string{"10.0.0.5", "192.168.1.0/24", "2001:db8::1", "node-7"} {
+		if err := b.Ban(subnet, time.Hour, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Ban("", time.Hour, "test"); !errors.Is(err, ErrBadSubnet) {
+		t.Fatalf("empty subnet: %v, want %v", err, ErrBadSubnet)
+	}
+	// Already expired when added, so it is written out but never matches.
+	if err := b.Ban("10.0.0.6", -time.Second, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(b *BanList) {
+		t.Helper()
+		tests := []struct {
+			address string
+			banned  bool
+		}{
+			{"10.0.0.5", true},
+			{"10.0.0.5:8333", true},
+			{"10.0.0.4:8333", false},
+			{"10.0.0.6:8333", false},
+			{"192.168.1.200:8333", true},
+			{"192.168.2.1:8333", false},
+			{"[2001:db8::1]:8333", true},
+			{"[2001:db8::2]:8333", false},
+			{"node-7", true},
+			{"node-8", false},
+		}
+		for _, tt := range tests {
+			if got := b.IsBanned(tt.address); got != tt.banned {
+				t.Fatalf("IsBanned(%s) = %v, want %v", tt.address, got, tt.banned)
+			}
+		}
+		want := []string{"10.0.0.5/32", "192.168.1.0/24", "2001:db8::1/128", "node-7"}
+		list := b.List()
+		if len(list) != len(want) {
+			t.Fatalf("%d bans listed, want %d", len(list), len(want))
+		}
+		for i, ban := range list {
+			if ban.Subnet != want[i] {
+				t.Fatalf("ban %d on %s, want %s", i, ban.Subnet, want[i])
+			}
+		}
+	}
+	check(b)
+	reloaded, err := OpenBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reloaded)
+
+	// A shorter ban does not cut an existing one short.
+	before := reloaded.List()[0].Until
+	if err := reloaded.Ban("10.0.0.5", time.Minute, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if until := reloaded.List()[0].Until; !until.Equal(before) {
+		t.Fatalf("ban shortened from %v to %v", before, until)
+	}
+
+	if err := reloaded.Unban("10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Unban("10.0.0.5"); !errors.Is(err, ErrNotBanned) {
+		t.Fatalf("second unban: %v, want %v", err, ErrNotBanned)
+	}
+	if reloaded, err = OpenBanList(path); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.IsBanned("10.0.0.5") {
+		t.Fatal("unban did not reach the disk")
+	}
+
+	// Bans run out on their own.
+	reloaded.mu.Lock()
+	reloaded.expire(time.Now().Add(2 * time.Hour))
+	reloaded.mu.Unlock()
+	if list := reloaded.List(); len(list) != 0 {
+		t.Fatalf("%d bans left after they expired", len(list))
+	}
+}
+
+func TestMisbehavingBansAtThreshold(t *testing.T) {
+	pm := NewPeerManager(nil, nil)
+	bans, err := OpenBanList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.SetBanList(bans)
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	p, err := pm.AddPeer("10.0.0.5:8333", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Misbehaving(BanThreshold-1, "almost") {
+		t.Fatal("banned below the threshold")
+	}
+	if bans.IsBanned("10.0.0.5") {
+		t.Fatal("host banned below the threshold")
+	}
+	if !p.Misbehaving(1, "over") {
+		t.Fatal("not banned at the threshold")
+	}
+	if !bans.IsBanned("10.0.0.5:9000") {
+		t.Fatal("host not banned after crossing the threshold")
+	}
+	if _, err := remote.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection left open after the ban")
+	}
+	if _, err := pm.AddPeer("10.0.0.5:9000", nil); !errors.Is(err, ErrBanned) {
+		t.Fatalf("reconnect from banned host: %v, want %v", err, ErrBanned)
+	}
+
+	// Only crossing the threshold bans; further misbehavior just reports it.
+	if err := bans.Unban("10.0.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Misbehaving(10, "more") {
+		t.Fatal("peer over the threshold reported as not banned")
+	}
+	if bans.IsBanned("10.0.0.5") {
+		t.Fatal("host banned again after already crossing the threshold")
+	}
+	if p.BanScore() != BanThreshold+10 {
+		t.Fatalf("ban score %d, want %d", p.BanScore(), BanThreshold+10)
+	}
+}
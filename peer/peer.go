@@ -1,107 +1,608 @@
 package peer
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"sync"
 	"time"
+
+	"blockchain-hello-golang/network"
 )
 
+const userAgent = "/blockchain-hello-golang:0.1/"
+const handshakeTimeout = 30 * time.Second
+const pingInterval = 30 * time.Second
+const pingTimeout = 20 * time.Second
+
+// writeTimeout bounds how long Send waits on a peer that stopped reading.
+const writeTimeout = 30 * time.Second
+
+// Accept errors are retried after a delay that doubles up to
+// maxAcceptDelay, so a persistent failure such as running out of file
+// descriptors does not spin.
+const minAcceptDelay = 5 * time.Millisecond
+const maxAcceptDelay = time.Second
+
+var ErrHandshake = errors.New("peer: protocol message before handshake")
+var ErrSelfConnection = errors.New("peer: connected to self")
+var ErrPingTimeout = errors.New("peer: ping timed out")
+var ErrAlreadyConnected = errors.New("peer: already connected")
+var ErrTooManyPeers = errors.New("peer: connection limit reached")
+
+// Listener is told about established peers and receives every message
+// other than the handshake and ping/pong, which the package handles itself.
+type Listener interface {
+	OnConnect(p *Peer)
+	OnMessage(p *Peer, msg Message)
+	OnDisconnect(p *Peer)
+}
+
+// Handler is the node's main listener and also reports the height
+// advertised in our version message.
+type Handler interface {
+	BestHeight() int
+	Listener
+}
+
 type Peer struct {
 	Address    string
 	Connection net.Conn
+	Inbound    bool
+	Version    *MsgVersion
+
+	manager     *PeerManager
+	sendMu      sync.Mutex
+	stateMu     sync.Mutex
+	verAckRecvd bool
+	pingNonce   uint64
+	pingSent    time.Time
+	lastPingRTT time.Duration
+	banScore    int
+}
+
+// PeerManager owns the connections of one node. The same manager runs over
+// TCP in production and over network.ChannelNetwork in the simulator.
+type PeerManager struct {
+	transport  network.Transport
+	handler    Handler
+	listeners  []Listener
+	nonce      uint64
+	mu         sync.Mutex
+	peers      map[string]*Peer
+	maxPeers   int
+	banList    *BanList
+	statsMu    sync.Mutex
+	sent       map[string]Traffic
+	received   map[string]Traffic
+	listener   net.Listener
+	listenAddr string
 }
 
-var peers = make(map[string]*Peer)
-var mu sync.Mutex
+func NewPeerManager(transport network.Transport, handler Handler) *PeerManager {
+	return &PeerManager{
+		transport: transport,
+		handler:   handler,
+		nonce:     randomNonce(),
+		peers:     make(map[string]*Peer),
+		sent:      make(map[string]Traffic),
+		received:  make(map[string]Traffic),
+	}
+}
 
-func AddPeer(address string, conn net.Conn) {
-	mu.Lock()
-	defer mu.Unlock()
-	peers[address] = &Peer{Address: address, Connection: conn}
+// Traffic counts messages and their bytes on the wire, framing included.
+type Traffic struct {
+	Messages int
+	Bytes    int
+}
+
+// TrafficStats returns what has been sent and received so far, by command.
+func (pm *PeerManager) TrafficStats() (sent, received map[string]Traffic) {
+	pm.statsMu.Lock()
+	defer pm.statsMu.Unlock()
+	sent = make(map[string]Traffic, len(pm.sent))
+	for cmd, t := range pm.sent {
+		sent[cmd] = t
+	}
+	received = make(map[string]Traffic, len(pm.received))
+	for cmd, t := range pm.received {
+		received[cmd] = t
+	}
+	return sent, received
 }
 
-func RemovePeer(address string) {
-	mu.Lock()
-	defer mu.Unlock()
-	delete(peers, address)
+func (pm *PeerManager) count(stats map[string]Traffic, command string, n int) {
+	pm.statsMu.Lock()
+	defer pm.statsMu.Unlock()
+	t := stats[command]
+	t.Messages++
+	t.Bytes += n
+	stats[command] = t
 }
 
-func GetPeer(address string) (*Peer, bool) {
-	mu.Lock()
-	defer mu.Unlock()
-	peer, exists := peers[address]
+func (pm *PeerManager) SetHandler(h Handler) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.handler = h
+}
+
+func (pm *PeerManager) getHandler() Handler {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.handler
+}
+
+// AddListener registers l to see the same events as the handler, after it.
+func (pm *PeerManager) AddListener(l Listener) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.listeners = append(pm.listeners, l)
+}
+
+func (pm *PeerManager) getListeners() []Listener {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	var all []Listener
+	if pm.handler != nil {
+		all = append(all, pm.handler)
+	}
+	return append(all, pm.listeners...)
+}
+
+// SetMaxPeers caps inbound plus outbound connections; zero means no limit.
+func (pm *PeerManager) SetMaxPeers(n int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.maxPeers = n
+}
+
+// SetBanList makes the manager refuse banned addresses and record a ban
+// whenever a peer crosses BanThreshold.
+func (pm *PeerManager) SetBanList(b *BanList) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.banList = b
+}
+
+func (pm *PeerManager) BanList() *BanList {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.banList
+}
+
+func (pm *PeerManager) OutboundCount() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	count := 0
+	for _, p := range pm.peers {
+		if !p.Inbound {
+			count++
+		}
+	}
+	return count
+}
+
+func (pm *PeerManager) AddPeer(address string, conn net.Conn) (*Peer, error) {
+	return pm.addPeer(address, conn, false)
+}
+
+// addPeer sets Inbound before the peer is published, since OutboundCount
+// reads it from other goroutines.
+func (pm *PeerManager) addPeer(address string, conn net.Conn, inbound bool) (*Peer, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if _, exists := pm.peers[address]; exists {
+		return nil, ErrAlreadyConnected
+	}
+	if pm.maxPeers > 0 && len(pm.peers) >= pm.maxPeers {
+		return nil, ErrTooManyPeers
+	}
+	if pm.banList != nil && pm.banList.IsBanned(address) {
+		return nil, ErrBanned
+	}
+	p := &Peer{Address: address, Connection: conn, Inbound: inbound, manager: pm}
+	pm.peers[address] = p
+	return p, nil
+}
+
+func (pm *PeerManager) RemovePeer(address string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.peers, address)
+}
+
+func (pm *PeerManager) GetPeer(address string) (*Peer, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	peer, exists := pm.peers[address]
 	return peer, exists
 }
 
-func ListPeers() []string {
-	mu.Lock()
-	defer mu.Unlock()
-	addresses := make([]string, 0, len(peers))
-	for address := range peers {
+func (pm *PeerManager) ListPeers() []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	addresses := make([]string, 0, len(pm.peers))
+	for address := range pm.peers {
 		addresses = append(addresses, address)
 	}
 	return addresses
 }
 
-func ConnectToPeer(address string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", address)
+// Peers returns the peers that completed the handshake.
+func (pm *PeerManager) Peers() []*Peer {
+	pm.mu.Lock()
+	all := make([]*Peer, 0, len(pm.peers))
+	for _, p := range pm.peers {
+		all = append(all, p)
+	}
+	pm.mu.Unlock()
+	var established []*Peer
+	for _, p := range all {
+		if p.Established() {
+			established = append(established, p)
+		}
+	}
+	return established
+}
+
+func (pm *PeerManager) Broadcast(msg Message) {
+	for _, p := range pm.Peers() {
+		if err := p.Send(msg); err != nil {
+			log.Printf("Error sending %s to %s: %v\n", msg.Command(), p.Address, err)
+		}
+	}
+}
+
+func (pm *PeerManager) Connect(address string) (*Peer, error) {
+	if _, exists := pm.GetPeer(address); exists {
+		return nil, ErrAlreadyConnected
+	}
+	if b := pm.BanList(); b != nil && b.IsBanned(address) {
+		return nil, ErrBanned
+	}
+	conn, err := pm.transport.Dial(address)
 	if err != nil {
 		return nil, err
 	}
-	AddPeer(address, conn)
-	return conn, nil
+	p, err := pm.AddPeer(address, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := p.Send(pm.newVersion()); err != nil {
+		conn.Close()
+		pm.RemovePeer(address)
+		return nil, err
+	}
+	go pm.handleConnection(p)
+	return p, nil
 }
 
-func ListenForPeers(port int) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// Listen starts accepting peers on address in the background.
+func (pm *PeerManager) Listen(address string) error {
+	listener, err := pm.transport.Listen(address)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer listener.Close()
+	pm.mu.Lock()
+	pm.listener = listener
+	pm.listenAddr = listener.Addr().String()
+	pm.mu.Unlock()
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Println("Error accepting connection:", err)
-			continue
+	go func() {
+		var delay time.Duration
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, network.ErrListenerClosed) {
+				return
+			}
+			if err != nil {
+				if delay == 0 {
+					delay = minAcceptDelay
+				} else if delay *= 2; delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				log.Printf("Error accepting connection: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			delay = 0
+			p, err := pm.addPeer(conn.RemoteAddr().String(), conn, true)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go pm.handleConnection(p)
+		}
+	}()
+	return nil
+}
+
+func (pm *PeerManager) ListenAddr() string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.listenAddr
+}
+
+func (pm *PeerManager) Close() {
+	pm.mu.Lock()
+	listener := pm.listener
+	peers := make([]*Peer, 0, len(pm.peers))
+	for _, p := range pm.peers {
+		peers = append(peers, p)
+	}
+	pm.mu.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+	for _, p := range peers {
+		p.Disconnect()
+	}
+}
+
+// Send writes msg to the peer. A peer that cannot take it within
+// writeTimeout is disconnected, since a partial write leaves the stream
+// unusable and would otherwise hold up every caller sending to it.
+func (p *Peer) Send(msg Message) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.Connection.SetWriteDeadline(time.Now().Add(writeTimeout))
+	n, err := WriteMessageN(p.Connection, msg)
+	if err != nil {
+		p.Disconnect()
+		return err
+	}
+	if p.manager != nil {
+		p.manager.count(p.manager.sent, msg.Command(), n)
+	}
+	return nil
+}
+
+func (p *Peer) ID() string {
+	return p.Address
+}
+
+func (p *Peer) StartHeight() int {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if p.Version == nil {
+		return 0
+	}
+	return int(p.Version.StartHeight)
+}
+
+// RemoteVersion returns the version message the peer sent, if any yet.
+func (p *Peer) RemoteVersion() (MsgVersion, bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if p.Version == nil {
+		return MsgVersion{}, false
+	}
+	return *p.Version, true
+}
+
+// ListenAddr is the address the remote side said it accepts connections on.
+func (p *Peer) ListenAddr() string {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if p.Version == nil {
+		return ""
+	}
+	return p.Version.ListenAddr
+}
+
+// Misbehaving adds score to the peer's ban score. Once the total reaches
+// BanThreshold the peer is disconnected and its host banned; the return
+// value reports whether that happened.
+func (p *Peer) Misbehaving(score int, reason string) bool {
+	p.stateMu.Lock()
+	before := p.banScore
+	p.banScore += score
+	after := p.banScore
+	p.stateMu.Unlock()
+	log.Printf("Misbehaving peer %s (%d -> %d): %s\n", p.Address, before, after, reason)
+	if before >= BanThreshold || after < BanThreshold {
+		return after >= BanThreshold
+	}
+	if p.manager != nil {
+		if b := p.manager.BanList(); b != nil {
+			if err := b.Ban(hostOf(p.Address), DefaultBanDuration, reason); err != nil {
+				log.Printf("Error banning %s: %v\n", p.Address, err)
+			}
 		}
-		AddPeer(conn.RemoteAddr().String(), conn)
-		go handleConnection(conn)
 	}
+	p.Disconnect()
+	return true
+}
+
+func (p *Peer) BanScore() int {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return p.banScore
 }
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
-	address := conn.RemoteAddr().String()
-	defer RemovePeer(address)
+func (p *Peer) Disconnect() {
+	p.Connection.Close()
+}
+
+func (p *Peer) Established() bool {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return p.Version != nil && p.verAckRecvd
+}
+
+func (p *Peer) PingRTT() time.Duration {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return p.lastPingRTT
+}
+
+func randomNonce() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatal(err)
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (pm *PeerManager) newVersion() *MsgVersion {
+	height := 0
+	if h := pm.getHandler(); h != nil {
+		height = h.BestHeight()
+	}
+	return &MsgVersion{
+		Version:     ProtocolVersion,
+		Nonce:       pm.nonce,
+		Timestamp:   time.Now().Unix(),
+		UserAgent:   userAgent,
+		StartHeight: int64(height),
+		ListenAddr:  pm.ListenAddr(),
+	}
+}
+
+func (pm *PeerManager) handleConnection(p *Peer) {
+	defer p.Connection.Close()
+	defer pm.RemovePeer(p.Address)
+	defer func() {
+		if p.Established() {
+			for _, l := range pm.getListeners() {
+				l.OnDisconnect(p)
+			}
+		}
+	}()
+
+	p.Connection.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	done := make(chan struct{})
+	defer close(done)
 
 	for {
-		buffer := make([]byte, 1024)
-		n, err := conn.Read(buffer)
+		msg, n, err := ReadMessageN(p.Connection)
+		if msg != nil {
+			pm.count(pm.received, msg.Command(), n)
+		}
+		if errors.Is(err, ErrBadChecksum) || errors.Is(err, ErrMalformedMessage) {
+			// The whole frame was consumed, so the stream is still in sync.
+			if p.Misbehaving(violationScore(err), err.Error()) {
+				return
+			}
+			continue
+		}
+		if errors.Is(err, ErrUnknownCommand) {
+			continue
+		}
 		if err != nil {
-			log.Println("Error reading from connection:", err)
+			if errors.Is(err, ErrBadMagic) || errors.Is(err, ErrPayloadTooLarge) {
+				p.Misbehaving(BanThreshold, err.Error())
+			}
+			log.Printf("Error reading from %s: %v\n", p.Address, err)
+			return
+		}
+		wasEstablished := p.Established()
+		if err := pm.processMessage(p, msg); err != nil {
+			log.Printf("Disconnecting %s: %v\n", p.Address, err)
 			return
 		}
-		log.Printf("Received message from %s: %s\n", address, string(buffer[:n]))
-		processMessage(buffer[:n])
+		if !wasEstablished && p.Established() {
+			p.Connection.SetReadDeadline(time.Time{})
+			go sendHeartbeat(p, done)
+			for _, l := range pm.getListeners() {
+				l.OnConnect(p)
+			}
+		}
+	}
+}
+
+func (pm *PeerManager) processMessage(p *Peer, msg Message) error {
+	switch m := msg.(type) {
+	case *MsgVersion:
+		p.stateMu.Lock()
+		duplicate := p.Version != nil
+		p.stateMu.Unlock()
+		if duplicate {
+			return fmt.Errorf("duplicate version message")
+		}
+		if m.Nonce == pm.nonce {
+			return ErrSelfConnection
+		}
+		if p.Inbound {
+			if err := p.Send(pm.newVersion()); err != nil {
+				return err
+			}
+		}
+		p.stateMu.Lock()
+		p.Version = m
+		p.stateMu.Unlock()
+		return p.Send(&MsgVerAck{})
+	case *MsgVerAck:
+		p.stateMu.Lock()
+		defer p.stateMu.Unlock()
+		if p.Version == nil || p.verAckRecvd {
+			return ErrHandshake
+		}
+		p.verAckRecvd = true
+		return nil
+	}
+
+	if !p.Established() {
+		return ErrHandshake
+	}
+	switch m := msg.(type) {
+	case *MsgPing:
+		return p.Send(&MsgPong{Nonce: m.Nonce})
+	case *MsgPong:
+		p.stateMu.Lock()
+		defer p.stateMu.Unlock()
+		if m.Nonce == p.pingNonce && p.pingNonce != 0 {
+			p.lastPingRTT = time.Since(p.pingSent)
+			p.pingNonce = 0
+		}
+		return nil
 	}
+	for _, l := range pm.getListeners() {
+		l.OnMessage(p, msg)
+	}
+	return nil
 }
 
-func processMessage(msg []byte) {
-	// Implement message parsing and handling logic here
-	log.Printf("Processing message: %s\n", msg)
+func violationScore(err error) int {
+	switch {
+	case errors.Is(err, ErrMalformedMessage):
+		return 50
+	case errors.Is(err, ErrBadChecksum):
+		return 20
+	}
+	return 0
 }
 
-func sendHeartbeat(conn net.Conn) {
-	ticker := time.NewTicker(30 * time.Second)
+// sendHeartbeat pings the peer and drops it if the previous ping is still
+// unanswered after pingTimeout.
+func sendHeartbeat(p *Peer, done chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		_, err := conn.Write([]byte("ping"))
-		if err != nil {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		p.stateMu.Lock()
+		outstanding := p.pingNonce != 0 && time.Since(p.pingSent) > pingTimeout
+		nonce := randomNonce() | 1
+		if !outstanding {
+			p.pingNonce = nonce
+			p.pingSent = time.Now()
+		}
+		p.stateMu.Unlock()
+		if outstanding {
+			log.Printf("Disconnecting %s: %v\n", p.Address, ErrPingTimeout)
+			p.Disconnect()
+			return
+		}
+		if err := p.Send(&MsgPing{Nonce: nonce}); err != nil {
 			log.Println("Error sending heartbeat:", err)
 			return
 		}
@@ -0,0 +1,625 @@
+package peer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/codec"
+	"blockchain-hello-golang/transaction"
+)
+
+const ProtocolVersion = 1
+const NetworkMagic = 0x6f6c6568 // "helo"
+const MaxPayloadSize = 2 << 20
+const MaxInvItems = 50000
+const MaxHeaders = 2000
+const MaxLocatorHashes = 101
+const MaxAddresses = 1000
+const ShortIDSize = 6
+
+const commandSize = 12
+const headerSize = 4 + commandSize + 4 + 4
+
+const (
+	CmdVersion    = "version"
+	CmdVerAck     = "verack"
+	CmdPing       = "ping"
+	CmdPong       = "pong"
+	CmdInv        = "inv"
+	CmdGetData    = "getdata"
+	CmdGetHeaders = "getheaders"
+	CmdHeaders    = "headers"
+	CmdBlock      = "block"
+	CmdTx         = "tx"
+	CmdGetAddr    = "getaddr"
+	CmdAddr       = "addr"
+	CmdSendCmpct  = "sendcmpct"
+	CmdCmpctBlock = "cmpctblock"
+	CmdGetBlkTxn  = "getblocktxn"
+	CmdBlkTxn     = "blocktxn"
+)
+
+var ErrBadMagic = errors.New("peer: bad network magic")
+var ErrBadChecksum = errors.New("peer: payload checksum mismatch")
+var ErrPayloadTooLarge = errors.New("peer: payload exceeds size limit")
+var ErrUnknownCommand = errors.New("peer: unknown command")
+var ErrMalformedMessage = errors.New("peer: malformed message")
+
+type Message interface {
+	Command() string
+	Encode(w *codec.Writer)
+	Decode(r *codec.Reader) error
+}
+
+type InvType uint8
+
+const (
+	InvTx    InvType = 1
+	InvBlock InvType = 2
+	// InvCmpctBlock is only used in getdata, to ask for a block in its
+	// compact form.
+	InvCmpctBlock InvType = 4
+)
+
+type InvVect struct {
+	Type InvType
+	Hash string
+}
+
+type MsgVersion struct {
+	Version     uint32
+	Nonce       uint64
+	Timestamp   int64
+	UserAgent   string
+	StartHeight int64
+	ListenAddr  string
+}
+
+type MsgVerAck struct{}
+
+type MsgPing struct {
+	Nonce uint64
+}
+
+type MsgPong struct {
+	Nonce uint64
+}
+
+type MsgInv struct {
+	Items []InvVect
+}
+
+type MsgGetData struct {
+	Items []InvVect
+}
+
+type MsgGetHeaders struct {
+	Locator  []string
+	StopHash string
+}
+
+type MsgHeaders struct {
+	Headers []block.Block
+}
+
+type MsgBlock struct {
+	Block block.Block
+}
+
+type MsgTx struct {
+	Tx transaction.Transaction
+}
+
+type MsgGetAddr struct{}
+
+type MsgAddr struct {
+	Addresses []string
+}
+
+// MsgSendCmpct asks the peer to announce new blocks to us as compact
+// blocks instead of inv.
+type MsgSendCmpct struct {
+	HighBandwidth bool
+}
+
+type PrefilledTx struct {
+	Index int
+	Tx    transaction.Transaction
+}
+
+// MsgCmpctBlock carries a block header and 6-byte short IDs for every
+// transaction not sent in full in Prefilled. Prefilled indexes are absolute
+// positions in the block.
+type MsgCmpctBlock struct {
+	Header    block.Block
+	Nonce     uint64
+	ShortIDs  []uint64
+	Prefilled []PrefilledTx
+}
+
+type MsgGetBlockTxn struct {
+	BlockHash string
+	Indexes   []int
+}
+
+type MsgBlockTxn struct {
+	BlockHash string
+	Txs       []transaction.Transaction
+}
+
+func (m *MsgVersion) Command() string     { return CmdVersion }
+func (m *MsgVerAck) Command() string      { return CmdVerAck }
+func (m *MsgPing) Command() string        { return CmdPing }
+func (m *MsgPong) Command() string        { return CmdPong }
+func (m *MsgInv) Command() string         { return CmdInv }
+func (m *MsgGetData) Command() string     { return CmdGetData }
+func (m *MsgGetHeaders) Command() string  { return CmdGetHeaders }
+func (m *MsgHeaders) Command() string     { return CmdHeaders }
+func (m *MsgBlock) Command() string       { return CmdBlock }
+func (m *MsgTx) Command() string          { return CmdTx }
+func (m *MsgGetAddr) Command() string     { return CmdGetAddr }
+func (m *MsgAddr) Command() string        { return CmdAddr }
+func (m *MsgSendCmpct) Command() string   { return CmdSendCmpct }
+func (m *MsgCmpctBlock) Command() string  { return CmdCmpctBlock }
+func (m *MsgGetBlockTxn) Command() string { return CmdGetBlkTxn }
+func (m *MsgBlockTxn) Command() string    { return CmdBlkTxn }
+
+func (m *MsgVersion) Encode(w *codec.Writer) {
+	w.WriteUint32(m.Version)
+	w.WriteUint64(m.Nonce)
+	w.WriteInt64(m.Timestamp)
+	w.WriteString(m.UserAgent)
+	w.WriteInt64(m.StartHeight)
+	w.WriteString(m.ListenAddr)
+}
+
+func (m *MsgVersion) Decode(r *codec.Reader) error {
+	var err error
+	if m.Version, err = r.ReadUint32(); err != nil {
+		return err
+	}
+	if m.Nonce, err = r.ReadUint64(); err != nil {
+		return err
+	}
+	if m.Timestamp, err = r.ReadInt64(); err != nil {
+		return err
+	}
+	if m.UserAgent, err = r.ReadString(); err != nil {
+		return err
+	}
+	if m.StartHeight, err = r.ReadInt64(); err != nil {
+		return err
+	}
+	m.ListenAddr, err = r.ReadString()
+	return err
+}
+
+func (m *MsgVerAck) Encode(w *codec.Writer)       {}
+func (m *MsgVerAck) Decode(r *codec.Reader) error { return nil }
+
+func (m *MsgPing) Encode(w *codec.Writer) { w.WriteUint64(m.Nonce) }
+func (m *MsgPong) Encode(w *codec.Writer) { w.WriteUint64(m.Nonce) }
+
+func (m *MsgPing) Decode(r *codec.Reader) error {
+	var err error
+	m.Nonce, err = r.ReadUint64()
+	return err
+}
+
+func (m *MsgPong) Decode(r *codec.Reader) error {
+	var err error
+	m.Nonce, err = r.ReadUint64()
+	return err
+}
+
+func (m *MsgInv) Encode(w *codec.Writer)     { encodeInv(w, m.Items) }
+func (m *MsgGetData) Encode(w *codec.Writer) { encodeInv(w, m.Items) }
+
+func (m *MsgInv) Decode(r *codec.Reader) error {
+	var err error
+	m.Items, err = decodeInv(r)
+	return err
+}
+
+func (m *MsgGetData) Decode(r *codec.Reader) error {
+	var err error
+	m.Items, err = decodeInv(r)
+	return err
+}
+
+func encodeInv(w *codec.Writer, items []InvVect) {
+	w.WriteVarInt(uint64(len(items)))
+	for _, item := range items {
+		w.WriteUint8(uint8(item.Type))
+		w.WriteString(item.Hash)
+	}
+}
+
+func decodeInv(r *codec.Reader) ([]InvVect, error) {
+	n, err := r.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxInvItems {
+		return nil, fmt.Errorf("peer: %d inventory items exceeds limit", n)
+	}
+	items := make([]InvVect, 0, n)
+	for i := 0; i < n; i++ {
+		kind, err := r.ReadUint8()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, InvVect{Type: InvType(kind), Hash: hash})
+	}
+	return items, nil
+}
+
+func (m *MsgGetHeaders) Encode(w *codec.Writer) {
+	w.WriteVarInt(uint64(len(m.Locator)))
+	for _, hash := range m.Locator {
+		w.WriteString(hash)
+	}
+	w.WriteString(m.StopHash)
+}
+
+func (m *MsgGetHeaders) Decode(r *codec.Reader) error {
+	n, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	if n > MaxLocatorHashes {
+		return fmt.Errorf("peer: %d locator hashes exceeds limit", n)
+	}
+	m.Locator = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hash, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		m.Locator = append(m.Locator, hash)
+	}
+	m.StopHash, err = r.ReadString()
+	return err
+}
+
+func (m *MsgHeaders) Encode(w *codec.Writer) {
+	w.WriteVarInt(uint64(len(m.Headers)))
+	for _, header := range m.Headers {
+		w.WriteBytes(block.EncodeHeader(header))
+	}
+}
+
+func (m *MsgHeaders) Decode(r *codec.Reader) error {
+	n, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	if n > MaxHeaders {
+		return fmt.Errorf("peer: %d headers exceeds limit", n)
+	}
+	m.Headers = make([]block.Block, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := r.ReadBytes()
+		if err != nil {
+			return err
+		}
+		header, err := block.DecodeHeader(data)
+		if err != nil {
+			return err
+		}
+		m.Headers = append(m.Headers, header)
+	}
+	return nil
+}
+
+func (m *MsgBlock) Encode(w *codec.Writer) { w.WriteBytes(block.Encode(m.Block)) }
+func (m *MsgTx) Encode(w *codec.Writer)    { w.WriteBytes(transaction.Encode(m.Tx)) }
+
+func (m *MsgBlock) Decode(r *codec.Reader) error {
+	data, err := r.ReadBytes()
+	if err != nil {
+		return err
+	}
+	m.Block, err = block.Decode(data)
+	return err
+}
+
+func (m *MsgTx) Decode(r *codec.Reader) error {
+	data, err := r.ReadBytes()
+	if err != nil {
+		return err
+	}
+	m.Tx, err = transaction.Decode(data)
+	return err
+}
+
+func (m *MsgGetAddr) Encode(w *codec.Writer)       {}
+func (m *MsgGetAddr) Decode(r *codec.Reader) error { return nil }
+
+func (m *MsgAddr) Encode(w *codec.Writer) {
+	w.WriteVarInt(uint64(len(m.Addresses)))
+	for _, addr := range m.Addresses {
+		w.WriteString(addr)
+	}
+}
+
+func (m *MsgAddr) Decode(r *codec.Reader) error {
+	n, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	if n > MaxAddresses {
+		return fmt.Errorf("peer: %d addresses exceeds limit", n)
+	}
+	m.Addresses = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		addr, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		m.Addresses = append(m.Addresses, addr)
+	}
+	return nil
+}
+
+func (m *MsgSendCmpct) Encode(w *codec.Writer) {
+	if m.HighBandwidth {
+		w.WriteUint8(1)
+	} else {
+		w.WriteUint8(0)
+	}
+}
+
+func (m *MsgSendCmpct) Decode(r *codec.Reader) error {
+	v, err := r.ReadUint8()
+	m.HighBandwidth = v == 1
+	return err
+}
+
+func (m *MsgCmpctBlock) Encode(w *codec.Writer) {
+	w.WriteBytes(block.EncodeHeader(m.Header))
+	w.WriteUint64(m.Nonce)
+	ids := make([]byte, 0, len(m.ShortIDs)*ShortIDSize)
+	for _, id := range m.ShortIDs {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], id)
+		ids = append(ids, buf[:ShortIDSize]...)
+	}
+	w.WriteBytes(ids)
+	w.WriteVarInt(uint64(len(m.Prefilled)))
+	for _, p := range m.Prefilled {
+		w.WriteVarInt(uint64(p.Index))
+		w.WriteBytes(transaction.Encode(p.Tx))
+	}
+}
+
+func (m *MsgCmpctBlock) Decode(r *codec.Reader) error {
+	data, err := r.ReadBytes()
+	if err != nil {
+		return err
+	}
+	if m.Header, err = block.DecodeHeader(data); err != nil {
+		return err
+	}
+	if m.Nonce, err = r.ReadUint64(); err != nil {
+		return err
+	}
+	ids, err := r.ReadBytes()
+	if err != nil {
+		return err
+	}
+	if len(ids)%ShortIDSize != 0 {
+		return fmt.Errorf("peer: short ID data of %d bytes", len(ids))
+	}
+	m.ShortIDs = make([]uint64, 0, len(ids)/ShortIDSize)
+	for i := 0; i < len(ids); i += ShortIDSize {
+		var buf [8]byte
+		copy(buf[:], ids[i:i+ShortIDSize])
+		m.ShortIDs = append(m.ShortIDs, binary.LittleEndian.Uint64(buf[:]))
+	}
+	n, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	m.Prefilled = make([]PrefilledTx, 0, n)
+	for i := 0; i < n; i++ {
+		index, err := r.ReadVarInt()
+		if err != nil {
+			return err
+		}
+		data, err := r.ReadBytes()
+		if err != nil {
+			return err
+		}
+		tx, err := transaction.Decode(data)
+		if err != nil {
+			return err
+		}
+		if index >= uint64(len(m.ShortIDs)+n) {
+			return fmt.Errorf("peer: prefilled index %d out of range", index)
+		}
+		m.Prefilled = append(m.Prefilled, PrefilledTx{Index: int(index), Tx: tx})
+	}
+	return nil
+}
+
+func (m *MsgGetBlockTxn) Encode(w *codec.Writer) {
+	w.WriteString(m.BlockHash)
+	w.WriteVarInt(uint64(len(m.Indexes)))
+	for _, index := range m.Indexes {
+		w.WriteVarInt(uint64(index))
+	}
+}
+
+func (m *MsgGetBlockTxn) Decode(r *codec.Reader) error {
+	var err error
+	if m.BlockHash, err = r.ReadString(); err != nil {
+		return err
+	}
+	n, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	m.Indexes = make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		index, err := r.ReadVarInt()
+		if err != nil {
+			return err
+		}
+		if index > MaxPayloadSize {
+			return fmt.Errorf("peer: transaction index %d out of range", index)
+		}
+		m.Indexes = append(m.Indexes, int(index))
+	}
+	return nil
+}
+
+func (m *MsgBlockTxn) Encode(w *codec.Writer) {
+	w.WriteString(m.BlockHash)
+	w.WriteVarInt(uint64(len(m.Txs)))
+	for _, tx := range m.Txs {
+		w.WriteBytes(transaction.Encode(tx))
+	}
+}
+
+func (m *MsgBlockTxn) Decode(r *codec.Reader) error {
+	var err error
+	if m.BlockHash, err = r.ReadString(); err != nil {
+		return err
+	}
+	n, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	m.Txs = make([]transaction.Transaction, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := r.ReadBytes()
+		if err != nil {
+			return err
+		}
+		tx, err := transaction.Decode(data)
+		if err != nil {
+			return err
+		}
+		m.Txs = append(m.Txs, tx)
+	}
+	return nil
+}
+
+func newMessage(command string) (Message, error) {
+	switch command {
+	case CmdVersion:
+		return &MsgVersion{}, nil
+	case CmdVerAck:
+		return &MsgVerAck{}, nil
+	case CmdPing:
+		return &MsgPing{}, nil
+	case CmdPong:
+		return &MsgPong{}, nil
+	case CmdInv:
+		return &MsgInv{}, nil
+	case CmdGetData:
+		return &MsgGetData{}, nil
+	case CmdGetHeaders:
+		return &MsgGetHeaders{}, nil
+	case CmdHeaders:
+		return &MsgHeaders{}, nil
+	case CmdBlock:
+		return &MsgBlock{}, nil
+	case CmdTx:
+		return &MsgTx{}, nil
+	case CmdGetAddr:
+		return &MsgGetAddr{}, nil
+	case CmdAddr:
+		return &MsgAddr{}, nil
+	case CmdSendCmpct:
+		return &MsgSendCmpct{}, nil
+	case CmdCmpctBlock:
+		return &MsgCmpctBlock{}, nil
+	case CmdGetBlkTxn:
+		return &MsgGetBlockTxn{}, nil
+	case CmdBlkTxn:
+		return &MsgBlockTxn{}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+}
+
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+// WriteMessage frames msg as magic | command | length | checksum | payload.
+func WriteMessage(w io.Writer, msg Message) error {
+	_, err := WriteMessageN(w, msg)
+	return err
+}
+
+// WriteMessageN is WriteMessage that also reports the bytes written.
+func WriteMessageN(w io.Writer, msg Message) (int, error) {
+	cw := codec.NewWriter()
+	msg.Encode(cw)
+	payload := cw.Bytes()
+	if len(payload) > MaxPayloadSize {
+		return 0, ErrPayloadTooLarge
+	}
+	header := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(header[0:4], NetworkMagic)
+	copy(header[4:4+commandSize], msg.Command())
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(payload)))
+	copy(header[20:24], checksum(payload))
+	return w.Write(append(header, payload...))
+}
+
+func ReadMessage(r io.Reader) (Message, error) {
+	msg, _, err := ReadMessageN(r)
+	return msg, err
+}
+
+// ReadMessageN is ReadMessage that also reports the bytes read, including
+// those of a frame that failed to decode.
+func ReadMessageN(r io.Reader) (Message, int, error) {
+	header := make([]byte, headerSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		return nil, n, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != NetworkMagic {
+		return nil, headerSize, ErrBadMagic
+	}
+	command := string(bytes.TrimRight(header[4:4+commandSize], "\x00"))
+	length := binary.LittleEndian.Uint32(header[16:20])
+	if length > MaxPayloadSize {
+		return nil, headerSize, ErrPayloadTooLarge
+	}
+	payload := make([]byte, length)
+	n, err := io.ReadFull(r, payload)
+	n += headerSize
+	if err != nil {
+		return nil, n, err
+	}
+	if !bytes.Equal(checksum(payload), header[20:24]) {
+		return nil, n, ErrBadChecksum
+	}
+	msg, err := newMessage(command)
+	if err != nil {
+		return nil, n, err
+	}
+	cr := codec.NewReader(payload)
+	if err := msg.Decode(cr); err != nil {
+		return nil, n, fmt.Errorf("%w: decoding %s: %v", ErrMalformedMessage, command, err)
+	}
+	if err := cr.Done(); err != nil {
+		return nil, n, fmt.Errorf("%w: %s: %v", ErrMalformedMessage, command, err)
+	}
+	return msg, n, nil
+}
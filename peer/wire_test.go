@@ -0,0 +1,38 @@
+package peer
+
+import (
+	"bytes"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/transaction"
+)
+
+func TestCmpctBlockPrefilledIndex(t *testing.T) {
+	header := block.Block{Index: 1, PrevHash: "parent", Timestamp: 1}
+	header.Hash = block.CalculateHash(header)
+	tx := transaction.Transaction{ID: "tx", Outputs: []transaction.Output{{Value: 1}}}
+	tests := []struct {
+		name  string
+		index int
+		ok    bool
+	}{
+		{"first", 0, true},
+		{"last", 2, true},
+		{"one past the end", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Two short IDs plus one prefilled transaction make three slots.
+			msg := &MsgCmpctBlock{Header: header, ShortIDs: []uint64{1, 2}, Prefilled: []PrefilledTx{{Index: tt.index, Tx: tx}}}
+			var buf bytes.Buffer
+			if err := WriteMessage(&buf, msg); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ReadMessage(&buf)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package relay
+
+import (
+	"errors"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/compact"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/validation"
+)
+
+// CompactStore is a Store that can rebuild compact blocks from its
+// mempool. Relays over such a store ask peers for compact blocks and hand
+// every block they rebuild to AcceptBlock.
+type CompactStore interface {
+	Store
+	MempoolTransactions() []transaction.Transaction
+	AcceptBlock(p *peer.Peer, blk block.Block)
+}
+
+// pendingBlock is a compact block waiting for the blocktxn that fills it.
+type pendingBlock struct {
+	peer    *peer.Peer
+	partial *compact.PartialBlock
+	sent    time.Time
+}
+
+func (r *Relay) compactBlock(hash string) (*peer.MsgCmpctBlock, bool) {
+	msg, ok := r.store.Get(peer.InvVect{Type: peer.InvBlock, Hash: hash})
+	if !ok {
+		return nil, false
+	}
+	full, ok := msg.(*peer.MsgBlock)
+	if !ok {
+		return nil, false
+	}
+	return compact.New(full.Block, r.nonce), true
+}
+
+func (r *Relay) handleCmpctBlock(p *peer.Peer, m *peer.MsgCmpctBlock) {
+	cs, ok := r.store.(CompactStore)
+	if !ok {
+		return
+	}
+	// A header without valid proof of work is not worth rebuilding or
+	// asking the peer more about.
+	if err := validation.CheckBlockHeader(m.Header, time.Now()); err != nil {
+		if validation.IsRuleError(err) {
+			p.Misbehaving(peer.BanThreshold, err.Error())
+		}
+		return
+	}
+	iv := peer.InvVect{Type: peer.InvBlock, Hash: m.Header.Hash}
+	have := r.store.Have(iv)
+	r.mu.Lock()
+	if state, exists := r.states[p.ID()]; exists {
+		state.known.add(iv)
+	}
+	_, inProgress := r.pending[iv.Hash]
+	if have || inProgress {
+		r.mu.Unlock()
+		return
+	}
+	if _, requested := r.requested[iv]; !requested {
+		r.requested[iv] = &request{peer: p.ID(), sent: time.Now()}
+	}
+	r.mu.Unlock()
+
+	partial, err := compact.Reconstruct(m, cs.MempoolTransactions())
+	if errors.Is(err, compact.ErrBadIndex) {
+		p.Misbehaving(peer.BanThreshold, err.Error())
+		return
+	}
+	if err != nil {
+		r.requestFullBlock(p, iv)
+		return
+	}
+	missing := partial.Missing()
+	if len(missing) == 0 {
+		r.completeBlock(p, partial)
+		return
+	}
+	r.mu.Lock()
+	r.pending[iv.Hash] = &pendingBlock{peer: p, partial: partial, sent: time.Now()}
+	r.mu.Unlock()
+	p.Send(&peer.MsgGetBlockTxn{BlockHash: iv.Hash, Indexes: missing})
+}
+
+func (r *Relay) handleGetBlockTxn(p *peer.Peer, m *peer.MsgGetBlockTxn) {
+	msg, ok := r.store.Get(peer.InvVect{Type: peer.InvBlock, Hash: m.BlockHash})
+	if !ok {
+		return
+	}
+	full, ok := msg.(*peer.MsgBlock)
+	if !ok {
+		return
+	}
+	resp, err := compact.BlockTxn(full.Block, m.Indexes)
+	if err != nil {
+		p.Misbehaving(peer.BanThreshold, err.Error())
+		return
+	}
+	p.Send(resp)
+}
+
+func (r *Relay) handleBlockTxn(p *peer.Peer, m *peer.MsgBlockTxn) {
+	r.mu.Lock()
+	pb, exists := r.pending[m.BlockHash]
+	if !exists || pb.peer != p {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.pending, m.BlockHash)
+	r.mu.Unlock()
+
+	if err := pb.partial.Fill(m.Txs); err != nil {
+		r.requestFullBlock(p, peer.InvVect{Type: peer.InvBlock, Hash: m.BlockHash})
+		return
+	}
+	r.completeBlock(p, pb.partial)
+}
+
+func (r *Relay) completeBlock(p *peer.Peer, partial *compact.PartialBlock) {
+	iv := peer.InvVect{Type: peer.InvBlock, Hash: partial.Hash()}
+	blk, err := partial.Block()
+	if err != nil {
+		r.requestFullBlock(p, iv)
+		return
+	}
+	r.received(p, iv)
+	r.store.(CompactStore).AcceptBlock(p, blk)
+}
+
+// requestFullBlock falls back to getdata when a compact block cannot be
+// rebuilt, for instance after a short ID collision.
+func (r *Relay) requestFullBlock(p *peer.Peer, iv peer.InvVect) {
+	r.mu.Lock()
+	delete(r.pending, iv.Hash)
+	r.requested[iv] = &request{peer: p.ID(), sent: time.Now()}
+	r.mu.Unlock()
+	p.Send(&peer.MsgGetData{Items: []peer.InvVect{iv}})
+}
+
+// expirePending drops reconstructions whose blocktxn never came; the
+// request timeout takes care of fetching the block elsewhere. The caller
+// must hold r.mu.
+func (r *Relay) expirePending(now time.Time) {
+	for hash, pb := range r.pending {
+		if now.Sub(pb.sent) > requestTimeout {
+			delete(r.pending, hash)
+		}
+	}
+}
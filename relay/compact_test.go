@@ -0,0 +1,241 @@
+package relay
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/compact"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/network"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/transaction"
+)
+
+// testNode is the handler and store of one peer manager in a simulated
+// network. Blocks it accepts, whether rebuilt or received in full, come
+// out of accepted.
+type testNode struct {
+	pm       *peer.PeerManager
+	relay    *Relay
+	accepted chan block.Block
+
+	mu      sync.Mutex
+	blocks  map[string]block.Block
+	mempool []transaction.Transaction
+}
+
+func newTestNode(t *testing.T, net *network.ChannelNetwork, address string, compactBlocks bool) *testNode {
+	t.Helper()
+	n := &testNode{accepted: make(chan block.Block, 1), blocks: make(map[string]block.Block)}
+	n.pm = peer.NewPeerManager(net.Transport(address), n)
+	var store Store = n
+	if !compactBlocks {
+		store = struct{ Store }{n}
+	}
+	n.relay = New(n.pm, store, Config{Blocks: true, HighBandwidthPeers: 1})
+	if err := n.pm.Listen(address); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.pm.Close)
+	return n
+}
+
+func (n *testNode) BestHeight() int           { return 0 }
+func (n *testNode) OnConnect(p *peer.Peer)    {}
+func (n *testNode) OnDisconnect(p *peer.Peer) {}
+
+func (n *testNode) OnMessage(p *peer.Peer, msg peer.Message) {
+	if m, ok := msg.(*peer.MsgBlock); ok {
+		n.AcceptBlock(p, m.Block)
+	}
+}
+
+func (n *testNode) Have(iv peer.InvVect) bool {
+	_, ok := n.Get(iv)
+	return ok
+}
+
+func (n *testNode) Get(iv peer.InvVect) (peer.Message, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch iv.Type {
+	case peer.InvBlock:
+		if blk, ok := n.blocks[iv.Hash]; ok {
+			return &peer.MsgBlock{Block: blk}, true
+		}
+	case peer.InvTx:
+		for _, tx := range n.mempool {
+			if tx.ID == iv.Hash {
+				return &peer.MsgTx{Tx: tx}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (n *testNode) MempoolTransactions() []transaction.Transaction {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]transaction.Transaction(nil), n.mempool...)
+}
+
+func (n *testNode) AcceptBlock(p *peer.Peer, blk block.Block) {
+	n.mu.Lock()
+	n.blocks[blk.Hash] = blk
+	n.mu.Unlock()
+	n.accepted <- blk
+}
+
+// connect links a to b and waits until both relays have seen the other's
+// sendcmpct, if either sends one.
+func connect(t *testing.T, a, b *testNode, compactBlocks bool) {
+	t.Helper()
+	if _, err := a.pm.Connect(b.pm.ListenAddr()); err != nil {
+		t.Fatal(err)
+	}
+	ready := func(n *testNode) bool {
+		n.relay.mu.Lock()
+		defer n.relay.mu.Unlock()
+		for _, state := range n.relay.states {
+			return state.compact == compactBlocks
+		}
+		return false
+	}
+	for deadline := time.Now().Add(5 * time.Second); !ready(a) || !ready(b); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("peers did not finish connecting")
+		}
+	}
+}
+
+func waitForBlock(t *testing.T, n *testNode, hash string) {
+	t.Helper()
+	select {
+	case blk := <-n.accepted:
+		if blk.Hash != hash || block.CalculateMerkleRoot(blk.Transactions) != blk.MerkleRoot {
+			t.Fatalf("accepted block %s does not match %s", blk.Hash, hash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("block never arrived")
+	}
+}
+
+func testTx(tag string) transaction.Transaction {
+	tx := transaction.Transaction{
+		Inputs:  []transaction.Input{{PrevTxID: tag, Signature: make([]byte, 72), PubKey: make([]byte, 33)}},
+		Outputs: []transaction.Output{{Value: 1000, ScriptPubKey: "76a914000000000000000000000000000000000000000088ac"}},
+	}
+	tx.ID = transaction.CalculateID(tx)
+	return tx
+}
+
+// testBlock solves a block holding a coinbase and txs.
+func testBlock(txs []transaction.Transaction) block.Block {
+	coinbase := transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 50}}}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	all := append([]transaction.Transaction{coinbase}, txs...)
+	blk := block.Block{Index: 1, PrevHash: "parent", Timestamp: time.Now().Unix(), Transactions: all, Bits: concensus.PowLimitBits}
+	blk.MerkleRoot = block.CalculateMerkleRoot(all)
+	return concensus.SolveBlock(blk)
+}
+
+func TestCompactBlockRelay(t *testing.T) {
+	var txs []transaction.Transaction
+	for i := 0; i < 10; i++ {
+		txs = append(txs, testTx(fmt.Sprint("funding", i)))
+	}
+	blk := testBlock(txs)
+	decoy := testTx("decoy")
+	relayBlock := func(sender *testNode) { sender.relay.RelayBlock(blk.Hash) }
+
+	tests := []struct {
+		name    string
+		mempool []transaction.Transaction
+		send    func(sender *testNode)
+		// Messages sent by the sender and the receiver, by command.
+		sent     map[string]int
+		received map[string]int
+	}{
+		{"rebuilt from the mempool", txs, relayBlock,
+			map[string]int{peer.CmdCmpctBlock: 1},
+			map[string]int{}},
+		{"missing transactions fetched", txs[3:8], relayBlock,
+			map[string]int{peer.CmdCmpctBlock: 1, peer.CmdBlkTxn: 1},
+			map[string]int{peer.CmdGetBlkTxn: 1}},
+		{"short ID collision falls back to the full block", append([]transaction.Transaction{decoy}, txs...), func(sender *testNode) {
+			// Announce blk with decoy's short ID in one slot, as if the
+			// two collided.
+			fake := blk
+			fake.Transactions = append([]transaction.Transaction(nil), blk.Transactions...)
+			fake.Transactions[4] = decoy
+			sender.pm.Peers()[0].Send(compact.New(fake, 1))
+		}, map[string]int{peer.CmdCmpctBlock: 1, peer.CmdBlock: 1},
+			map[string]int{peer.CmdGetData: 1}},
+		{"duplicate short IDs fall back to the full block", txs, func(sender *testNode) {
+			msg := compact.New(blk, 1)
+			msg.ShortIDs[1] = msg.ShortIDs[0]
+			sender.pm.Peers()[0].Send(msg)
+		}, map[string]int{peer.CmdCmpctBlock: 1, peer.CmdBlock: 1},
+			map[string]int{peer.CmdGetData: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			net := network.NewChannelNetwork()
+			sender := newTestNode(t, net, "sender", true)
+			receiver := newTestNode(t, net, "receiver", true)
+			sender.blocks[blk.Hash] = blk
+			receiver.mempool = tt.mempool
+			connect(t, sender, receiver, true)
+
+			tt.send(sender)
+			waitForBlock(t, receiver, blk.Hash)
+			for n, want := range map[*testNode]map[string]int{sender: tt.sent, receiver: tt.received} {
+				sent, _ := n.pm.TrafficStats()
+				for _, cmd := range []string{peer.CmdCmpctBlock, peer.CmdGetBlkTxn, peer.CmdBlkTxn, peer.CmdGetData, peer.CmdBlock} {
+					if got := sent[cmd].Messages; got != want[cmd] {
+						t.Fatalf("%s sent %d %s, want %d", n.pm.ListenAddr(), got, cmd, want[cmd])
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestCompactBlocksSaveBandwidth relays the same block between two nodes
+// of a simulated network with and without compact blocks and compares the
+// bytes the traffic counters saw.
+func TestCompactBlocksSaveBandwidth(t *testing.T) {
+	var txs []transaction.Transaction
+	for i := 0; i < 200; i++ {
+		txs = append(txs, testTx(fmt.Sprint("funding", i)))
+	}
+	blk := testBlock(txs)
+	blockRelay := []string{peer.CmdInv, peer.CmdGetData, peer.CmdBlock, peer.CmdCmpctBlock, peer.CmdGetBlkTxn, peer.CmdBlkTxn}
+
+	bytes := make(map[bool]int)
+	for _, compactBlocks := range []bool{false, true} {
+		net := network.NewChannelNetwork()
+		sender := newTestNode(t, net, "sender", compactBlocks)
+		receiver := newTestNode(t, net, "receiver", compactBlocks)
+		sender.blocks[blk.Hash] = blk
+		// The receiver has all but a few of the transactions already.
+		receiver.mempool = txs[5:]
+		connect(t, sender, receiver, compactBlocks)
+
+		sender.relay.RelayBlock(blk.Hash)
+		waitForBlock(t, receiver, blk.Hash)
+		for _, n := range []*testNode{sender, receiver} {
+			sent, _ := n.pm.TrafficStats()
+			for _, cmd := range blockRelay {
+				bytes[compactBlocks] += sent[cmd].Bytes
+			}
+		}
+	}
+	t.Logf("block relay: %d bytes full, %d bytes compact", bytes[false], bytes[true])
+	if bytes[true]*5 > bytes[false] {
+		t.Fatalf("compact blocks used %d bytes against %d for full blocks", bytes[true], bytes[false])
+	}
+}
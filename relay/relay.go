@@ -0,0 +1,421 @@
+package relay
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/peer"
+)
+
+const maxKnownInventory = 5000
+const requestTimeout = 30 * time.Second
+const tickInterval = 100 * time.Millisecond
+const DefaultTrickleInterval = 5 * time.Second
+
+// Store is where the relay looks up inventory to decide what to fetch and
+// to answer getdata.
+type Store interface {
+	Have(iv peer.InvVect) bool
+	Get(iv peer.InvVect) (peer.Message, bool)
+}
+
+type Config struct {
+	// TrickleInterval is the mean delay before queued transaction
+	// announcements go out to a peer. Each peer gets its own random
+	// schedule so the first peer to hear of a tx says little about its
+	// origin.
+	TrickleInterval time.Duration
+	// Blocks makes the relay fetch announced blocks and serve block
+	// getdata itself. Leave it off when a netsync.Manager owns block
+	// download.
+	Blocks bool
+	// HighBandwidthPeers is how many peers are asked to push new blocks as
+	// cmpctblock without announcing them first. That saves a round trip
+	// but every extra peer mostly adds duplicate pushes; the rest announce
+	// with inv and we fetch the compact form with getdata.
+	HighBandwidthPeers int
+}
+
+// knownInventory is a bounded set of what a peer is known to have, so we
+// never announce or send it the same item twice.
+type knownInventory struct {
+	items map[peer.InvVect]bool
+	order []peer.InvVect
+}
+
+func (k *knownInventory) add(iv peer.InvVect) {
+	if k.items[iv] {
+		return
+	}
+	if len(k.order) >= maxKnownInventory {
+		delete(k.items, k.order[0])
+		k.order = k.order[1:]
+	}
+	k.items[iv] = true
+	k.order = append(k.order, iv)
+}
+
+type peerState struct {
+	peer        *peer.Peer
+	known       knownInventory
+	txQueue     []peer.InvVect
+	nextTrickle time.Time
+	// compact is set once the peer asks for new blocks to be pushed as
+	// cmpctblock; highBandwidth once we have asked the same of it.
+	compact       bool
+	highBandwidth bool
+}
+
+// request tracks one item being fetched. Other peers that announce it
+// while the request is outstanding are kept as fallbacks.
+type request struct {
+	peer      string
+	sent      time.Time
+	fallbacks []string
+}
+
+// Relay announces new blocks and transactions with inv, fetches announced
+// items with getdata and serves getdata from its Store.
+type Relay struct {
+	mu        sync.Mutex
+	store     Store
+	cfg       Config
+	states    map[string]*peerState
+	requested map[peer.InvVect]*request
+	pending   map[string]*pendingBlock
+	nonce     uint64
+	quit      chan struct{}
+}
+
+func New(peers *peer.PeerManager, store Store, cfg Config) *Relay {
+	if cfg.TrickleInterval <= 0 {
+		cfg.TrickleInterval = DefaultTrickleInterval
+	}
+	r := &Relay{
+		store:     store,
+		cfg:       cfg,
+		states:    make(map[string]*peerState),
+		requested: make(map[peer.InvVect]*request),
+		pending:   make(map[string]*pendingBlock),
+		nonce:     rand.Uint64(),
+		quit:      make(chan struct{}),
+	}
+	peers.AddListener(r)
+	return r
+}
+
+func (r *Relay) Start() {
+	go r.loop()
+}
+
+func (r *Relay) Stop() {
+	close(r.quit)
+}
+
+// RelayBlock announces a block to every peer not known to have it, as a
+// compact block to peers that asked for those and with inv to the rest.
+func (r *Relay) RelayBlock(hash string) {
+	iv := peer.InvVect{Type: peer.InvBlock, Hash: hash}
+	r.mu.Lock()
+	var targets, compactTargets []*peer.Peer
+	for _, state := range r.states {
+		if state.known.items[iv] {
+			continue
+		}
+		state.known.add(iv)
+		if state.compact {
+			compactTargets = append(compactTargets, state.peer)
+		} else {
+			targets = append(targets, state.peer)
+		}
+	}
+	r.mu.Unlock()
+	if len(compactTargets) > 0 {
+		if msg, ok := r.compactBlock(hash); ok {
+			for _, p := range compactTargets {
+				p.Send(msg)
+			}
+		} else {
+			targets = append(targets, compactTargets...)
+		}
+	}
+	for _, p := range targets {
+		p.Send(&peer.MsgInv{Items: []peer.InvVect{iv}})
+	}
+}
+
+// RelayTransaction queues a transaction announcement for every peer not
+// known to have it. The queues are flushed on each peer's trickle schedule.
+func (r *Relay) RelayTransaction(id string) {
+	iv := peer.InvVect{Type: peer.InvTx, Hash: id}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, state := range r.states {
+		if !state.known.items[iv] {
+			state.txQueue = append(state.txQueue, iv)
+		}
+	}
+}
+
+func (r *Relay) OnConnect(p *peer.Peer) {
+	_, compactStore := r.store.(CompactStore)
+	r.mu.Lock()
+	state := &peerState{
+		peer:        p,
+		known:       knownInventory{items: make(map[peer.InvVect]bool)},
+		nextTrickle: r.nextTrickle(time.Now()),
+	}
+	state.highBandwidth = compactStore && r.highBandwidthPeers() < r.cfg.HighBandwidthPeers
+	r.states[p.ID()] = state
+	r.mu.Unlock()
+	if compactStore {
+		p.Send(&peer.MsgSendCmpct{HighBandwidth: state.highBandwidth})
+	}
+}
+
+func (r *Relay) OnDisconnect(p *peer.Peer) {
+	r.mu.Lock()
+	var promoted *peer.Peer
+	if state, exists := r.states[p.ID()]; exists && state.highBandwidth {
+		for _, other := range r.states {
+			if other != state && !other.highBandwidth {
+				other.highBandwidth = true
+				promoted = other.peer
+				break
+			}
+		}
+	}
+	delete(r.states, p.ID())
+	for hash, pb := range r.pending {
+		if pb.peer == p {
+			delete(r.pending, hash)
+		}
+	}
+	retries := r.reassign(func(req *request) bool { return req.peer == p.ID() })
+	r.mu.Unlock()
+	if promoted != nil {
+		promoted.Send(&peer.MsgSendCmpct{HighBandwidth: true})
+	}
+	r.sendRequests(retries)
+}
+
+func (r *Relay) OnMessage(p *peer.Peer, msg peer.Message) {
+	switch m := msg.(type) {
+	case *peer.MsgInv:
+		r.handleInv(p, m.Items)
+	case *peer.MsgGetData:
+		r.serve(p, m.Items)
+	case *peer.MsgTx:
+		r.received(p, peer.InvVect{Type: peer.InvTx, Hash: m.Tx.ID})
+	case *peer.MsgBlock:
+		r.received(p, peer.InvVect{Type: peer.InvBlock, Hash: m.Block.Hash})
+	case *peer.MsgSendCmpct:
+		r.mu.Lock()
+		if state, exists := r.states[p.ID()]; exists {
+			state.compact = m.HighBandwidth
+		}
+		r.mu.Unlock()
+	case *peer.MsgCmpctBlock:
+		r.handleCmpctBlock(p, m)
+	case *peer.MsgGetBlockTxn:
+		r.handleGetBlockTxn(p, m)
+	case *peer.MsgBlockTxn:
+		r.handleBlockTxn(p, m)
+	}
+}
+
+// highBandwidthPeers counts the peers we asked to push compact blocks.
+// The caller must hold r.mu.
+func (r *Relay) highBandwidthPeers() int {
+	count := 0
+	for _, state := range r.states {
+		if state.highBandwidth {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *Relay) handles(iv peer.InvVect) bool {
+	return iv.Type == peer.InvTx || (iv.Type == peer.InvBlock && r.cfg.Blocks)
+}
+
+func (r *Relay) handleInv(p *peer.Peer, items []peer.InvVect) {
+	// The store is consulted before taking r.mu so that it may use its own
+	// locks while the node calls into the relay.
+	var missing []peer.InvVect
+	for _, iv := range items {
+		if r.handles(iv) && !r.store.Have(iv) {
+			missing = append(missing, iv)
+		}
+	}
+	_, compactStore := r.store.(CompactStore)
+	var want []peer.InvVect
+	r.mu.Lock()
+	state, exists := r.states[p.ID()]
+	if !exists {
+		r.mu.Unlock()
+		return
+	}
+	for _, iv := range items {
+		state.known.add(iv)
+	}
+	for _, iv := range missing {
+		if req, pending := r.requested[iv]; pending {
+			if req.peer != p.ID() && !contains(req.fallbacks, p.ID()) {
+				req.fallbacks = append(req.fallbacks, p.ID())
+			}
+			continue
+		}
+		r.requested[iv] = &request{peer: p.ID(), sent: time.Now()}
+		if iv.Type == peer.InvBlock && compactStore {
+			iv.Type = peer.InvCmpctBlock
+		}
+		want = append(want, iv)
+	}
+	r.mu.Unlock()
+	if len(want) > 0 {
+		p.Send(&peer.MsgGetData{Items: want})
+	}
+}
+
+func (r *Relay) serve(p *peer.Peer, items []peer.InvVect) {
+	for _, iv := range items {
+		var msg peer.Message
+		var ok bool
+		if iv.Type == peer.InvCmpctBlock {
+			iv.Type = peer.InvBlock
+			msg, ok = r.compactBlock(iv.Hash)
+		} else if r.handles(iv) {
+			msg, ok = r.store.Get(iv)
+		}
+		if !ok {
+			continue
+		}
+		r.mu.Lock()
+		if state, exists := r.states[p.ID()]; exists {
+			state.known.add(iv)
+		}
+		r.mu.Unlock()
+		if err := p.Send(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (r *Relay) received(p *peer.Peer, iv peer.InvVect) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if state, exists := r.states[p.ID()]; exists {
+		state.known.add(iv)
+	}
+	delete(r.requested, iv)
+	if iv.Type == peer.InvBlock {
+		delete(r.pending, iv.Hash)
+	}
+}
+
+func (r *Relay) loop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.quit:
+			return
+		}
+		now := time.Now()
+		r.trickle(now)
+		r.mu.Lock()
+		retries := r.reassign(func(req *request) bool { return now.Sub(req.sent) > requestTimeout })
+		r.expirePending(now)
+		r.mu.Unlock()
+		r.sendRequests(retries)
+	}
+}
+
+// trickle sends the queued transaction announcements of every peer whose
+// turn has come.
+func (r *Relay) trickle(now time.Time) {
+	type batch struct {
+		peer  *peer.Peer
+		items []peer.InvVect
+	}
+	var batches []batch
+	r.mu.Lock()
+	for _, state := range r.states {
+		if now.Before(state.nextTrickle) {
+			continue
+		}
+		state.nextTrickle = r.nextTrickle(now)
+		var items []peer.InvVect
+		sent := 0
+		for _, iv := range state.txQueue {
+			if len(items) == peer.MaxInvItems {
+				break
+			}
+			sent++
+			if !state.known.items[iv] {
+				state.known.add(iv)
+				items = append(items, iv)
+			}
+		}
+		// Whatever did not fit waits for the peer's next turn.
+		state.txQueue = append([]peer.InvVect(nil), state.txQueue[sent:]...)
+		if len(items) > 0 {
+			batches = append(batches, batch{state.peer, items})
+		}
+	}
+	r.mu.Unlock()
+	for _, b := range batches {
+		b.peer.Send(&peer.MsgInv{Items: b.items})
+	}
+}
+
+func (r *Relay) nextTrickle(now time.Time) time.Time {
+	return now.Add(time.Duration(rand.ExpFloat64() * float64(r.cfg.TrickleInterval)))
+}
+
+// reassign moves every request matching stale to its next fallback peer,
+// dropping requests with none left, and returns the new getdata to send.
+// The caller must hold r.mu.
+func (r *Relay) reassign(stale func(*request) bool) map[*peer.Peer][]peer.InvVect {
+	retries := make(map[*peer.Peer][]peer.InvVect)
+	for iv, req := range r.requested {
+		if !stale(req) {
+			continue
+		}
+		delete(r.requested, iv)
+		for len(req.fallbacks) > 0 {
+			next := req.fallbacks[0]
+			req.fallbacks = req.fallbacks[1:]
+			if state, exists := r.states[next]; exists {
+				req.peer = next
+				req.sent = time.Now()
+				r.requested[iv] = req
+				retries[state.peer] = append(retries[state.peer], iv)
+				break
+			}
+		}
+	}
+	return retries
+}
+
+func (r *Relay) sendRequests(retries map[*peer.Peer][]peer.InvVect) {
+	for p, items := range retries {
+		if err := p.Send(&peer.MsgGetData{Items: items}); err != nil {
+			log.Printf("relay: getdata to %s: %v", p.ID(), err)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/peer"
+)
+
+func TestTrickleKeepsOverflow(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	invs := make(chan *peer.MsgInv, 2)
+	go func() {
+		for {
+			msg, err := peer.ReadMessage(remote)
+			if err != nil {
+				return
+			}
+			if inv, ok := msg.(*peer.MsgInv); ok {
+				invs <- inv
+			}
+		}
+	}()
+
+	r := &Relay{cfg: Config{TrickleInterval: time.Millisecond}, states: make(map[string]*peerState)}
+	r.states["p"] = &peerState{
+		peer:  &peer.Peer{Address: "p", Connection: local},
+		known: knownInventory{items: make(map[peer.InvVect]bool)},
+	}
+	extra := 5
+	for i := 0; i < peer.MaxInvItems+extra; i++ {
+		r.RelayTransaction(fmt.Sprint("tx", i))
+	}
+
+	now := time.Now()
+	for _, want := range []int{peer.MaxInvItems, extra} {
+		now = now.Add(time.Hour)
+		r.trickle(now)
+		select {
+		case inv := <-invs:
+			if len(inv.Items) != want {
+				t.Fatalf("inv of %d items, want %d", len(inv.Items), want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no inv sent")
+		}
+	}
+	if q := r.states["p"].txQueue; len(q) != 0 {
+		t.Fatalf("%d announcements still queued", len(q))
+	}
+}
@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Args are the parameters of one call, in the order the method declared
+// them whether the client sent them by position or by name.
+type Args struct {
+	names  []string
+	values []json.RawMessage
+}
+
+func parseArgs(names []string, raw json.RawMessage) (Args, error) {
+	args := Args{names: names}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return args, nil
+	}
+	if raw[0] == '{' {
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return args, NewError(CodeInvalidParams, "%v", err)
+		}
+		args.values = make([]json.RawMessage, len(names))
+		for key, value := range named {
+			i := indexOf(names, key)
+			if i < 0 {
+				return args, NewError(CodeInvalidParams, "unknown named parameter %s", key)
+			}
+			args.values[i] = value
+		}
+		return args, nil
+	}
+	if err := json.Unmarshal(raw, &args.values); err != nil {
+		return args, NewError(CodeInvalidParams, "params must be an array or object")
+	}
+	if len(args.values) > len(names) {
+		return args, NewError(CodeInvalidParams, "too many parameters: expected at most %d", len(names))
+	}
+	return args, nil
+}
+
+// Has reports whether parameter i was given and is not null.
+func (a Args) Has(i int) bool {
+	return i < len(a.values) && len(a.values[i]) > 0 && !bytes.Equal(a.values[i], []byte("null"))
+}
+
+// Decode unmarshals required parameter i into v.
+func (a Args) Decode(i int, v interface{}) error {
+	if !a.Has(i) {
+		return NewError(CodeInvalidParams, "missing parameter %s", a.name(i))
+	}
+	if err := json.Unmarshal(a.values[i], v); err != nil {
+		return NewError(CodeInvalidParams, "parameter %s: %v", a.name(i), err)
+	}
+	return nil
+}
+
+func (a Args) String(i int) (string, error) {
+	var s string
+	err := a.Decode(i, &s)
+	return s, err
+}
+
+func (a Args) Int(i int) (int, error) {
+	var n int
+	err := a.Decode(i, &n)
+	return n, err
+}
+
+// Optional decodes parameter i into v if it was given and leaves v alone
+// otherwise.
+func (a Args) Optional(i int, v interface{}) error {
+	if !a.Has(i) {
+		return nil
+	}
+	return a.Decode(i, v)
+}
+
+func (a Args) name(i int) string {
+	if i < len(a.names) {
+		return a.names[i]
+	}
+	return "?"
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+const DefaultListen = "127.0.0.1:9332"
+const cookieName = ".cookie"
+
+// CookiePath is where a node using dataDir writes its cookie file.
+func CookiePath(dataDir string) string {
+	return filepath.Join(dataDir, cookieName)
+}
+
+type Client struct {
+	url      string
+	user     string
+	password string
+	http     *http.Client
+}
+
+func NewClient(addr, user, password string) *Client {
+	return &Client{
+		url:      "http://" + addr,
+		user:     user,
+		password: password,
+		http:     &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Call runs method with params, which may be a slice for positional or a
+// map for named parameters, and returns the raw result.
+func (c *Client) Call(method string, params interface{}) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]interface{}{"id": 1, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("rpc: incorrect user or password")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpc: server returned %s", resp.Status)
+	}
+	var result struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return result.Result, nil
+}
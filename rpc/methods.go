@@ -0,0 +1,349 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/fees"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/transaction"
+)
+
+type BlockResult struct {
+	Hash          string      `json:"hash"`
+	Confirmations int         `json:"confirmations"`
+	Height        int         `json:"height"`
+	PreviousHash  string      `json:"previousblockhash"`
+	MerkleRoot    string      `json:"merkleroot"`
+	Time          int64       `json:"time"`
+	Bits          string      `json:"bits"`
+	Nonce         int         `json:"nonce"`
+	Size          int         `json:"size"`
+	Tx            interface{} `json:"tx"`
+}
+
+type TxResult struct {
+	TxID          string       `json:"txid"`
+	Hex           string       `json:"hex"`
+	Size          int          `json:"size"`
+	LockTime      int64        `json:"locktime"`
+	Vin           []VinResult  `json:"vin"`
+	Vout          []VoutResult `json:"vout"`
+	BlockHash     string       `json:"blockhash,omitempty"`
+	Confirmations int          `json:"confirmations,omitempty"`
+}
+
+type VinResult struct {
+	TxID      string `json:"txid"`
+	Vout      int    `json:"vout"`
+	ScriptSig string `json:"scriptSig,omitempty"`
+}
+
+type VoutResult struct {
+	Value        int    `json:"value"`
+	N            int    `json:"n"`
+	ScriptPubKey string `json:"scriptPubKey"`
+}
+
+type MempoolInfo struct {
+	Size       int `json:"size"`
+	Bytes      int `json:"bytes"`
+	MaxMempool int `json:"maxmempool"`
+}
+
+type PeerInfo struct {
+	Addr           string  `json:"addr"`
+	ListenAddr     string  `json:"listenaddr,omitempty"`
+	Inbound        bool    `json:"inbound"`
+	Version        uint32  `json:"version"`
+	SubVer         string  `json:"subver"`
+	StartingHeight int64   `json:"startingheight"`
+	PingTime       float64 `json:"pingtime"`
+	BanScore       int     `json:"banscore"`
+}
+
+func (s *Server) registerBuiltins() {
+	if s.cfg.Chain != nil {
+		s.Register("getblockcount", nil, s.getBlockCount)
+		s.Register("getblockhash", []string{"height"}, s.getBlockHash)
+		s.Register("getblock", []string{"blockhash", "verbosity"}, s.getBlock)
+		s.Register("getrawtransaction", []string{"txid", "verbose", "blockhash"}, s.getRawTransaction)
+	}
+	if s.cfg.Mempool != nil {
+		s.Register("sendrawtransaction", []string{"hexstring"}, s.sendRawTransaction)
+		s.Register("getmempoolinfo", nil, s.getMempoolInfo)
+	}
+	if s.cfg.Peers != nil {
+		s.Register("getpeerinfo", nil, s.getPeerInfo)
+		s.Register("addnode", []string{"node", "command"}, s.addNode)
+	}
+	if s.cfg.Fees != nil {
+		s.Register("estimatefee", []string{"nblocks"}, s.estimateFee)
+	}
+	if s.cfg.Shutdown != nil {
+		s.Register("stopnode", nil, s.stopNode)
+	}
+	if s.cfg.WalletPath != "" {
+		s.registerWallet()
+	}
+}
+
+func (s *Server) getBlockCount(args Args) (interface{}, error) {
+	_, height := s.cfg.Chain.Tip()
+	return height, nil
+}
+
+func (s *Server) getBlockHash(args Args) (interface{}, error) {
+	height, err := args.Int(0)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := s.cfg.Chain.HashAtHeight(height)
+	if !ok {
+		return nil, NewError(CodeInvalidParameter, "Block height out of range")
+	}
+	return hash, nil
+}
+
+// getBlock returns the serialized block in hex for verbosity 0, a summary
+// with transaction IDs for 1 and full transactions for 2. Like bitcoind it
+// also accepts a boolean, true meaning 1.
+func (s *Server) getBlock(args Args) (interface{}, error) {
+	hash, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	verbosity := 1
+	if args.Has(1) {
+		var verbose bool
+		if err := args.Decode(1, &verbose); err == nil {
+			if !verbose {
+				verbosity = 0
+			}
+		} else if err := args.Decode(1, &verbosity); err != nil {
+			return nil, err
+		}
+	}
+	blk, err := s.cfg.Chain.GetBlock(hash)
+	if err != nil {
+		return nil, NewError(CodeInvalidAddress, "Block not found")
+	}
+	if verbosity <= 0 {
+		return hex.EncodeToString(block.Encode(blk)), nil
+	}
+	confirmations := s.confirmations(blk)
+	result := BlockResult{
+		Hash:          blk.Hash,
+		Confirmations: confirmations,
+		Height:        blk.Index,
+		PreviousHash:  blk.PrevHash,
+		MerkleRoot:    blk.MerkleRoot,
+		Time:          blk.Timestamp,
+		Bits:          fmt.Sprintf("%08x", blk.Bits),
+		Nonce:         blk.Nonce,
+		Size:          len(block.Encode(blk)),
+	}
+	if verbosity == 1 {
+		ids := make([]string, 0, len(blk.Transactions))
+		for _, tx := range blk.Transactions {
+			ids = append(ids, tx.ID)
+		}
+		result.Tx = ids
+	} else {
+		txs := make([]TxResult, 0, len(blk.Transactions))
+		for _, tx := range blk.Transactions {
+			txs = append(txs, txResult(tx, "", 0))
+		}
+		result.Tx = txs
+	}
+	return result, nil
+}
+
+// confirmations is -1 for blocks not on the main chain.
+func (s *Server) confirmations(blk block.Block) int {
+	if hash, ok := s.cfg.Chain.HashAtHeight(blk.Index); !ok || hash != blk.Hash {
+		return -1
+	}
+	_, tipHeight := s.cfg.Chain.Tip()
+	return tipHeight - blk.Index + 1
+}
+
+// getRawTransaction looks in the mempool and, if a block hash is given, in
+// that block. There is no transaction index to find arbitrary confirmed
+// transactions.
+func (s *Server) getRawTransaction(args Args) (interface{}, error) {
+	txID, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	var verbose bool
+	if err := args.Optional(1, &verbose); err != nil {
+		return nil, err
+	}
+	var blockHash string
+	if err := args.Optional(2, &blockHash); err != nil {
+		return nil, err
+	}
+
+	var tx transaction.Transaction
+	found := false
+	confirmations := 0
+	if blockHash != "" {
+		blk, err := s.cfg.Chain.GetBlock(blockHash)
+		if err != nil {
+			return nil, NewError(CodeInvalidAddress, "Block hash not found")
+		}
+		for _, candidate := range blk.Transactions {
+			if candidate.ID == txID {
+				tx, found = candidate, true
+				break
+			}
+		}
+		confirmations = s.confirmations(blk)
+	} else if s.cfg.Mempool != nil {
+		if desc, ok := s.cfg.Mempool.Get(txID); ok {
+			tx, found = desc.Tx, true
+		}
+	}
+	if !found {
+		if blockHash != "" {
+			return nil, NewError(CodeInvalidAddress, "No such transaction found in the provided block")
+		}
+		return nil, NewError(CodeInvalidAddress, "No such mempool transaction. Provide the block hash to look up a confirmed transaction")
+	}
+	if !verbose {
+		return hex.EncodeToString(transaction.Encode(tx)), nil
+	}
+	return txResult(tx, blockHash, confirmations), nil
+}
+
+func txResult(tx transaction.Transaction, blockHash string, confirmations int) TxResult {
+	encoded := transaction.Encode(tx)
+	result := TxResult{
+		TxID:          tx.ID,
+		Hex:           hex.EncodeToString(encoded),
+		Size:          len(encoded),
+		LockTime:      tx.LockTime,
+		Vin:           make([]VinResult, 0, len(tx.Inputs)),
+		Vout:          make([]VoutResult, 0, len(tx.Outputs)),
+		BlockHash:     blockHash,
+		Confirmations: confirmations,
+	}
+	for _, in := range tx.Inputs {
+		result.Vin = append(result.Vin, VinResult{TxID: in.PrevTxID, Vout: in.OutputIndex, ScriptSig: in.ScriptSig})
+	}
+	for i, out := range tx.Outputs {
+		result.Vout = append(result.Vout, VoutResult{Value: out.Value, N: i, ScriptPubKey: out.ScriptPubKey})
+	}
+	return result
+}
+
+func (s *Server) sendRawTransaction(args Args) (interface{}, error) {
+	hexTx, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(hexTx)
+	if err != nil {
+		return nil, NewError(CodeDeserialization, "TX decode failed: %v", err)
+	}
+	tx, err := transaction.Decode(data)
+	if err != nil {
+		return nil, NewError(CodeDeserialization, "TX decode failed: %v", err)
+	}
+	err = s.cfg.Mempool.AddTransaction(tx)
+	switch {
+	case err == nil, errors.Is(err, mempool.ErrAlreadyHave):
+		return tx.ID, nil
+	case errors.Is(err, mempool.ErrMissingInputs):
+		return nil, NewError(CodeVerifyError, "%v", err)
+	default:
+		return nil, NewError(CodeVerifyRejected, "%v", err)
+	}
+}
+
+func (s *Server) getMempoolInfo(args Args) (interface{}, error) {
+	return MempoolInfo{
+		Size:       s.cfg.Mempool.Count(),
+		Bytes:      s.cfg.Mempool.Bytes(),
+		MaxMempool: s.cfg.Mempool.MaxSize(),
+	}, nil
+}
+
+func (s *Server) getPeerInfo(args Args) (interface{}, error) {
+	peers := s.cfg.Peers.Peers()
+	infos := make([]PeerInfo, 0, len(peers))
+	for _, p := range peers {
+		info := PeerInfo{
+			Addr:       p.Address,
+			ListenAddr: p.ListenAddr(),
+			Inbound:    p.Inbound,
+			PingTime:   p.PingRTT().Seconds(),
+			BanScore:   p.BanScore(),
+		}
+		if version, ok := p.RemoteVersion(); ok {
+			info.Version = version.Version
+			info.SubVer = version.UserAgent
+			info.StartingHeight = version.StartHeight
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// addNode supports bitcoind's commands: "add" also remembers the address in
+// the address book, "onetry" only connects and "remove" disconnects.
+func (s *Server) addNode(args Args) (interface{}, error) {
+	addr, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	command, err := args.String(1)
+	if err != nil {
+		return nil, err
+	}
+	switch command {
+	case "add", "onetry":
+		if command == "add" && s.cfg.Addrs != nil {
+			s.cfg.Addrs.AddAddress(addr, addr)
+		}
+		if _, err := s.cfg.Peers.Connect(addr); err != nil {
+			return nil, NewError(CodeMisc, "connecting to %s: %v", addr, err)
+		}
+	case "remove":
+		p, ok := s.cfg.Peers.GetPeer(addr)
+		if !ok {
+			return nil, NewError(CodeNodeNotAdded, "Node has not been added")
+		}
+		p.Disconnect()
+	default:
+		return nil, NewError(CodeInvalidParameter, "command must be one of add, remove or onetry")
+	}
+	return nil, nil
+}
+
+// estimateFee returns the fee per 1000 bytes that should confirm within
+// nblocks, or -1 like bitcoind when there is not enough data yet.
+func (s *Server) estimateFee(args Args) (interface{}, error) {
+	target, err := args.Int(0)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := s.cfg.Fees.EstimateFee(target)
+	switch {
+	case errors.Is(err, fees.ErrBadTarget):
+		return nil, NewError(CodeInvalidParameter, "nblocks must be between 1 and %d", fees.MaxTarget)
+	case errors.Is(err, fees.ErrNoEstimate):
+		return -1, nil
+	case err != nil:
+		return nil, err
+	}
+	return rate, nil
+}
+
+func (s *Server) stopNode(args Args) (interface{}, error) {
+	go s.cfg.Shutdown()
+	return "node stopping", nil
+}
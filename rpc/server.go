@@ -0,0 +1,318 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/addrmgr"
+	"blockchain-hello-golang/fees"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/wallet"
+)
+
+const cookieUser = "__cookie__"
+const maxRequestSize = 4 << 20
+const shutdownTimeout = 5 * time.Second
+
+// Error codes follow bitcoind so existing tooling can interpret them.
+const (
+	CodeParseError              = -32700
+	CodeInvalidRequest          = -32600
+	CodeMethodNotFound          = -32601
+	CodeInvalidParams           = -32602
+	CodeInternalError           = -32603
+	CodeMisc                    = -1
+	CodeWalletError             = -4
+	CodeWalletInsufficientFunds = -6
+	CodeInvalidAddress          = -5
+	CodeInvalidParameter        = -8
+	CodeUnlockNeeded            = -13
+	CodeWrongPassphrase         = -14
+	CodeWalletNotFound          = -18
+	CodeDeserialization         = -22
+	CodeNodeNotAdded            = -24
+	CodeVerifyError             = -25
+	CodeVerifyRejected          = -26
+	CodeAlreadyInChain          = -27
+	CodeWalletExists            = -35
+)
+
+var ErrNoCredentials = errors.New("rpc: no credentials configured")
+
+// Error is returned to clients in the error member of a response.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type Config struct {
+	Listen string
+	// User and Password enable basic auth with fixed credentials. When
+	// they are empty a random password is written to CookieFile instead.
+	User       string
+	Password   string
+	CookieFile string
+
+	// Methods are only registered for the parts of the node that are set.
+	Chain    *fork.ChainManager
+	Mempool  *mempool.Pool
+	Peers    *peer.PeerManager
+	Addrs    *addrmgr.AddrManager
+	Fees     *fees.Estimator
+	Shutdown func()
+
+	// WalletPath enables the wallet methods. Wallet is the wallet already
+	// open there, if any; OnWalletLoaded is told when createwallet or
+	// restorewallet opens one.
+	WalletPath     string
+	Wallet         *wallet.Wallet
+	OnWalletLoaded func(*wallet.Wallet)
+}
+
+// Handler runs one method. Returning an *Error controls the code sent to
+// the client; any other error is reported as CodeMisc.
+type Handler func(args Args) (interface{}, error)
+
+type method struct {
+	params []string
+	fn     Handler
+}
+
+type Server struct {
+	cfg      Config
+	mu       sync.Mutex
+	methods  map[string]method
+	auth     []string
+	cookie   bool
+	server   *http.Server
+	listener net.Listener
+	wallet   *wallet.Wallet
+}
+
+func NewServer(cfg Config) *Server {
+	s := &Server{cfg: cfg, methods: make(map[string]method), wallet: cfg.Wallet}
+	s.registerBuiltins()
+	return s
+}
+
+// Register adds a method. params names the positional parameters so that
+// clients may also pass them by name.
+func (s *Server) Register(name string, params []string, fn Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = method{params: params, fn: fn}
+}
+
+func (s *Server) Start() error {
+	if err := s.setupAuth(); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("rpc: serve:", err)
+		}
+	}()
+	return nil
+}
+
+// setupAuth accepts the configured credentials or, without them, writes a
+// fresh cookie.
+func (s *Server) setupAuth() error {
+	switch {
+	case s.cfg.User != "" && s.cfg.Password != "":
+		s.auth = append(s.auth, s.cfg.User+":"+s.cfg.Password)
+	case s.cfg.CookieFile != "":
+		password, err := writeCookie(s.cfg.CookieFile)
+		if err != nil {
+			return err
+		}
+		s.auth = append(s.auth, cookieUser+":"+password)
+		s.cookie = true
+	default:
+		return ErrNoCredentials
+	}
+	return nil
+}
+
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Stop() error {
+	if s.cookie {
+		os.Remove(s.cfg.CookieFile)
+	}
+	if s.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+type request struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Result interface{}     `json:"result"`
+	Error  *Error          `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(body) > maxRequestSize {
+		writeJSON(w, response{Error: NewError(CodeInvalidRequest, "request too large")})
+		return
+	}
+
+	// A JSON array is a batch; answer with an array in the same order.
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, response{Error: NewError(CodeParseError, "%v", err)})
+			return
+		}
+		responses := make([]response, 0, len(batch))
+		for _, raw := range batch {
+			responses = append(responses, s.handle(raw))
+		}
+		writeJSON(w, responses)
+		return
+	}
+	writeJSON(w, s.handle(body))
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	given := []byte(user + ":" + password)
+	for _, want := range s.auth {
+		if subtle.ConstantTimeCompare(given, []byte(want)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handle(raw []byte) response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return response{Error: NewError(CodeParseError, "%v", err)}
+	}
+	resp := response{ID: req.ID}
+	if req.Method == "" {
+		resp.Error = NewError(CodeInvalidRequest, "missing method")
+		return resp
+	}
+	s.mu.Lock()
+	m, exists := s.methods[req.Method]
+	s.mu.Unlock()
+	if !exists {
+		resp.Error = NewError(CodeMethodNotFound, "method not found: %s", req.Method)
+		return resp
+	}
+	args, err := parseArgs(m.params, req.Params)
+	if err != nil {
+		resp.Error = toError(err)
+		return resp
+	}
+	result, err := m.fn(args)
+	if err != nil {
+		resp.Error = toError(err)
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+func toError(err error) *Error {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return NewError(CodeMisc, "%v", err)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("rpc: writing response:", err)
+	}
+}
+
+func writeCookie(path string) (string, error) {
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", err
+	}
+	password := hex.EncodeToString(secret[:])
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(cookieUser+":"+password), 0600); err != nil {
+		return "", err
+	}
+	return password, os.Rename(tmp, path)
+}
+
+// ReadCookie returns the credentials a running server wrote to path.
+func ReadCookie(path string) (user, password string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	user, password, ok := strings.Cut(strings.TrimSpace(string(data)), ":")
+	if !ok {
+		return "", "", fmt.Errorf("rpc: malformed cookie file %s", path)
+	}
+	return user, password, nil
+}
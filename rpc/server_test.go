@@ -0,0 +1,247 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves s over httptest with fixed credentials and two test
+// methods: pair echoes a required string and an optional int, fail returns
+// its argument as an error.
+func newTestServer(t *testing.T, cfg Config) (*Server, *httptest.Server) {
+	t.Helper()
+	if cfg.User == "" {
+		cfg.User, cfg.Password = "alice", "secret"
+	}
+	s := NewServer(cfg)
+	s.Register("pair", []string{"a", "b"}, func(args Args) (interface{}, error) {
+		a, err := args.String(0)
+		if err != nil {
+			return nil, err
+		}
+		b := 7
+		if err := args.Optional(1, &b); err != nil {
+			return nil, err
+		}
+		return []interface{}{a, b}, nil
+	})
+	s.Register("fail", []string{"rpc"}, func(args Args) (interface{}, error) {
+		var typed bool
+		if err := args.Decode(0, &typed); err != nil {
+			return nil, err
+		}
+		if typed {
+			return nil, NewError(CodeVerifyRejected, "rejected")
+		}
+		return nil, errors.New("boom")
+	})
+	if err := s.setupAuth(); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func post(t *testing.T, ts *httptest.Server, method, user, password, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestAuthorization(t *testing.T) {
+	_, ts := newTestServer(t, Config{})
+	body := `{"id":1,"method":"pair","params":["x"]}`
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		password string
+		status   int
+	}{
+		{"no credentials", http.MethodPost, "", "", http.StatusUnauthorized},
+		{"wrong password", http.MethodPost, "alice", "guess", http.StatusUnauthorized},
+		{"wrong user", http.MethodPost, "bob", "secret", http.StatusUnauthorized},
+		{"cookie user", http.MethodPost, cookieUser, "secret", http.StatusUnauthorized},
+		{"not a POST", http.MethodGet, "alice", "secret", http.StatusMethodNotAllowed},
+		{"authorized", http.MethodPost, "alice", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, data := post(t, ts, tt.method, tt.user, tt.password, body)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d: %s", status, tt.status, data)
+			}
+			if status == http.StatusOK && !strings.Contains(data, `"result":["x",7]`) {
+				t.Fatalf("unexpected response %s", data)
+			}
+		})
+	}
+}
+
+func TestCookie(t *testing.T) {
+	if err := NewServer(Config{Listen: "127.0.0.1:0"}).Start(); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("no credentials: %v, want %v", err, ErrNoCredentials)
+	}
+
+	path := CookiePath(t.TempDir())
+	s := NewServer(Config{Listen: "127.0.0.1:0", CookieFile: path})
+	s.Register("pair", []string{"a"}, func(args Args) (interface{}, error) { return args.String(0) })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("cookie readable with mode %v", perm)
+	}
+	user, password, err := ReadCookie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != cookieUser || len(password) != 64 {
+		t.Fatalf("cookie %s:%s", user, password)
+	}
+	if _, err := NewClient(s.Addr(), user, password).Call("pair", []string{"x"}); err != nil {
+		t.Fatalf("call with the cookie: %v", err)
+	}
+	if _, err := NewClient(s.Addr(), user, "stale").Call("pair", []string{"x"}); err == nil {
+		t.Fatal("call with a stale cookie succeeded")
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("cookie left behind after Stop: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("no separator"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadCookie(path); err == nil {
+		t.Fatal("malformed cookie accepted")
+	}
+	if _, _, err := ReadCookie(filepath.Join(t.TempDir(), cookieName)); !os.IsNotExist(err) {
+		t.Fatalf("missing cookie: %v", err)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	_, ts := newTestServer(t, Config{})
+	client := NewClient(ts.Listener.Addr().String(), "alice", "secret")
+	tests := []struct {
+		name   string
+		params interface{}
+		want   []interface{}
+		code   int
+	}{
+		{"positional", []interface{}{"x", 2}, []interface{}{"x", 2.0}, 0},
+		{"named", map[string]interface{}{"a": "x", "b": 2}, []interface{}{"x", 2.0}, 0},
+		{"optional left out by position", []interface{}{"x"}, []interface{}{"x", 7.0}, 0},
+		{"optional left out by name", map[string]interface{}{"a": "x"}, []interface{}{"x", 7.0}, 0},
+		{"optional null", []interface{}{"x", nil}, []interface{}{"x", 7.0}, 0},
+		{"no params", nil, nil, CodeInvalidParams},
+		{"required missing by name", map[string]interface{}{"b": 2}, nil, CodeInvalidParams},
+		{"too many", []interface{}{"x", 2, 3}, nil, CodeInvalidParams},
+		{"unknown name", map[string]interface{}{"a": "x", "c": 3}, nil, CodeInvalidParams},
+		{"wrong type", []interface{}{1}, nil, CodeInvalidParams},
+		{"not an array or object", "x", nil, CodeInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := client.Call("pair", tt.params)
+			var rpcErr *Error
+			if tt.code != 0 {
+				if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code {
+					t.Fatalf("got %v, want code %d", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []interface{}
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	_, ts := newTestServer(t, Config{})
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"not JSON", `{"id":1,`, CodeParseError},
+		{"no method", `{"id":1}`, CodeInvalidRequest},
+		{"unknown method", `{"id":1,"method":"nope"}`, CodeMethodNotFound},
+		{"bad params", `{"id":1,"method":"pair","params":[1]}`, CodeInvalidParams},
+		{"handler error code kept", `{"id":1,"method":"fail","params":[true]}`, CodeVerifyRejected},
+		{"plain handler error", `{"id":1,"method":"fail","params":[false]}`, CodeMisc},
+		{"too large", `{"id":1,"method":"pair","params":["` + strings.Repeat("x", maxRequestSize) + `"]}`, CodeInvalidRequest},
+		{"bad batch", `[1,`, CodeParseError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, data := post(t, ts, http.MethodPost, "alice", "secret", tt.body)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, data)
+			}
+			var resp struct{ Error *Error }
+			if err := json.Unmarshal([]byte(data), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error == nil || resp.Error.Code != tt.code {
+				t.Fatalf("error %v, want code %d", resp.Error, tt.code)
+			}
+		})
+	}
+
+	// A batch is answered in order, each response carrying its own ID.
+	_, data := post(t, ts, http.MethodPost, "alice", "secret",
+		`[{"id":"a","method":"pair","params":["x"]},{"id":"b","method":"nope"},{"id":"c","method":"fail","params":[true]}]`)
+	var batch []struct {
+		ID     string
+		Result interface{}
+		Error  *Error
+	}
+	if err := json.Unmarshal([]byte(data), &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 || batch[0].ID != "a" || batch[0].Error != nil ||
+		batch[1].ID != "b" || batch[1].Error == nil || batch[1].Error.Code != CodeMethodNotFound ||
+		batch[2].ID != "c" || batch[2].Error == nil || batch[2].Error.Code != CodeVerifyRejected {
+		t.Fatalf("batch response %s", data)
+	}
+}
@@ -0,0 +1,345 @@
+package rpc
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"blockchain-hello-golang/fees"
+	"blockchain-hello-golang/wallet"
+)
+
+// maxUnlockSeconds is bitcoind's limit on walletpassphrase timeouts.
+const maxUnlockSeconds = 100000000
+
+type CreateWalletResult struct {
+	Path     string `json:"path"`
+	Mnemonic string `json:"mnemonic,omitempty"`
+	Warning  string `json:"warning,omitempty"`
+}
+
+type UnspentResult struct {
+	TxID          string `json:"txid"`
+	Vout          int    `json:"vout"`
+	Address       string `json:"address"`
+	ScriptPubKey  string `json:"scriptPubKey"`
+	Amount        int    `json:"amount"`
+	Confirmations int    `json:"confirmations"`
+	Spendable     bool   `json:"spendable"`
+}
+
+type WalletInfo struct {
+	Path          string `json:"walletpath"`
+	AccountPath   string `json:"accountpath"`
+	Receive       int    `json:"receiveaddresses"`
+	Change        int    `json:"changeaddresses"`
+	Unused        int    `json:"unusedreceiveaddresses"`
+	UnlockedUntil int64  `json:"unlocked_until"`
+}
+
+func (s *Server) registerWallet() {
+	s.Register("createwallet", []string{"passphrase", "mnemonic", "seedpassphrase"}, s.createWallet)
+	s.Register("restorewallet", []string{"backup_file"}, s.restoreWallet)
+	s.Register("backupwallet", []string{"destination"}, s.withWallet(s.backupWallet))
+	s.Register("walletpassphrase", []string{"passphrase", "timeout"}, s.withWallet(s.walletPassphrase))
+	s.Register("walletlock", nil, s.withWallet(s.walletLock))
+	s.Register("walletpassphrasechange", []string{"oldpassphrase", "newpassphrase"}, s.withWallet(s.walletPassphraseChange))
+	s.Register("getwalletinfo", nil, s.withWallet(s.getWalletInfo))
+	s.Register("getnewaddress", nil, s.withWallet(s.getNewAddress))
+	s.Register("getrawchangeaddress", nil, s.withWallet(s.getRawChangeAddress))
+	s.Register("dumpmnemonic", nil, s.withWallet(s.dumpMnemonic))
+	s.Register("getbalance", nil, s.withWallet(s.getBalance))
+	s.Register("listunspent", nil, s.withWallet(s.listUnspent))
+	if s.cfg.Mempool != nil {
+		s.Register("sendtoaddress", []string{"address", "amount", "fee_rate", "coin_selection", "conf_target"}, s.withWallet(s.sendToAddress))
+		s.Register("sendmany", []string{"amounts", "fee_rate", "coin_selection", "conf_target"}, s.withWallet(s.sendMany))
+	}
+}
+
+type walletHandler func(w *wallet.Wallet, args Args) (interface{}, error)
+
+// withWallet fails the call unless a wallet is loaded.
+func (s *Server) withWallet(fn walletHandler) Handler {
+	return func(args Args) (interface{}, error) {
+		s.mu.Lock()
+		w := s.wallet
+		s.mu.Unlock()
+		if w == nil {
+			return nil, NewError(CodeWalletNotFound, "No wallet is loaded. Load one with createwallet or restorewallet.")
+		}
+		result, err := fn(w, args)
+		return result, walletError(err)
+	}
+}
+
+func walletError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, wallet.ErrLocked):
+		return NewError(CodeUnlockNeeded, "Error: Please enter the wallet passphrase with walletpassphrase first.")
+	case errors.Is(err, wallet.ErrWrongPassphrase):
+		return NewError(CodeWrongPassphrase, "Error: The wallet passphrase entered was incorrect.")
+	case errors.Is(err, wallet.ErrExists):
+		return NewError(CodeWalletExists, "%v", err)
+	case errors.Is(err, wallet.ErrBadAddress):
+		return NewError(CodeInvalidAddress, "Invalid address")
+	case errors.Is(err, wallet.ErrBadAmount), errors.Is(err, wallet.ErrUnknownSelection):
+		return NewError(CodeInvalidParameter, "%v", err)
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		return NewError(CodeWalletInsufficientFunds, "Insufficient funds")
+	}
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return err
+	}
+	return NewError(CodeWalletError, "%v", err)
+}
+
+// loadWallet installs a wallet opened by createwallet or restorewallet.
+func (s *Server) loadWallet(open func() (*wallet.Wallet, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wallet != nil {
+		return NewError(CodeWalletExists, "Wallet %s is already loaded.", s.wallet.Path())
+	}
+	w, err := open()
+	if err != nil {
+		return walletError(err)
+	}
+	s.wallet = w
+	if s.cfg.OnWalletLoaded != nil {
+		go s.cfg.OnWalletLoaded(w)
+	}
+	return nil
+}
+
+// createWallet makes a new wallet encrypted with passphrase. Given a
+// mnemonic it regenerates that wallet's keys instead of making new ones.
+func (s *Server) createWallet(args Args) (interface{}, error) {
+	passphrase, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	var mnemonic, seedPassphrase string
+	if err := args.Optional(1, &mnemonic); err != nil {
+		return nil, err
+	}
+	if err := args.Optional(2, &seedPassphrase); err != nil {
+		return nil, err
+	}
+	result := CreateWalletResult{Path: s.cfg.WalletPath}
+	err = s.loadWallet(func() (*wallet.Wallet, error) {
+		if mnemonic != "" {
+			return wallet.Restore(s.cfg.WalletPath, mnemonic, seedPassphrase, wallet.DefaultAccountPath, passphrase)
+		}
+		w, generated, err := wallet.Create(s.cfg.WalletPath, wallet.DefaultAccountPath, passphrase)
+		result.Mnemonic = generated
+		result.Warning = "Write down the mnemonic. It is the only way to recover the wallet without the wallet file and passphrase."
+		return w, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Server) restoreWallet(args Args) (interface{}, error) {
+	backup, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadWallet(func() (*wallet.Wallet, error) {
+		return wallet.RestoreBackup(backup, s.cfg.WalletPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateWalletResult{Path: s.cfg.WalletPath}, nil
+}
+
+func (s *Server) backupWallet(w *wallet.Wallet, args Args) (interface{}, error) {
+	dest, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	return nil, w.Backup(dest)
+}
+
+func (s *Server) walletPassphrase(w *wallet.Wallet, args Args) (interface{}, error) {
+	passphrase, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := args.Int(1)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return nil, NewError(CodeInvalidParameter, "Timeout cannot be negative or zero.")
+	}
+	if timeout > maxUnlockSeconds {
+		timeout = maxUnlockSeconds
+	}
+	return nil, w.Unlock(passphrase, time.Duration(timeout)*time.Second)
+}
+
+func (s *Server) walletLock(w *wallet.Wallet, args Args) (interface{}, error) {
+	w.Lock()
+	return nil, nil
+}
+
+func (s *Server) walletPassphraseChange(w *wallet.Wallet, args Args) (interface{}, error) {
+	oldPassphrase, err := args.String(0)
+	if err != nil {
+		return nil, err
+	}
+	newPassphrase, err := args.String(1)
+	if err != nil {
+		return nil, err
+	}
+	return nil, w.ChangePassphrase(oldPassphrase, newPassphrase)
+}
+
+func (s *Server) getWalletInfo(w *wallet.Wallet, args Args) (interface{}, error) {
+	info := WalletInfo{
+		Path:        w.Path(),
+		AccountPath: w.AccountPath(),
+		Receive:     len(w.Addresses(wallet.ReceiveChain)),
+		Change:      len(w.Addresses(wallet.ChangeChain)),
+		Unused:      len(w.Unused(wallet.ReceiveChain)),
+	}
+	if until := w.UnlockedUntil(); !until.IsZero() {
+		info.UnlockedUntil = until.Unix()
+	}
+	return info, nil
+}
+
+// getNewAddress and getRawChangeAddress save the wallet so an address is
+// never handed out twice, even across a crash.
+func (s *Server) getNewAddress(w *wallet.Wallet, args Args) (interface{}, error) {
+	a := w.NewAddress()
+	return a.Address, w.Save()
+}
+
+func (s *Server) getRawChangeAddress(w *wallet.Wallet, args Args) (interface{}, error) {
+	a := w.ChangeAddress()
+	return a.Address, w.Save()
+}
+
+func (s *Server) dumpMnemonic(w *wallet.Wallet, args Args) (interface{}, error) {
+	return w.Mnemonic()
+}
+
+func (s *Server) getBalance(w *wallet.Wallet, args Args) (interface{}, error) {
+	return w.Balance(), nil
+}
+
+// listUnspent lists the wallet's confirmed coins. Immature coinbase coins
+// and ones already spent by a transaction in the mempool are marked not
+// spendable.
+func (s *Server) listUnspent(w *wallet.Wallet, args Args) (interface{}, error) {
+	height := -1
+	if s.cfg.Chain != nil {
+		_, height = s.cfg.Chain.Tip()
+	}
+	coins := w.Coins()
+	results := make([]UnspentResult, 0, len(coins))
+	for _, c := range coins {
+		a, _ := w.Lookup(c.Output.ScriptPubKey)
+		results = append(results, UnspentResult{
+			TxID:          c.OutPoint.TxID,
+			Vout:          c.OutPoint.Index,
+			Address:       a.Address,
+			ScriptPubKey:  c.Output.ScriptPubKey,
+			Amount:        c.Output.Value,
+			Confirmations: height - c.Height + 1,
+			Spendable:     c.Mature(height+1) && (s.cfg.Mempool == nil || !s.cfg.Mempool.IsSpent(c.OutPoint)),
+		})
+	}
+	return results, nil
+}
+
+func (s *Server) sendToAddress(w *wallet.Wallet, args Args) (interface{}, error) {
+	var r wallet.Recipient
+	var err error
+	if r.Address, err = args.String(0); err != nil {
+		return nil, err
+	}
+	if r.Value, err = args.Int(1); err != nil {
+		return nil, err
+	}
+	return s.send(w, []wallet.Recipient{r}, args, 2)
+}
+
+// sendMany pays every address in an {"address": amount} object in one
+// transaction.
+func (s *Server) sendMany(w *wallet.Wallet, args Args) (interface{}, error) {
+	var amounts map[string]int
+	if err := args.Decode(0, &amounts); err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(amounts))
+	for addr := range amounts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	recipients := make([]wallet.Recipient, 0, len(addrs))
+	for _, addr := range addrs {
+		recipients = append(recipients, wallet.Recipient{Address: addr, Value: amounts[addr]})
+	}
+	return s.send(w, recipients, args, 1)
+}
+
+// send builds, signs and broadcasts a payment from the wallet's coins that
+// are not already being spent. The fee rate, coin selection strategy and
+// confirmation target are the optional parameters starting at opt; without
+// a fee rate the wallet pays the estimate for the target.
+func (s *Server) send(w *wallet.Wallet, recipients []wallet.Recipient, args Args, opt int) (interface{}, error) {
+	var opts wallet.TxOptions
+	if err := args.Optional(opt, &opts.FeeRate); err != nil {
+		return nil, err
+	}
+	if opts.FeeRate < 0 {
+		return nil, NewError(CodeInvalidParameter, "fee_rate must not be negative")
+	}
+	var selection string
+	if err := args.Optional(opt+1, &selection); err != nil {
+		return nil, err
+	}
+	opts.Selection = wallet.CoinSelection(selection)
+	if err := args.Optional(opt+2, &opts.ConfTarget); err != nil {
+		return nil, err
+	}
+	if opts.ConfTarget < 0 || opts.ConfTarget > fees.MaxTarget {
+		return nil, NewError(CodeInvalidParameter, "conf_target must be between 0 and %d", fees.MaxTarget)
+	}
+
+	height := -1
+	if s.cfg.Chain != nil {
+		_, height = s.cfg.Chain.Tip()
+	}
+	var coins []wallet.Coin
+	for _, c := range w.Coins() {
+		if c.Mature(height+1) && !s.cfg.Mempool.IsSpent(c.OutPoint) {
+			coins = append(coins, c)
+		}
+	}
+	spend, err := w.CreateTransaction(coins, recipients, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cfg.Mempool.AddTransaction(spend.Tx); err != nil {
+		return nil, NewError(CodeWalletError, "Transaction rejected by the mempool: %v", err)
+	}
+	if spend.Change >= 0 {
+		w.CommitSpend(spend)
+		if err := w.Save(); err != nil {
+			// The change address is within the gap limit, so a rescan finds
+			// it even if this is lost; the payment itself has gone out.
+			log.Println("rpc: saving wallet:", err)
+		}
+	}
+	return spend.Tx.ID, nil
+}
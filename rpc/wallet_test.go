@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/fees"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/wallet"
+)
+
+func TestSendConfTarget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallet.dat")
+	w, _, err := wallet.Create(path, wallet.DefaultAccountPath, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Unlock("secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	utxos, err := utxo.Open(filepath.Join(dir, "chainstate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ts := newTestServer(t, Config{WalletPath: path, Wallet: w, Mempool: mempool.New(utxos, mempool.DefaultMaxSize)})
+	client := NewClient(ts.Listener.Addr().String(), "alice", "secret")
+	to := w.NewAddress().Address
+
+	tests := []struct {
+		name   string
+		target int
+		code   int
+	}{
+		{"default target", 0, CodeWalletInsufficientFunds},
+		{"longest target", fees.MaxTarget, CodeWalletInsufficientFunds},
+		{"negative", -1, CodeInvalidParameter},
+		{"past the longest target", fees.MaxTarget + 1, CodeInvalidParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Call("sendtoaddress", map[string]interface{}{"address": to, "amount": 1000, "conf_target": tt.target})
+			var rpcErr *Error
+			if !errors.As(err, &rpcErr) || rpcErr.Code != tt.code {
+				t.Fatalf("got %v, want code %d", err, tt.code)
+			}
+			if tt.code == CodeInvalidParameter && !strings.Contains(rpcErr.Message, "between 0 and") {
+				t.Fatalf("message %q does not give the accepted range", rpcErr.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"blockchain-hello-golang/crypto"
+)
+
+const maxNumSize = 4
+const maxLockTimeNumSize = 5
+
+var (
+	ErrEvalFalse         = errors.New("script: evaluated to false")
+	ErrStackUnderflow    = errors.New("script: stack underflow")
+	ErrStackOverflow     = errors.New("script: stack size limit exceeded")
+	ErrTooManyOps        = errors.New("script: operation limit exceeded")
+	ErrPushTooLarge      = errors.New("script: push exceeds size limit")
+	ErrVerifyFailed      = errors.New("script: verify failed")
+	ErrEqualVerifyFailed = errors.New("script: equalverify failed")
+	ErrSigVerifyFailed   = errors.New("script: checksigverify failed")
+	ErrUnbalancedIf      = errors.New("script: unbalanced conditional")
+	ErrEarlyReturn       = errors.New("script: OP_RETURN executed")
+	ErrUnknownOpcode     = errors.New("script: unknown opcode")
+	ErrBadNumber         = errors.New("script: invalid number encoding")
+	ErrBadPubKeyCount    = errors.New("script: invalid multisig key count")
+	ErrBadSigCount       = errors.New("script: invalid multisig signature count")
+	ErrLockTime          = errors.New("script: lock time not satisfied")
+	ErrSigPushOnly       = errors.New("script: signature script must be push only")
+)
+
+// Checker supplies the transaction-dependent parts of execution.
+type Checker interface {
+	CheckSig(sig, pubKey []byte) bool
+	CheckLockTime(lockTime int64) bool
+}
+
+type engine struct {
+	stack   [][]byte
+	cond    []bool
+	ops     int
+	checker Checker
+}
+
+// Verify runs sigScript and then pkScript over the same stack and succeeds
+// if the final top of stack is true.
+func Verify(sigScript, pkScript []byte, checker Checker) error {
+	sigInstructions, err := Parse(sigScript)
+	if err != nil {
+		return err
+	}
+	for _, in := range sigInstructions {
+		if !in.IsPush() {
+			return ErrSigPushOnly
+		}
+	}
+	pkInstructions, err := Parse(pkScript)
+	if err != nil {
+		return err
+	}
+	e := &engine{checker: checker}
+	if err := e.run(sigInstructions); err != nil {
+		return err
+	}
+	if err := e.run(pkInstructions); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+func (e *engine) run(instructions []Instruction) error {
+	e.ops = 0
+	e.cond = nil
+	for _, in := range instructions {
+		if err := e.step(in); err != nil {
+			return err
+		}
+		if len(e.stack) > MaxStackSize {
+			return ErrStackOverflow
+		}
+	}
+	if len(e.cond) != 0 {
+		return ErrUnbalancedIf
+	}
+	return nil
+}
+
+func (e *engine) executing() bool {
+	for _, c := range e.cond {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *engine) step(in Instruction) error {
+	if len(in.Data) > MaxPushSize {
+		return ErrPushTooLarge
+	}
+	if in.Opcode > OP_16 {
+		e.ops++
+		if e.ops > MaxOpsPerScript {
+			return ErrTooManyOps
+		}
+	}
+
+	switch in.Opcode {
+	case OP_IF, OP_NOTIF:
+		value := false
+		if e.executing() {
+			top, err := e.pop()
+			if err != nil {
+				return err
+			}
+			value = asBool(top) == (in.Opcode == OP_IF)
+		}
+		e.cond = append(e.cond, value)
+		return nil
+	case OP_ELSE:
+		if len(e.cond) == 0 {
+			return ErrUnbalancedIf
+		}
+		e.cond[len(e.cond)-1] = !e.cond[len(e.cond)-1]
+		return nil
+	case OP_ENDIF:
+		if len(e.cond) == 0 {
+			return ErrUnbalancedIf
+		}
+		e.cond = e.cond[:len(e.cond)-1]
+		return nil
+	}
+	if !e.executing() {
+		return nil
+	}
+
+	switch op := in.Opcode; {
+	case op == OP_0:
+		e.push(nil)
+	case op < OP_PUSHDATA1 || op == OP_PUSHDATA1 || op == OP_PUSHDATA2:
+		e.push(in.Data)
+	case op == OP_1NEGATE:
+		e.push(encodeNum(-1))
+	case op >= OP_1 && op <= OP_16:
+		e.push(encodeNum(int64(op - OP_1 + 1)))
+	case op == OP_NOP:
+	case op == OP_VERIFY:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(top) {
+			return ErrVerifyFailed
+		}
+	case op == OP_RETURN:
+		return ErrEarlyReturn
+	case op == OP_DROP:
+		_, err := e.pop()
+		return err
+	case op == OP_DUP:
+		top, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(top)
+	case op == OP_EQUAL || op == OP_EQUALVERIFY:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if op == OP_EQUALVERIFY {
+			if !equal {
+				return ErrEqualVerifyFailed
+			}
+			return nil
+		}
+		e.push(fromBool(equal))
+	case op == OP_SHA256:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(top)
+		e.push(hash[:])
+	case op == OP_HASH160:
+		top, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(crypto.Hash160(top))
+	case op == OP_CHECKSIG || op == OP_CHECKSIGVERIFY:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		ok := e.checker.CheckSig(sig, pubKey)
+		if op == OP_CHECKSIGVERIFY {
+			if !ok {
+				return ErrSigVerifyFailed
+			}
+			return nil
+		}
+		e.push(fromBool(ok))
+	case op == OP_CHECKMULTISIG || op == OP_CHECKMULTISIGVERIFY:
+		ok, err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		if op == OP_CHECKMULTISIGVERIFY {
+			if !ok {
+				return ErrSigVerifyFailed
+			}
+			return nil
+		}
+		e.push(fromBool(ok))
+	case op == OP_CHECKLOCKTIMEVERIFY:
+		top, err := e.peek()
+		if err != nil {
+			return err
+		}
+		lockTime, err := decodeNum(top, maxLockTimeNumSize)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 || !e.checker.CheckLockTime(lockTime) {
+			return ErrLockTime
+		}
+	default:
+		return fmt.Errorf("%w: 0x%02x", ErrUnknownOpcode, op)
+	}
+	return nil
+}
+
+// checkMultiSig consumes <dummy> <sig...> <m> <pubkey...> <n>. Signatures
+// must appear in the same order as the keys they match.
+func (e *engine) checkMultiSig() (bool, error) {
+	n, err := e.popNum()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > MaxPubKeysPerMultisig {
+		return false, ErrBadPubKeyCount
+	}
+	e.ops += int(n)
+	if e.ops > MaxOpsPerScript {
+		return false, ErrTooManyOps
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+	m, err := e.popNum()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, ErrBadSigCount
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+	// Bitcoin's off-by-one: one extra element is always consumed.
+	if _, err := e.pop(); err != nil {
+		return false, err
+	}
+
+	k := 0
+	for _, sig := range sigs {
+		for k < len(pubKeys) && !e.checker.CheckSig(sig, pubKeys[k]) {
+			k++
+		}
+		if k == len(pubKeys) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+func (e *engine) push(data []byte) {
+	e.stack = append(e.stack, data)
+}
+
+func (e *engine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return top, nil
+}
+
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *engine) popNum() (int64, error) {
+	top, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeNum(top, maxNumSize)
+}
+
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			// Negative zero is false.
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// encodeNum uses Bitcoin's little-endian sign-magnitude number format.
+func encodeNum(v int64) []byte {
+	if v == 0 {
+		return nil
+	}
+	negative := v < 0
+	if negative {
+		v = -v
+	}
+	var result []byte
+	for v > 0 {
+		result = append(result, byte(v&0xff))
+		v >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+func decodeNum(data []byte, maxSize int) (int64, error) {
+	if len(data) > maxSize {
+		return 0, ErrBadNumber
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var v int64
+	for i, b := range data {
+		v |= int64(b) << (8 * i)
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		v &^= int64(0x80) << (8 * (len(data) - 1))
+		return -v, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,116 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"blockchain-hello-golang/crypto"
+)
+
+// testChecker accepts a signature that is "sig:" followed by the public
+// key, and lock times up to 100.
+type testChecker struct{}
+
+func (testChecker) CheckSig(sig, pubKey []byte) bool {
+	return bytes.Equal(sig, sign(pubKey))
+}
+
+func (testChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= 100
+}
+
+func sign(pubKey []byte) []byte {
+	return append([]byte("sig:"), pubKey...)
+}
+
+func TestVerify(t *testing.T) {
+	pk1, pk2, pk3 := []byte("key1"), []byte("key2"), []byte("key3")
+	p2pkh := PayToPubKeyHash(crypto.Hash160(pk1))
+	multisig := MultiSig(2, [][]byte{pk1, pk2, pk3})
+	ops := func(ops ...byte) []byte { return ops }
+	const op2 = OP_1 + 1
+	digest := sha256.Sum256([]byte("x"))
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		want      error
+	}{
+		{"p2pkh", SignatureScript(sign(pk1), pk1), p2pkh, nil},
+		{"p2pkh wrong key", SignatureScript(sign(pk2), pk2), p2pkh, ErrEqualVerifyFailed},
+		{"p2pkh bad signature", SignatureScript(sign(pk2), pk1), p2pkh, ErrEvalFalse},
+		{"p2pkh empty signature script", nil, p2pkh, ErrStackUnderflow},
+		{"multisig", NewBuilder().AddOp(OP_0).AddData(sign(pk1)).AddData(sign(pk3)).Script(), multisig, nil},
+		{"multisig out of order", NewBuilder().AddOp(OP_0).AddData(sign(pk3)).AddData(sign(pk1)).Script(), multisig, ErrEvalFalse},
+		{"multisig without dummy", NewBuilder().AddData(sign(pk1)).AddData(sign(pk3)).Script(), multisig, ErrStackUnderflow},
+		{"multisig too many keys", ops(OP_0, OP_0), NewBuilder().AddInt(MaxPubKeysPerMultisig + 1).AddOp(OP_CHECKMULTISIG).Script(), ErrBadPubKeyCount},
+		{"if taken", ops(OP_1), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), nil},
+		{"else taken", ops(OP_0), ops(OP_IF, OP_1, OP_ELSE, OP_0, OP_ENDIF), ErrEvalFalse},
+		{"notif", ops(OP_0), ops(OP_NOTIF, OP_1, OP_ENDIF), nil},
+		{"skipped branch is not executed", ops(OP_0), ops(OP_IF, OP_RETURN, OP_ENDIF, OP_1), nil},
+		{"unbalanced if", ops(OP_1), ops(OP_IF, OP_1), ErrUnbalancedIf},
+		{"stray endif", nil, ops(OP_1, OP_ENDIF), ErrUnbalancedIf},
+		{"verify", ops(OP_0), ops(OP_VERIFY, OP_1), ErrVerifyFailed},
+		{"return", nil, ops(OP_1, OP_RETURN), ErrEarlyReturn},
+		{"equal", ops(op2), ops(op2, OP_EQUAL), nil},
+		{"sha256", NewBuilder().AddData([]byte("x")).Script(), NewBuilder().AddOp(OP_SHA256).AddData(digest[:]).AddOp(OP_EQUAL).Script(), nil},
+		{"lock time reached", nil, TimeLocked(100, ops(OP_1)), nil},
+		{"lock time not reached", nil, TimeLocked(101, ops(OP_1)), ErrLockTime},
+		{"negative lock time", nil, TimeLocked(-1, ops(OP_1)), ErrLockTime},
+		{"negative zero is false", NewBuilder().AddData([]byte{0x80}).Script(), nil, ErrEvalFalse},
+		{"empty stack is false", nil, nil, ErrEvalFalse},
+		{"signature script must push only", ops(OP_1, OP_DUP), ops(OP_EQUAL), ErrSigPushOnly},
+		{"unknown opcode", nil, ops(0xff), ErrUnknownOpcode},
+		{"too many ops", nil, append(bytes.Repeat([]byte{OP_NOP}, MaxOpsPerScript+1), OP_1), ErrTooManyOps},
+		{"push too large", nil, NewBuilder().AddData(make([]byte, MaxPushSize+1)).Script(), ErrPushTooLarge},
+		{"malformed push", nil, ops(0x05, 0x01), ErrMalformedPush},
+		{"script too large", nil, make([]byte, MaxScriptSize+1), ErrScriptTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.sigScript, tt.pkScript, testChecker{}); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumEncoding(t *testing.T) {
+	tests := []struct {
+		v    int64
+		data []byte
+	}{
+		{0, nil},
+		{1, []byte{0x01}},
+		{-1, []byte{0x81}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x00}},
+		{-128, []byte{0x80, 0x80}},
+		{255, []byte{0xff, 0x00}},
+		{256, []byte{0x00, 0x01}},
+		{-32768, []byte{0x00, 0x80, 0x80}},
+	}
+	for _, tt := range tests {
+		if got := encodeNum(tt.v); !bytes.Equal(got, tt.data) {
+			t.Errorf("encodeNum(%d) = %x, want %x", tt.v, got, tt.data)
+		}
+		if got, err := decodeNum(tt.data, maxNumSize); err != nil || got != tt.v {
+			t.Errorf("decodeNum(%x) = %d, %v, want %d", tt.data, got, err, tt.v)
+		}
+	}
+	if _, err := decodeNum(make([]byte, maxNumSize+1), maxNumSize); !errors.Is(err, ErrBadNumber) {
+		t.Errorf("oversized number: %v, want %v", err, ErrBadNumber)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	got, err := Disassemble(PayToPubKeyHash([]byte{0xab, 0xcd}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "OP_DUP OP_HASH160 abcd OP_EQUALVERIFY OP_CHECKSIG"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	OP_0                   = 0x00
+	OP_PUSHDATA1           = 0x4c
+	OP_PUSHDATA2           = 0x4d
+	OP_1NEGATE             = 0x4f
+	OP_1                   = 0x51
+	OP_16                  = 0x60
+	OP_NOP                 = 0x61
+	OP_IF                  = 0x63
+	OP_NOTIF               = 0x64
+	OP_ELSE                = 0x67
+	OP_ENDIF               = 0x68
+	OP_VERIFY              = 0x69
+	OP_RETURN              = 0x6a
+	OP_DROP                = 0x75
+	OP_DUP                 = 0x76
+	OP_EQUAL               = 0x87
+	OP_EQUALVERIFY         = 0x88
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+)
+
+const (
+	MaxScriptSize         = 10000
+	MaxPushSize           = 520
+	MaxOpsPerScript       = 201
+	MaxStackSize          = 1000
+	MaxPubKeysPerMultisig = 20
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+var ErrMalformedPush = errors.New("script: push extends past end of script")
+var ErrScriptTooLarge = errors.New("script: script exceeds size limit")
+
+type Instruction struct {
+	Opcode byte
+	Data   []byte
+}
+
+func (in Instruction) IsPush() bool {
+	return in.Opcode <= OP_16 && in.Opcode != 0x50
+}
+
+// Parse splits script into instructions without executing it.
+func Parse(script []byte) ([]Instruction, error) {
+	if len(script) > MaxScriptSize {
+		return nil, ErrScriptTooLarge
+	}
+	var instructions []Instruction
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		var size int
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			size = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, ErrMalformedPush
+			}
+			size = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, ErrMalformedPush
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			instructions = append(instructions, Instruction{Opcode: op})
+			continue
+		}
+		if i+size > len(script) {
+			return nil, ErrMalformedPush
+		}
+		instructions = append(instructions, Instruction{Opcode: op, Data: script[i : i+size]})
+		i += size
+	}
+	return instructions, nil
+}
+
+func Disassemble(script []byte) (string, error) {
+	instructions, err := Parse(script)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(instructions))
+	for _, in := range instructions {
+		switch {
+		case in.Data != nil:
+			parts = append(parts, hex.EncodeToString(in.Data))
+		case in.Opcode >= OP_1 && in.Opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", in.Opcode-OP_1+1))
+		case opcodeNames[in.Opcode] != "":
+			parts = append(parts, opcodeNames[in.Opcode])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN%d", in.Opcode))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+type Builder struct {
+	script []byte
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+func (b *Builder) AddOp(op byte) *Builder {
+	b.script = append(b.script, op)
+	return b
+}
+
+// AddData pushes data with the smallest encoding that can hold it.
+func (b *Builder) AddData(data []byte) *Builder {
+	switch n := len(data); {
+	case n == 0:
+		b.script = append(b.script, OP_0)
+	case n < OP_PUSHDATA1:
+		b.script = append(b.script, byte(n))
+	case n <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(n))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(n), byte(n>>8))
+	}
+	b.script = append(b.script, data...)
+	return b
+}
+
+func (b *Builder) AddInt(v int64) *Builder {
+	switch {
+	case v == 0:
+		return b.AddOp(OP_0)
+	case v == -1:
+		return b.AddOp(OP_1NEGATE)
+	case v >= 1 && v <= 16:
+		return b.AddOp(byte(OP_1 + v - 1))
+	}
+	return b.AddData(encodeNum(v))
+}
+
+func (b *Builder) Script() []byte {
+	return b.script
+}
+
+func PayToPubKeyHash(pubKeyHash []byte) []byte {
+	return NewBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+func MultiSig(required int, pubKeys [][]byte) []byte {
+	b := NewBuilder().AddInt(int64(required))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	return b.AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// TimeLocked prefixes script with a lock time check so the output can only
+// be spent by a transaction whose LockTime has reached lockTime.
+func TimeLocked(lockTime int64, script []byte) []byte {
+	prefix := NewBuilder().AddInt(lockTime).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).Script()
+	return append(prefix, script...)
+}
+
+func SignatureScript(sig, pubKey []byte) []byte {
+	return NewBuilder().AddData(sig).AddData(pubKey).Script()
+}
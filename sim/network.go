@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+
+	"blockchain-hello-golang/peer"
+)
+
+var ErrNotConnected = errors.New("sim: nodes are not linked")
+
+// Link describes one direction of a connection between two nodes.
+type Link struct {
+	Latency time.Duration
+	// Jitter adds up to this much extra delay to each message, so messages
+	// on the same link may overtake each other.
+	Jitter time.Duration
+	// Bandwidth in bytes per second; zero means unlimited. Messages queue
+	// behind each other while the link is busy.
+	Bandwidth int
+	// Loss is the probability that a message is dropped. Nothing is
+	// retransmitted; nodes recover by asking again for blocks they were
+	// promised, by announcing their tip again and by fetching the parents
+	// of orphans.
+	Loss float64
+}
+
+// Receiver is a node attached to the network.
+type Receiver interface {
+	Receive(from int, msg peer.Message)
+}
+
+type direction struct {
+	link      Link
+	busyUntil time.Time
+}
+
+// Network carries wire messages between nodes in virtual time. Messages
+// are encoded and decoded with the real framing, so sizes and codec
+// behavior match a TCP connection.
+type Network struct {
+	sim       *Simulator
+	receivers map[int]Receiver
+	links     map[[2]int]*direction
+	adjacent  map[int][]int
+	sent      map[string]peer.Traffic
+	dropped   int
+}
+
+func NewNetwork(s *Simulator) *Network {
+	return &Network{
+		sim:       s,
+		receivers: make(map[int]Receiver),
+		links:     make(map[[2]int]*direction),
+		adjacent:  make(map[int][]int),
+		sent:      make(map[string]peer.Traffic),
+	}
+}
+
+func (n *Network) Attach(id int, r Receiver) {
+	n.receivers[id] = r
+}
+
+// Connect links a and b in both directions.
+func (n *Network) Connect(a, b int, link Link) {
+	if a == b || n.Connected(a, b) {
+		return
+	}
+	n.links[[2]int{a, b}] = &direction{link: link}
+	n.links[[2]int{b, a}] = &direction{link: link}
+	n.adjacent[a] = insertSorted(n.adjacent[a], b)
+	n.adjacent[b] = insertSorted(n.adjacent[b], a)
+}
+
+func (n *Network) Connected(a, b int) bool {
+	_, ok := n.links[[2]int{a, b}]
+	return ok
+}
+
+// Peers lists the nodes linked to id in ascending order.
+func (n *Network) Peers(id int) []int {
+	return n.adjacent[id]
+}
+
+// Send queues msg on the link from one node to another. A dropped message
+// is not an error, just as it would not be for the sender on a real
+// network.
+func (n *Network) Send(from, to int, msg peer.Message) error {
+	d, ok := n.links[[2]int{from, to}]
+	if !ok {
+		return ErrNotConnected
+	}
+	var buf bytes.Buffer
+	size, err := peer.WriteMessageN(&buf, msg)
+	if err != nil {
+		return err
+	}
+	t := n.sent[msg.Command()]
+	t.Messages++
+	t.Bytes += size
+	n.sent[msg.Command()] = t
+
+	if d.link.Loss > 0 && n.sim.Rand().Float64() < d.link.Loss {
+		n.dropped++
+		n.sim.Tracef("drop %d->%d %s", from, to, msg.Command())
+		return nil
+	}
+	start := n.sim.Now()
+	if d.busyUntil.After(start) {
+		start = d.busyUntil
+	}
+	if d.link.Bandwidth > 0 {
+		d.busyUntil = start.Add(time.Duration(size) * time.Second / time.Duration(d.link.Bandwidth))
+	} else {
+		d.busyUntil = start
+	}
+	delay := d.busyUntil.Sub(n.sim.Now()) + d.link.Latency
+	if d.link.Jitter > 0 {
+		delay += time.Duration(n.sim.Rand().Int63n(int64(d.link.Jitter)))
+	}
+	data := buf.Bytes()
+	n.sim.After(delay, func() {
+		msg, err := peer.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			n.sim.Tracef("undecodable %d->%d: %v", from, to, err)
+			return
+		}
+		if r, ok := n.receivers[to]; ok {
+			r.Receive(from, msg)
+		}
+	})
+	return nil
+}
+
+// Traffic reports the messages and bytes sent per command, including ones
+// that were later dropped.
+func (n *Network) Traffic() map[string]peer.Traffic {
+	return n.sent
+}
+
+func (n *Network) Dropped() int {
+	return n.dropped
+}
+
+func insertSorted(list []int, id int) []int {
+	i := sort.SearchInts(list, id)
+	list = append(list, 0)
+	copy(list[i+1:], list[i:])
+	list[i] = id
+	return list
+}
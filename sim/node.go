@@ -0,0 +1,214 @@
+package sim
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/mempool"
+	"blockchain-hello-golang/mining"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/storage"
+	"blockchain-hello-golang/utxo"
+)
+
+// requestTimeout is how long a node waits for a requested block before it
+// asks again, and how long it waits before announcing its tip again to
+// peers that have not acknowledged it. That is what recovers from lost
+// messages. After maxRetries it waits for another announcement instead.
+const requestTimeout = 10 * time.Second
+const maxRetries = 5
+
+// Node is an event-driven full node. It validates blocks with the real
+// chain manager and orphan pool and relays them with inv/getdata, but does
+// everything from simulator events instead of goroutines.
+type Node struct {
+	ID    int
+	Miner bool
+
+	sim       *Simulator
+	net       *Network
+	store     *storage.BlockStore
+	chain     *fork.ChainManager
+	orphans   *fork.OrphanPool
+	pool      *mempool.Pool
+	known     map[int]map[string]bool
+	requested map[string]time.Time
+	reorgs    int
+}
+
+// NewNode opens a chain under dir that starts at genesis.
+func NewNode(id int, s *Simulator, net *Network, dir string, genesis block.Block) (*Node, error) {
+	store, err := storage.Open(filepath.Join(dir, "blocks"))
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := utxo.Open(filepath.Join(dir, "chainstate"))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	n := &Node{
+		ID:        id,
+		sim:       s,
+		net:       net,
+		store:     store,
+		pool:      mempool.New(utxos, mempool.DefaultMaxSize),
+		known:     make(map[int]map[string]bool),
+		requested: make(map[string]time.Time),
+	}
+	n.chain, err = fork.NewChainManager(store, utxos, n.pool)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if _, err := n.chain.ProcessBlock(genesis); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("sim: genesis: %w", err)
+	}
+	n.orphans = fork.NewOrphanPool(n.chain, func(peerID, hash string) {
+		if from, err := strconv.Atoi(peerID); err == nil {
+			n.request(from, []string{hash})
+		}
+	})
+	net.Attach(id, n)
+	return n, nil
+}
+
+func (n *Node) Close() error {
+	return n.store.Close()
+}
+
+func (n *Node) Tip() (block.Block, int) {
+	return n.chain.Tip()
+}
+
+func (n *Node) Chain() *fork.ChainManager {
+	return n.chain
+}
+
+func (n *Node) Reorgs() int {
+	return n.reorgs
+}
+
+func (n *Node) Receive(from int, msg peer.Message) {
+	switch m := msg.(type) {
+	case *peer.MsgInv:
+		var want []string
+		for _, iv := range m.Items {
+			if iv.Type != peer.InvBlock {
+				continue
+			}
+			n.markKnown(from, iv.Hash)
+			if !n.chain.HaveBlock(iv.Hash) && !n.orphans.Has(iv.Hash) {
+				want = append(want, iv.Hash)
+			}
+		}
+		n.request(from, want)
+	case *peer.MsgGetData:
+		for _, iv := range m.Items {
+			if iv.Type != peer.InvBlock {
+				continue
+			}
+			if blk, err := n.chain.GetBlock(iv.Hash); err == nil {
+				n.markKnown(from, iv.Hash)
+				n.net.Send(n.ID, from, &peer.MsgBlock{Block: blk})
+			}
+		}
+	case *peer.MsgBlock:
+		n.markKnown(from, m.Block.Hash)
+		delete(n.requested, m.Block.Hash)
+		n.acceptBlock(strconv.Itoa(from), m.Block)
+	}
+}
+
+// Mine extends the node's tip with a block paying to the node itself.
+func (n *Node) Mine() block.Block {
+	blk := mining.NewBlock(n.chain, n.pool, fmt.Sprintf("node-%d", n.ID), n.sim.Now())
+	n.sim.Tracef("mine node=%d height=%d hash=%s", n.ID, blk.Index, blk.Hash)
+	n.acceptBlock("self", blk)
+	return blk
+}
+
+func (n *Node) acceptBlock(source string, blk block.Block) {
+	oldTip, oldHeight := n.chain.Tip()
+	changed, err := n.orphans.ProcessBlock(blk, source)
+	if err != nil {
+		n.sim.Tracef("reject node=%d hash=%s from=%s: %v", n.ID, blk.Hash, source, err)
+		return
+	}
+	if !changed {
+		return
+	}
+	tip, height := n.chain.Tip()
+	if hash, ok := n.chain.HashAtHeight(oldHeight); !ok || hash != oldTip.Hash {
+		n.reorgs++
+		n.sim.Tracef("reorg node=%d from=%s height=%d to=%s height=%d", n.ID, oldTip.Hash, oldHeight, tip.Hash, height)
+	}
+	n.sim.Tracef("tip node=%d height=%d hash=%s", n.ID, height, tip.Hash)
+	n.announce(tip.Hash, 0)
+}
+
+// announce sends inv for hash to every peer not known to have it, and
+// repeats that while hash is still the tip, since a lost inv is otherwise
+// never noticed. A peer is known to have a block once it has announced,
+// requested or sent it.
+func (n *Node) announce(hash string, attempt int) {
+	if tip, _ := n.chain.Tip(); tip.Hash != hash || attempt > maxRetries {
+		return
+	}
+	sent := false
+	for _, p := range n.net.Peers(n.ID) {
+		if n.known[p][hash] {
+			continue
+		}
+		n.net.Send(n.ID, p, &peer.MsgInv{Items: []peer.InvVect{{Type: peer.InvBlock, Hash: hash}}})
+		sent = true
+	}
+	if sent {
+		n.sim.After(requestTimeout, func() { n.announce(hash, attempt+1) })
+	}
+}
+
+// request asks from for the blocks in hashes that are not already on their
+// way from someone.
+func (n *Node) request(from int, hashes []string) {
+	var items []peer.InvVect
+	for _, hash := range hashes {
+		if sent, ok := n.requested[hash]; ok && n.sim.Now().Sub(sent) < requestTimeout {
+			continue
+		}
+		n.requested[hash] = n.sim.Now()
+		items = append(items, peer.InvVect{Type: peer.InvBlock, Hash: hash})
+		n.retryLater(from, hash, 1)
+	}
+	if len(items) > 0 {
+		n.net.Send(n.ID, from, &peer.MsgGetData{Items: items})
+	}
+}
+
+func (n *Node) retryLater(from int, hash string, attempt int) {
+	n.sim.After(requestTimeout, func() {
+		if _, pending := n.requested[hash]; !pending || attempt > maxRetries {
+			return
+		}
+		if n.chain.HaveBlock(hash) || n.orphans.Has(hash) {
+			delete(n.requested, hash)
+			return
+		}
+		n.sim.Tracef("retry node=%d hash=%s peer=%d", n.ID, hash, from)
+		n.requested[hash] = n.sim.Now()
+		n.net.Send(n.ID, from, &peer.MsgGetData{Items: []peer.InvVect{{Type: peer.InvBlock, Hash: hash}}})
+		n.retryLater(from, hash, attempt+1)
+	})
+}
+
+func (n *Node) markKnown(p int, hash string) {
+	if n.known[p] == nil {
+		n.known[p] = make(map[string]bool)
+	}
+	n.known[p][hash] = true
+}
@@ -0,0 +1,184 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/peer"
+	"blockchain-hello-golang/transaction"
+)
+
+const (
+	TopologyRandom = "random"
+	TopologyRing   = "ring"
+	TopologyStar   = "star"
+)
+
+var ErrBadConfig = errors.New("sim: bad config")
+
+// Config fully determines a run: the same Config always produces the same
+// trace.
+type Config struct {
+	Nodes         int
+	Topology      string
+	Outbound      int
+	MinerFraction float64
+	Bits          uint32
+	Blocks        int
+	// BlockInterval is the mean time between blocks across all miners.
+	BlockInterval time.Duration
+	Seed          int64
+	Link          Link
+}
+
+type Result struct {
+	Elapsed   time.Duration
+	Events    int
+	Mined     int
+	Stale     int
+	Reorgs    int
+	Height    int
+	Converged bool
+	Dropped   int
+	Traffic   map[string]peer.Traffic
+	Digest    string
+}
+
+// Run simulates cfg to completion: miners find blocks until cfg.Blocks
+// have been mined and the network is then left to settle. trace, if not
+// nil, receives every trace line.
+func Run(cfg Config, trace io.Writer) (Result, error) {
+	if err := cfg.check(); err != nil {
+		return Result{}, err
+	}
+	dir, err := os.MkdirTemp("", "blockchain-sim-")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	s := New(cfg.Seed)
+	s.SetTrace(trace)
+	net := NewNetwork(s)
+	genesis := genesisBlock(cfg.Bits)
+	nodes := make([]*Node, cfg.Nodes)
+	for i := range nodes {
+		n, err := NewNode(i, s, net, filepath.Join(dir, fmt.Sprint(i)), genesis)
+		if err != nil {
+			return Result{}, err
+		}
+		defer n.Close()
+		nodes[i] = n
+	}
+	connect(s.Rand(), net, cfg)
+
+	var miners []*Node
+	for _, n := range nodes {
+		if s.Rand().Float64() < cfg.MinerFraction {
+			n.Miner = true
+			miners = append(miners, n)
+		}
+	}
+	if len(miners) == 0 {
+		nodes[0].Miner = true
+		miners = append(miners, nodes[0])
+	}
+
+	// Blocks are found as a Poisson process averaging one per
+	// BlockInterval, each by a random miner since all have equal hash rate.
+	// Once the last one is mined the queue drains as the network settles.
+	var mined []block.Block
+	var scheduleBlock func()
+	scheduleBlock = func() {
+		s.After(time.Duration(s.Rand().ExpFloat64()*float64(cfg.BlockInterval)), func() {
+			n := miners[s.Rand().Intn(len(miners))]
+			mined = append(mined, n.Mine())
+			if len(mined) < cfg.Blocks {
+				scheduleBlock()
+			}
+		})
+	}
+	scheduleBlock()
+	s.Run(func() bool { return false })
+
+	result := Result{
+		Elapsed:   s.Elapsed(),
+		Events:    s.Events(),
+		Mined:     len(mined),
+		Converged: true,
+		Dropped:   net.Dropped(),
+		Traffic:   net.Traffic(),
+	}
+	best, height := nodes[0].Tip()
+	result.Height = height
+	for _, n := range nodes {
+		result.Reorgs += n.Reorgs()
+		if tip, _ := n.Tip(); tip.Hash != best.Hash {
+			result.Converged = false
+		}
+	}
+	for _, blk := range mined {
+		if hash, ok := nodes[0].Chain().HashAtHeight(blk.Index); !ok || hash != blk.Hash {
+			result.Stale++
+		}
+	}
+	s.Tracef("end height=%d tip=%s converged=%v", height, best.Hash, result.Converged)
+	result.Digest = s.Digest()
+	return result, nil
+}
+
+func (cfg Config) check() error {
+	switch {
+	case cfg.Nodes < 2:
+		return fmt.Errorf("%w: need at least 2 nodes, got %d", ErrBadConfig, cfg.Nodes)
+	case cfg.Blocks < 1:
+		return fmt.Errorf("%w: need at least 1 block", ErrBadConfig)
+	case cfg.BlockInterval <= 0:
+		return fmt.Errorf("%w: block interval must be positive", ErrBadConfig)
+	case cfg.Link.Loss < 0 || cfg.Link.Loss >= 1:
+		return fmt.Errorf("%w: loss must be in [0, 1)", ErrBadConfig)
+	}
+	switch cfg.Topology {
+	case TopologyRandom, TopologyRing, TopologyStar:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown topology %q", ErrBadConfig, cfg.Topology)
+}
+
+// connect links the nodes: each to cfg.Outbound random others, each to
+// the next in a ring, or all to node 0 as the hub of a star.
+func connect(r *rand.Rand, net *Network, cfg Config) {
+	for i := 0; i < cfg.Nodes; i++ {
+		switch cfg.Topology {
+		case TopologyRing:
+			net.Connect(i, (i+1)%cfg.Nodes, cfg.Link)
+		case TopologyStar:
+			net.Connect(0, i, cfg.Link)
+		default:
+			for j := 0; j < cfg.Outbound; j++ {
+				net.Connect(i, r.Intn(cfg.Nodes), cfg.Link)
+			}
+		}
+	}
+}
+
+func genesisBlock(bits uint32) block.Block {
+	coinbase := transaction.Transaction{Inputs: []transaction.Input{}, Outputs: []transaction.Output{{Value: 0}}}
+	coinbase.ID = transaction.CalculateID(coinbase)
+	blk := block.Block{
+		Index:        0,
+		PrevHash:     "0",
+		Timestamp:    Epoch.Unix(),
+		Transactions: []transaction.Transaction{coinbase},
+		Bits:         bits,
+	}
+	blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+	return concensus.SolveBlock(blk)
+}
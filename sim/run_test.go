@@ -0,0 +1,68 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/consensus"
+)
+
+func testConfig() Config {
+	return Config{
+		Nodes:         6,
+		Topology:      TopologyRandom,
+		Outbound:      2,
+		MinerFraction: 0.5,
+		Bits:          concensus.PowLimitBits,
+		Blocks:        8,
+		BlockInterval: 10 * time.Minute,
+		Seed:          7,
+		Link:          Link{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond},
+	}
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	first, err := Run(testConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Run(testConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Digest != second.Digest {
+		t.Fatalf("digests differ: %s and %s", first.Digest, second.Digest)
+	}
+	if first.Events != second.Events || first.Height != second.Height {
+		t.Fatalf("runs differ: %+v and %+v", first, second)
+	}
+}
+
+func TestRunConvergesDespiteLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		topology string
+		loss     float64
+	}{
+		{"random", TopologyRandom, 0.1},
+		{"ring", TopologyRing, 0.1},
+		{"star", TopologyStar, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Topology = tt.topology
+			cfg.Link.Loss = tt.loss
+			result, err := Run(cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Dropped == 0 {
+				t.Fatal("no messages were dropped")
+			}
+			if !result.Converged {
+				t.Fatalf("nodes did not converge: %+v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package sim
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// Epoch is where the virtual clock of every run starts, so that block
+// timestamps do not depend on when the run happens.
+var Epoch = time.Unix(1700000000, 0)
+
+type event struct {
+	at  time.Time
+	seq uint64
+	fn  func()
+}
+
+// eventQueue orders events by time and then by the order they were
+// scheduled, which makes runs with the same seed identical.
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Simulator is a discrete-event scheduler with a virtual clock. All
+// randomness comes from one seeded source and events run one at a time on
+// the caller's goroutine, so a run is fully determined by its seed and
+// configuration.
+type Simulator struct {
+	now    time.Time
+	seq    uint64
+	queue  eventQueue
+	rand   *rand.Rand
+	trace  io.Writer
+	digest hash.Hash
+	events int
+}
+
+func New(seed int64) *Simulator {
+	return &Simulator{now: Epoch, rand: rand.New(rand.NewSource(seed)), digest: sha256.New()}
+}
+
+func (s *Simulator) Now() time.Time {
+	return s.now
+}
+
+// Elapsed is the virtual time since the run started.
+func (s *Simulator) Elapsed() time.Duration {
+	return s.now.Sub(Epoch)
+}
+
+func (s *Simulator) Rand() *rand.Rand {
+	return s.rand
+}
+
+// After schedules fn to run d from now in virtual time.
+func (s *Simulator) After(d time.Duration, fn func()) {
+	if d < 0 {
+		d = 0
+	}
+	s.seq++
+	heap.Push(&s.queue, &event{at: s.now.Add(d), seq: s.seq, fn: fn})
+}
+
+// Step runs the next event and reports whether there was one.
+func (s *Simulator) Step() bool {
+	if len(s.queue) == 0 {
+		return false
+	}
+	e := heap.Pop(&s.queue).(*event)
+	s.now = e.at
+	s.events++
+	e.fn()
+	return true
+}
+
+// Run processes events until the queue is empty or done returns true.
+func (s *Simulator) Run(done func() bool) {
+	for !done() && s.Step() {
+	}
+}
+
+// Events is the number of events run so far.
+func (s *Simulator) Events() int {
+	return s.events
+}
+
+// SetTrace sends every trace line to w as well as into the digest.
+func (s *Simulator) SetTrace(w io.Writer) {
+	s.trace = w
+}
+
+// Tracef records a line of the run's history, prefixed with the virtual
+// time. Two runs replay each other exactly when their traces match.
+func (s *Simulator) Tracef(format string, args ...interface{}) {
+	line := fmt.Sprintf("%.6f %s\n", s.Elapsed().Seconds(), fmt.Sprintf(format, args...))
+	s.digest.Write([]byte(line))
+	if s.trace != nil {
+		io.WriteString(s.trace, line)
+	}
+}
+
+// Digest summarizes the trace so far.
+func (s *Simulator) Digest() string {
+	return fmt.Sprintf("%x", s.digest.Sum(nil))
+}
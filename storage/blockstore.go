@@ -0,0 +1,490 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/codec"
+	"blockchain-hello-golang/consensus"
+)
+
+const segmentMagic = 0xb10cc4a1
+const maxSegmentSize = 128 << 20
+const indexFileName = "index.log"
+
+const (
+	recordBlock = 1
+	recordTip   = 2
+)
+
+var ErrBlockNotFound = errors.New("storage: block not found")
+
+type location struct {
+	Segment int
+	Offset  int64
+	Length  int
+}
+
+type BlockStore struct {
+	dir      string
+	mu       sync.Mutex
+	segment  *os.File
+	segNum   int
+	segSize  int64
+	index    *os.File
+	byHash   map[string]location
+	prevHash map[string]string
+	byHeight []string
+	// genesis is the root setTip last found, remembered so that its
+	// height need not be read back from disk every time.
+	genesis string
+}
+
+func Open(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &BlockStore{
+		dir:      dir,
+		byHash:   make(map[string]location),
+		prevHash: make(map[string]string),
+	}
+	tip, err := s.loadIndex()
+	if err == nil && tip != "" {
+		err = s.setTip(tip)
+	}
+	if err != nil {
+		// The index is only a cache of what is in the segment files.
+		if s.index != nil {
+			s.index.Close()
+		}
+		if tip, err = s.rebuildIndex(); err != nil {
+			return nil, err
+		}
+		if tip != "" {
+			if err := s.setTip(tip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := s.openSegment(s.segNum); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BlockStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.segment.Close(); err != nil {
+		return err
+	}
+	return s.index.Close()
+}
+
+func (s *BlockStore) HasBlock(hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.byHash[hash]
+	return exists
+}
+
+func (s *BlockStore) PutBlock(b block.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.byHash[b.Hash]; exists {
+		return nil
+	}
+	data := block.Encode(b)
+	if s.segSize > 0 && s.segSize+int64(len(data))+8 > maxSegmentSize {
+		if err := s.segment.Close(); err != nil {
+			return err
+		}
+		if err := s.openSegment(s.segNum + 1); err != nil {
+			return err
+		}
+	}
+	var frame [8]byte
+	binary.LittleEndian.PutUint32(frame[:4], segmentMagic)
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(data)))
+	// A failed write may still have appended part of the record; cut it
+	// off so that the next block lands at segSize as its location says.
+	if _, err := s.segment.Write(append(frame[:], data...)); err != nil {
+		s.segment.Truncate(s.segSize)
+		return err
+	}
+	if err := s.segment.Sync(); err != nil {
+		s.segment.Truncate(s.segSize)
+		return err
+	}
+	loc := location{Segment: s.segNum, Offset: s.segSize + 8, Length: len(data)}
+	s.segSize += int64(len(data)) + 8
+
+	if err := s.appendIndex(blockRecord(b.Hash, b.PrevHash, loc)); err != nil {
+		return err
+	}
+	s.byHash[b.Hash] = loc
+	s.prevHash[b.Hash] = b.PrevHash
+	return nil
+}
+
+func (s *BlockStore) GetBlock(hash string) (block.Block, error) {
+	s.mu.Lock()
+	loc, exists := s.byHash[hash]
+	s.mu.Unlock()
+	if !exists {
+		return block.Block{}, ErrBlockNotFound
+	}
+	return s.readBlock(loc)
+}
+
+// SetTip makes hash the head of the best chain and rewrites the height
+// index along its ancestry. Every ancestor must already be stored.
+func (s *BlockStore) SetTip(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.setTip(hash); err != nil {
+		return err
+	}
+	w := codec.NewWriter()
+	w.WriteUint8(recordTip)
+	w.WriteString(hash)
+	return s.appendIndex(w.Bytes())
+}
+
+func (s *BlockStore) Tip() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.byHeight) == 0 {
+		return "", -1
+	}
+	return s.byHeight[len(s.byHeight)-1], len(s.byHeight) - 1
+}
+
+func (s *BlockStore) HashAtHeight(height int) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height < 0 || height >= len(s.byHeight) {
+		return "", false
+	}
+	return s.byHeight[height], true
+}
+
+func (s *BlockStore) BestChain() ([]block.Block, error) {
+	s.mu.Lock()
+	hashes := append([]string(nil), s.byHeight...)
+	s.mu.Unlock()
+	chain := make([]block.Block, 0, len(hashes))
+	for _, hash := range hashes {
+		blk, err := s.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, blk)
+	}
+	return chain, nil
+}
+
+func (s *BlockStore) setTip(hash string) error {
+	var ancestry []string
+	for cur := hash; ; {
+		if _, exists := s.byHash[cur]; !exists {
+			return fmt.Errorf("storage: missing ancestor %s of tip %s", cur, hash)
+		}
+		ancestry = append(ancestry, cur)
+		prev := s.prevHash[cur]
+		if _, exists := s.byHash[prev]; !exists {
+			if err := s.checkGenesis(cur); err != nil {
+				return fmt.Errorf("storage: missing ancestor %s of tip %s: %w", prev, hash, err)
+			}
+			break
+		}
+		cur = prev
+	}
+	heights := make([]string, len(ancestry))
+	for i, h := range ancestry {
+		heights[len(ancestry)-1-i] = h
+	}
+	s.byHeight = heights
+	return nil
+}
+
+// checkGenesis makes sure the block an ancestry walk ended at is really the
+// start of the chain rather than a block whose parent is missing.
+func (s *BlockStore) checkGenesis(hash string) error {
+	if hash == s.genesis {
+		return nil
+	}
+	blk, err := s.readBlock(s.byHash[hash])
+	if err != nil {
+		return err
+	}
+	if blk.Index != 0 {
+		return fmt.Errorf("block %s at height %d has no stored parent", hash, blk.Index)
+	}
+	s.genesis = hash
+	return nil
+}
+
+func (s *BlockStore) readBlock(loc location) (block.Block, error) {
+	f, err := os.Open(s.segmentPath(loc.Segment))
+	if err != nil {
+		return block.Block{}, err
+	}
+	defer f.Close()
+	data := make([]byte, loc.Length)
+	if _, err := f.ReadAt(data, loc.Offset); err != nil {
+		return block.Block{}, err
+	}
+	return block.Decode(data)
+}
+
+func (s *BlockStore) segmentPath(n int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("blk%05d.dat", n))
+}
+
+func (s *BlockStore) openSegment(n int) error {
+	f, err := os.OpenFile(s.segmentPath(n), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.segment = f
+	s.segNum = n
+	s.segSize = info.Size()
+	return nil
+}
+
+func (s *BlockStore) appendIndex(record []byte) error {
+	var frame [4]byte
+	binary.LittleEndian.PutUint32(frame[:], uint32(len(record)))
+	if _, err := s.index.Write(append(frame[:], record...)); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
+func (s *BlockStore) loadIndex() (string, error) {
+	path := filepath.Join(s.dir, indexFileName)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if os.IsNotExist(err) && s.hasSegments() {
+		return "", fmt.Errorf("storage: index missing")
+	}
+	tip := ""
+	valid := 0
+	for valid+4 <= len(data) {
+		n := int(binary.LittleEndian.Uint32(data[valid:]))
+		if valid+4+n > len(data) {
+			break
+		}
+		r := codec.NewReader(data[valid+4 : valid+4+n])
+		if err := s.applyIndexRecord(r, &tip); err != nil {
+			return "", err
+		}
+		valid += 4 + n
+	}
+	if len(s.byHash) == 0 && s.hasSegments() {
+		return "", fmt.Errorf("storage: index empty")
+	}
+	for _, loc := range s.byHash {
+		if loc.Segment > s.segNum {
+			s.segNum = loc.Segment
+		}
+	}
+	// Drop a torn trailing record left by a crash mid-write.
+	if err := os.Truncate(path, int64(valid)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	s.index, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	return tip, err
+}
+
+func (s *BlockStore) applyIndexRecord(r *codec.Reader, tip *string) error {
+	kind, err := r.ReadUint8()
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case recordBlock:
+		hash, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		prev, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		var fields [3]uint64
+		for i := range fields {
+			if fields[i], err = r.ReadVarInt(); err != nil {
+				return err
+			}
+		}
+		s.byHash[hash] = location{Segment: int(fields[0]), Offset: int64(fields[1]), Length: int(fields[2])}
+		s.prevHash[hash] = prev
+	case recordTip:
+		hash, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		*tip = hash
+	default:
+		return fmt.Errorf("storage: unknown index record %d", kind)
+	}
+	return nil
+}
+
+func (s *BlockStore) hasSegments() bool {
+	info, err := os.Stat(s.segmentPath(0))
+	return err == nil && info.Size() > 0
+}
+
+// scannedBlock is what rebuildIndex needs to know about a block to choose
+// the tip. order counts blocks in the order they were stored.
+type scannedBlock struct {
+	height int
+	bits   uint32
+	order  int
+}
+
+// rebuildIndex scans every segment file and picks the block with the most
+// work behind it and a complete ancestry as the tip. On a tie the block
+// stored first wins, as it would have when it arrived.
+func (s *BlockStore) rebuildIndex() (string, error) {
+	s.byHash = make(map[string]location)
+	s.prevHash = make(map[string]string)
+	s.genesis = ""
+	path := filepath.Join(s.dir, indexFileName)
+	index, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+	s.index = index
+	blocks := make(map[string]scannedBlock)
+	for n := 0; ; n++ {
+		if _, err := os.Stat(s.segmentPath(n)); os.IsNotExist(err) {
+			break
+		}
+		s.segNum = n
+		if err := s.scanSegment(n, blocks); err != nil {
+			return "", err
+		}
+	}
+	tip := ""
+	var best *big.Int
+	work := make(map[string]*big.Int)
+	for hash, blk := range blocks {
+		w := s.chainWork(hash, blocks, work)
+		if w == nil {
+			continue
+		}
+		if best == nil || w.Cmp(best) > 0 || w.Cmp(best) == 0 && blk.order < blocks[tip].order {
+			tip, best = hash, w
+		}
+	}
+	if tip == "" {
+		return "", nil
+	}
+	w := codec.NewWriter()
+	w.WriteUint8(recordTip)
+	w.WriteString(tip)
+	return tip, s.appendIndex(w.Bytes())
+}
+
+// scanSegment indexes the blocks in segment n. A damaged record is skipped
+// by searching for the next frame magic, so one torn write does not hide
+// the blocks after it.
+func (s *BlockStore) scanSegment(n int, blocks map[string]scannedBlock) error {
+	data, err := os.ReadFile(s.segmentPath(n))
+	if err != nil {
+		return err
+	}
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], segmentMagic)
+	offset := 0
+	for offset+8 <= len(data) {
+		if !bytes.Equal(data[offset:offset+4], magic[:]) {
+			next := bytes.Index(data[offset+1:], magic[:])
+			if next < 0 {
+				break
+			}
+			offset += 1 + next
+			continue
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := offset + 8 + length
+		if end > len(data) {
+			offset++
+			continue
+		}
+		blk, err := block.Decode(data[offset+8 : end])
+		if err != nil {
+			offset++
+			continue
+		}
+		loc := location{Segment: n, Offset: int64(offset + 8), Length: length}
+		s.byHash[blk.Hash] = loc
+		s.prevHash[blk.Hash] = blk.PrevHash
+		blocks[blk.Hash] = scannedBlock{height: blk.Index, bits: blk.Bits, order: len(blocks)}
+		if err := s.appendIndex(blockRecord(blk.Hash, blk.PrevHash, loc)); err != nil {
+			return err
+		}
+		offset = end
+	}
+	return nil
+}
+
+// chainWork returns the total work of hash and its ancestors, or nil if
+// its ancestry does not reach a genesis block. Results are memoized in work
+// so that scoring every block takes a single pass over each branch.
+func (s *BlockStore) chainWork(hash string, blocks map[string]scannedBlock, work map[string]*big.Int) *big.Int {
+	var path []string
+	var total *big.Int
+	for cur := hash; ; {
+		if w, done := work[cur]; done {
+			total = w
+			break
+		}
+		path = append(path, cur)
+		prev := s.prevHash[cur]
+		if _, exists := blocks[prev]; !exists {
+			if blocks[cur].height == 0 {
+				total = big.NewInt(0)
+			}
+			break
+		}
+		cur = prev
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if total != nil {
+			total = new(big.Int).Add(total, concensus.CalcWork(blocks[path[i]].bits))
+		}
+		work[path[i]] = total
+	}
+	return total
+}
+
+func blockRecord(hash, prevHash string, loc location) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(recordBlock)
+	w.WriteString(hash)
+	w.WriteString(prevHash)
+	w.WriteVarInt(uint64(loc.Segment))
+	w.WriteVarInt(uint64(loc.Offset))
+	w.WriteVarInt(uint64(loc.Length))
+	return w.Bytes()
+}
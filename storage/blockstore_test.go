@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/codec"
+	"blockchain-hello-golang/consensus"
+)
+
+// testBranch builds n blocks at difficulty bits on parent, or from genesis
+// if parent is nil. tag keeps sibling branches apart.
+func testBranch(parent *block.Block, n int, bits uint32, tag int64) []block.Block {
+	var blocks []block.Block
+	index, prev := 0, "0"
+	if parent != nil {
+		index, prev = parent.Index+1, parent.Hash
+	}
+	for i := 0; i < n; i++ {
+		blk := block.Block{Index: index + i, PrevHash: prev, Timestamp: tag*1000 + int64(i), Bits: bits}
+		blk.Hash = block.CalculateHash(blk)
+		blocks = append(blocks, blk)
+		prev = blk.Hash
+	}
+	return blocks
+}
+
+func testChain(n int) []block.Block {
+	return testBranch(nil, n, concensus.PowLimitBits, 0)
+}
+
+func fill(t *testing.T, dir string, blocks []block.Block, tip string) {
+	t.Helper()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range blocks {
+		if err := s.PutBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetTip(tip); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func removeIndex(dir string) error {
+	return os.Remove(filepath.Join(dir, indexFileName))
+}
+
+func TestReopen(t *testing.T) {
+	blocks := testChain(5)
+	// heavy has less height than blocks but far more work; twin ties.
+	heavy := testBranch(&blocks[0], 2, 0x1d00ffff, 1)
+	twin := testBranch(&blocks[0], 4, concensus.PowLimitBits, 2)
+	tests := []struct {
+		name    string
+		stored  []block.Block
+		tip     string
+		damage  func(dir string) error
+		wantTip block.Block
+		lost    []block.Block
+	}{
+		{"intact", blocks, blocks[4].Hash, func(string) error { return nil }, blocks[4], nil},
+		{"index missing", blocks, blocks[4].Hash, removeIndex, blocks[4], nil},
+		{"index empty", blocks, blocks[4].Hash, func(dir string) error {
+			return os.Truncate(filepath.Join(dir, indexFileName), 0)
+		}, blocks[4], nil},
+		{"torn record mid-segment", blocks, blocks[4].Hash, func(dir string) error {
+			// The second block's frame gets a garbage length; the blocks
+			// after it survive but without it have no ancestry.
+			path := filepath.Join(dir, "blk00000.dat")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			second := 8 + len(block.Encode(blocks[0]))
+			data[second+4] = 0xff
+			data[second+5] = 0xff
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return err
+			}
+			return removeIndex(dir)
+		}, blocks[0], blocks[1:2]},
+		{"index tip without ancestry", blocks, blocks[4].Hash, func(dir string) error {
+			w := codec.NewWriter()
+			w.WriteUint8(recordTip)
+			w.WriteString("unknown")
+			var frame [4]byte
+			binary.LittleEndian.PutUint32(frame[:], uint32(len(w.Bytes())))
+			f, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = f.Write(append(frame[:], w.Bytes()...))
+			return err
+		}, blocks[4], nil},
+		{"rebuild picks most work over height", append(append([]block.Block{}, blocks...), heavy...), blocks[4].Hash, removeIndex, heavy[1], nil},
+		{"rebuild keeps first stored on a tie", append(append([]block.Block{}, blocks...), twin...), blocks[4].Hash, removeIndex, blocks[4], nil},
+		{"rebuild keeps first stored twin on a tie", append(append([]block.Block{blocks[0]}, twin...), blocks[1:]...), twin[3].Hash, removeIndex, twin[3], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fill(t, dir, tt.stored, tt.tip)
+			if err := tt.damage(dir); err != nil {
+				t.Fatal(err)
+			}
+			s, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if hash, height := s.Tip(); hash != tt.wantTip.Hash || height != tt.wantTip.Index {
+				t.Fatalf("tip %s at %d, want %s at %d", hash, height, tt.wantTip.Hash, tt.wantTip.Index)
+			}
+			lost := make(map[string]bool)
+			for _, blk := range tt.lost {
+				lost[blk.Hash] = true
+			}
+			for i, blk := range tt.stored {
+				got, err := s.GetBlock(blk.Hash)
+				if lost[blk.Hash] {
+					if err != ErrBlockNotFound {
+						t.Fatalf("block %d: got %v, want %v", i, err, ErrBlockNotFound)
+					}
+					continue
+				}
+				if err != nil || got.Hash != blk.Hash {
+					t.Fatalf("block %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetTipNeedsAncestry(t *testing.T) {
+	blocks := testChain(3)
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, blk := range []block.Block{blocks[0], blocks[2]} {
+		if err := s.PutBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetTip(blocks[2].Hash); err == nil {
+		t.Fatal("SetTip accepted a tip whose parent is missing")
+	}
+	if err := s.PutBlock(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTip(blocks[2].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if hash, height := s.Tip(); hash != blocks[2].Hash || height != 2 {
+		t.Fatalf("tip %s at %d", hash, height)
+	}
+}
@@ -0,0 +1,187 @@
+package transaction
+
+import (
+	"fmt"
+
+	"blockchain-hello-golang/codec"
+)
+
+const EncodingVersion = 3
+
+func Encode(tx Transaction) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeTransaction(w, tx)
+	return w.Bytes()
+}
+
+func Decode(data []byte) (Transaction, error) {
+	r := codec.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return Transaction{}, err
+	}
+	tx, err := DecodeFrom(r)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return tx, r.Done()
+}
+
+func EncodeInput(in Input) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeInput(w, in)
+	return w.Bytes()
+}
+
+func DecodeInput(data []byte) (Input, error) {
+	r := codec.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return Input{}, err
+	}
+	in, err := decodeInput(r)
+	if err != nil {
+		return Input{}, err
+	}
+	return in, r.Done()
+}
+
+func EncodeOutput(out Output) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeOutput(w, out)
+	return w.Bytes()
+}
+
+func DecodeOutput(data []byte) (Output, error) {
+	r := codec.NewReader(data)
+	if err := readVersion(r); err != nil {
+		return Output{}, err
+	}
+	out, err := decodeOutput(r)
+	if err != nil {
+		return Output{}, err
+	}
+	return out, r.Done()
+}
+
+// EncodeTo writes tx without a version prefix so it can be embedded in a
+// larger structure such as a block.
+func EncodeTo(w *codec.Writer, tx Transaction) {
+	encodeTransaction(w, tx)
+}
+
+func DecodeFrom(r *codec.Reader) (Transaction, error) {
+	var tx Transaction
+	id, err := r.ReadString()
+	if err != nil {
+		return tx, err
+	}
+	tx.ID = id
+	n, err := r.ReadLength()
+	if err != nil {
+		return tx, err
+	}
+	tx.Inputs = make([]Input, 0, n)
+	for i := 0; i < n; i++ {
+		in, err := decodeInput(r)
+		if err != nil {
+			return tx, err
+		}
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	n, err = r.ReadLength()
+	if err != nil {
+		return tx, err
+	}
+	tx.Outputs = make([]Output, 0, n)
+	for i := 0; i < n; i++ {
+		out, err := decodeOutput(r)
+		if err != nil {
+			return tx, err
+		}
+		tx.Outputs = append(tx.Outputs, out)
+	}
+	if tx.LockTime, err = r.ReadInt64(); err != nil {
+		return tx, err
+	}
+	return tx, nil
+}
+
+func encodeTransaction(w *codec.Writer, tx Transaction) {
+	w.WriteString(tx.ID)
+	encodeBody(w, tx)
+}
+
+// encodeBody covers everything but the ID, which is derived from it.
+func encodeBody(w *codec.Writer, tx Transaction) {
+	w.WriteVarInt(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		encodeInput(w, in)
+	}
+	w.WriteVarInt(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		encodeOutput(w, out)
+	}
+	w.WriteInt64(tx.LockTime)
+}
+
+func encodeInput(w *codec.Writer, in Input) {
+	w.WriteString(in.PrevTxID)
+	w.WriteVarInt(uint64(in.OutputIndex))
+	w.WriteString(in.ScriptSig)
+	w.WriteBytes(in.Signature)
+	w.WriteBytes(in.PubKey)
+}
+
+func decodeInput(r *codec.Reader) (Input, error) {
+	var in Input
+	var err error
+	if in.PrevTxID, err = r.ReadString(); err != nil {
+		return in, err
+	}
+	index, err := r.ReadVarInt()
+	if err != nil {
+		return in, err
+	}
+	in.OutputIndex = int(index)
+	if in.ScriptSig, err = r.ReadString(); err != nil {
+		return in, err
+	}
+	if in.Signature, err = r.ReadBytes(); err != nil {
+		return in, err
+	}
+	if in.PubKey, err = r.ReadBytes(); err != nil {
+		return in, err
+	}
+	return in, nil
+}
+
+func encodeOutput(w *codec.Writer, out Output) {
+	w.WriteInt64(int64(out.Value))
+	w.WriteString(out.ScriptPubKey)
+}
+
+func decodeOutput(r *codec.Reader) (Output, error) {
+	var out Output
+	value, err := r.ReadInt64()
+	if err != nil {
+		return out, err
+	}
+	out.Value = int(value)
+	if out.ScriptPubKey, err = r.ReadString(); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+func readVersion(r *codec.Reader) error {
+	version, err := r.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if version != EncodingVersion {
+		return fmt.Errorf("transaction: unsupported encoding version %d", version)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package transaction
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   Transaction
+	}{
+		{"coinbase", Transaction{ID: "cb", Inputs: []Input{}, Outputs: []Output{{Value: 50, ScriptPubKey: "miner"}}, LockTime: 7}},
+		{"signed", Transaction{
+			ID: "spend",
+			Inputs: []Input{
+				{PrevTxID: "a", OutputIndex: 1, ScriptSig: "sig", Signature: []byte{1, 2}, PubKey: []byte{3}},
+				{PrevTxID: "b", OutputIndex: 300, Signature: []byte{}, PubKey: []byte{}},
+			},
+			Outputs:  []Output{{Value: MaxMoney, ScriptPubKey: "x"}, {Value: 0}},
+			LockTime: LockTimeThreshold + 1,
+		}},
+		{"no outputs", Transaction{ID: "empty", Inputs: []Input{}, Outputs: []Output{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := Encode(tt.tx)
+			got, err := Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.tx) {
+				t.Fatalf("got %+v, want %+v", got, tt.tx)
+			}
+			if !bytes.Equal(Encode(got), data) {
+				t.Fatal("re-encoding differs")
+			}
+			if _, err := Decode(data[:len(data)-1]); err == nil {
+				t.Fatal("truncated transaction decoded")
+			}
+			if _, err := Decode(append(data, 0)); err == nil {
+				t.Fatal("trailing byte accepted")
+			}
+			bad := append([]byte{EncodingVersion + 1}, data[1:]...)
+			if _, err := Decode(bad); err == nil {
+				t.Fatal("unknown encoding version accepted")
+			}
+		})
+	}
+}
+
+func TestCalculateIDIgnoresID(t *testing.T) {
+	tx := Transaction{Inputs: []Input{}, Outputs: []Output{{Value: 1}}}
+	id := CalculateID(tx)
+	tx.ID = "anything"
+	if CalculateID(tx) != id {
+		t.Fatal("ID depends on the ID field")
+	}
+	tx.LockTime = 1
+	if CalculateID(tx) == id {
+		t.Fatal("ID does not cover LockTime")
+	}
+}
@@ -0,0 +1,192 @@
+package transaction
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"blockchain-hello-golang/codec"
+	"blockchain-hello-golang/crypto"
+	"blockchain-hello-golang/script"
+)
+
+type SigHashType uint8
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+const sigComponentSize = 32
+
+var (
+	ErrBadSigHashType = errors.New("transaction: invalid sighash type")
+	ErrBadSignature   = errors.New("transaction: signature verification failed")
+	ErrBadPubKey      = errors.New("transaction: invalid public key")
+	ErrBadScript      = errors.New("transaction: script is not valid hex")
+	ErrInputIndex     = errors.New("transaction: input index out of range")
+	ErrHighS          = errors.New("transaction: signature S value is not low")
+)
+
+func (t SigHashType) base() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+func (t SigHashType) valid() bool {
+	base := t.base()
+	return t&^(SigHashAnyoneCanPay|0x03) == 0 && base >= SigHashAll && base <= SigHashSingle
+}
+
+// SignatureHash commits to the parts of tx selected by hashType. The input
+// being signed carries the spent output's ScriptPubKey in place of its
+// ScriptSig and every signature is blanked, so signing order doesn't matter.
+func SignatureHash(tx Transaction, index int, prevOut Output, hashType SigHashType) ([]byte, error) {
+	if index < 0 || index >= len(tx.Inputs) {
+		return nil, ErrInputIndex
+	}
+	if !hashType.valid() {
+		return nil, ErrBadSigHashType
+	}
+
+	var inputs []Input
+	if hashType&SigHashAnyoneCanPay != 0 {
+		inputs = []Input{{PrevTxID: tx.Inputs[index].PrevTxID, OutputIndex: tx.Inputs[index].OutputIndex, ScriptSig: prevOut.ScriptPubKey}}
+	} else {
+		for _, in := range tx.Inputs {
+			inputs = append(inputs, Input{PrevTxID: in.PrevTxID, OutputIndex: in.OutputIndex})
+		}
+		inputs[index].ScriptSig = prevOut.ScriptPubKey
+	}
+
+	var outputs []Output
+	switch hashType.base() {
+	case SigHashAll:
+		outputs = tx.Outputs
+	case SigHashNone:
+		outputs = nil
+	case SigHashSingle:
+		if index >= len(tx.Outputs) {
+			return nil, ErrInputIndex
+		}
+		outputs = make([]Output, index+1)
+		for i := 0; i < index; i++ {
+			outputs[i] = Output{Value: -1}
+		}
+		outputs[index] = tx.Outputs[index]
+	}
+
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeBody(w, Transaction{Inputs: inputs, Outputs: outputs, LockTime: tx.LockTime})
+	w.WriteUint32(uint32(hashType))
+	hash := sha256.Sum256(w.Bytes())
+	return hash[:], nil
+}
+
+// SignInput signs input index of tx and recomputes the transaction ID,
+// which covers signatures.
+func SignInput(tx *Transaction, index int, privKey *ecdsa.PrivateKey, prevOut Output, hashType SigHashType) error {
+	hash, err := SignatureHash(*tx, index, prevOut, hashType)
+	if err != nil {
+		return err
+	}
+	r, s, err := crypto.SignMessage(privKey, hash)
+	if err != nil {
+		return err
+	}
+	tx.Inputs[index].Signature = encodeSignature(r, s, hashType)
+	tx.Inputs[index].PubKey = crypto.MarshalPublicKey(&privKey.PublicKey)
+	tx.ID = CalculateID(*tx)
+	return nil
+}
+
+// VerifyInput runs the input's ScriptSig against the spent output's
+// ScriptPubKey. Both hold hex-encoded scripts; an input with an empty
+// ScriptSig is treated as the standard <Signature> <PubKey> pair.
+func VerifyInput(tx Transaction, index int, prevOut Output) error {
+	if index < 0 || index >= len(tx.Inputs) {
+		return ErrInputIndex
+	}
+	in := tx.Inputs[index]
+	pkScript, err := hex.DecodeString(prevOut.ScriptPubKey)
+	if err != nil {
+		return ErrBadScript
+	}
+	sigScript := script.SignatureScript(in.Signature, in.PubKey)
+	if in.ScriptSig != "" {
+		if sigScript, err = hex.DecodeString(in.ScriptSig); err != nil {
+			return ErrBadScript
+		}
+	}
+	return script.Verify(sigScript, pkScript, txChecker{tx: tx, index: index, prevOut: prevOut})
+}
+
+type txChecker struct {
+	tx      Transaction
+	index   int
+	prevOut Output
+}
+
+func (c txChecker) CheckSig(sig, pubKey []byte) bool {
+	key, err := crypto.ParsePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	return CheckSignature(c.tx, c.index, c.prevOut, sig, key) == nil
+}
+
+// CheckLockTime follows BIP65: both lock times must be of the same kind and
+// the transaction's must have reached the script's.
+func (c txChecker) CheckLockTime(lockTime int64) bool {
+	if (lockTime < LockTimeThreshold) != (c.tx.LockTime < LockTimeThreshold) {
+		return false
+	}
+	return lockTime <= c.tx.LockTime
+}
+
+// PayToPubKeyHash returns the hex ScriptPubKey that locks an output to
+// pubKey's hash.
+func PayToPubKeyHash(pubKey *ecdsa.PublicKey) string {
+	return hex.EncodeToString(script.PayToPubKeyHash(crypto.Hash160(crypto.MarshalPublicKey(pubKey))))
+}
+
+// CheckSignature verifies sig, which carries its hash type in the last
+// byte, over the parts of tx it covers. Only low-S signatures pass.
+func CheckSignature(tx Transaction, index int, prevOut Output, sig []byte, pubKey *ecdsa.PublicKey) error {
+	r, s, hashType, ok := decodeSignature(sig)
+	if !ok {
+		return ErrBadSignature
+	}
+	if !crypto.IsLowS(pubKey.Curve, s) {
+		return ErrHighS
+	}
+	hash, err := SignatureHash(tx, index, prevOut, hashType)
+	if err != nil {
+		return err
+	}
+	if !crypto.VerifySignature(pubKey, hash, r, s) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func encodeSignature(r, s *big.Int, hashType SigHashType) []byte {
+	sig := make([]byte, 2*sigComponentSize+1)
+	r.FillBytes(sig[:sigComponentSize])
+	s.FillBytes(sig[sigComponentSize : 2*sigComponentSize])
+	sig[2*sigComponentSize] = byte(hashType)
+	return sig
+}
+
+func decodeSignature(sig []byte) (*big.Int, *big.Int, SigHashType, bool) {
+	if len(sig) != 2*sigComponentSize+1 {
+		return nil, nil, 0, false
+	}
+	r := new(big.Int).SetBytes(sig[:sigComponentSize])
+	s := new(big.Int).SetBytes(sig[sigComponentSize : 2*sigComponentSize])
+	return r, s, SigHashType(sig[2*sigComponentSize]), true
+}
@@ -0,0 +1,176 @@
+package transaction
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"blockchain-hello-golang/crypto"
+	"blockchain-hello-golang/script"
+)
+
+// sighashTx has more inputs than outputs so SIGHASH_SINGLE can run past
+// the outputs.
+func sighashTx() Transaction {
+	return Transaction{
+		Inputs: []Input{
+			{PrevTxID: "a", OutputIndex: 0},
+			{PrevTxID: "b", OutputIndex: 1},
+			{PrevTxID: "c", OutputIndex: 2},
+		},
+		Outputs:  []Output{{Value: 10, ScriptPubKey: "00"}, {Value: 20, ScriptPubKey: "01"}},
+		LockTime: 5,
+	}
+}
+
+func TestSignatureHash(t *testing.T) {
+	prevOut := Output{Value: 30, ScriptPubKey: "76"}
+	changeOutput0 := func(tx *Transaction) { tx.Outputs[0].Value++ }
+	changeOutput1 := func(tx *Transaction) { tx.Outputs[1].Value++ }
+	changeOtherInput := func(tx *Transaction) { tx.Inputs[1].OutputIndex++ }
+	signOtherInput := func(tx *Transaction) { tx.Inputs[1].Signature = []byte{1} }
+	addInput := func(tx *Transaction) { tx.Inputs = append(tx.Inputs, Input{PrevTxID: "d"}) }
+	changeLockTime := func(tx *Transaction) { tx.LockTime++ }
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		index    int
+		mutate   func(tx *Transaction)
+		same     bool
+	}{
+		{"all covers outputs", SigHashAll, 0, changeOutput1, false},
+		{"all covers other inputs", SigHashAll, 0, changeOtherInput, false},
+		{"all covers lock time", SigHashAll, 0, changeLockTime, false},
+		{"all ignores other signatures", SigHashAll, 0, signOtherInput, true},
+		{"none ignores outputs", SigHashNone, 0, changeOutput0, true},
+		{"none covers other inputs", SigHashNone, 0, changeOtherInput, false},
+		{"single covers its output", SigHashSingle, 1, changeOutput1, false},
+		{"single ignores other outputs", SigHashSingle, 1, changeOutput0, true},
+		{"anyonecanpay ignores other inputs", SigHashAll | SigHashAnyoneCanPay, 0, changeOtherInput, true},
+		{"anyonecanpay allows more inputs", SigHashAll | SigHashAnyoneCanPay, 0, addInput, true},
+		{"anyonecanpay all covers outputs", SigHashAll | SigHashAnyoneCanPay, 0, changeOutput1, false},
+		{"anyonecanpay none ignores outputs", SigHashNone | SigHashAnyoneCanPay, 0, changeOutput1, true},
+		{"anyonecanpay single ignores other outputs", SigHashSingle | SigHashAnyoneCanPay, 0, changeOutput1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := sighashTx()
+			before, err := SignatureHash(tx, tt.index, prevOut, tt.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.mutate(&tx)
+			after, err := SignatureHash(tx, tt.index, prevOut, tt.hashType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := bytes.Equal(before, after); same != tt.same {
+				t.Fatalf("hash unchanged: %v, want %v", same, tt.same)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		name     string
+		hashType SigHashType
+		index    int
+		want     error
+	}{
+		{"single past the outputs", SigHashSingle, 2, ErrInputIndex},
+		{"anyonecanpay single past the outputs", SigHashSingle | SigHashAnyoneCanPay, 2, ErrInputIndex},
+		{"input out of range", SigHashAll, 3, ErrInputIndex},
+		{"negative input", SigHashAll, -1, ErrInputIndex},
+		{"zero type", 0, 0, ErrBadSigHashType},
+		{"unknown base type", 0x04, 0, ErrBadSigHashType},
+		{"unknown flag", SigHashAll | 0x40, 0, ErrBadSigHashType},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SignatureHash(sighashTx(), tt.index, prevOut, tt.hashType); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignAndVerifyInput(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut := Output{Value: 30, ScriptPubKey: PayToPubKeyHash(pub)}
+
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		tamper   func(tx *Transaction)
+		want     error
+	}{
+		{"all untouched", SigHashAll, func(*Transaction) {}, nil},
+		{"all with changed output", SigHashAll, func(tx *Transaction) { tx.Outputs[0].Value-- }, script.ErrEvalFalse},
+		{"all with changed lock time", SigHashAll, func(tx *Transaction) { tx.LockTime++ }, script.ErrEvalFalse},
+		{"all with added input", SigHashAll, func(tx *Transaction) { tx.Inputs = append(tx.Inputs, Input{PrevTxID: "d"}) }, script.ErrEvalFalse},
+		{"none with changed output", SigHashNone, func(tx *Transaction) { tx.Outputs[1].ScriptPubKey = "02" }, nil},
+		{"none with changed input", SigHashNone, func(tx *Transaction) { tx.Inputs[2].PrevTxID = "x" }, script.ErrEvalFalse},
+		{"single with other output changed", SigHashSingle, func(tx *Transaction) { tx.Outputs[1].Value++ }, nil},
+		{"single with own output changed", SigHashSingle, func(tx *Transaction) { tx.Outputs[0].Value++ }, script.ErrEvalFalse},
+		{"anyonecanpay with added input", SigHashAll | SigHashAnyoneCanPay, func(tx *Transaction) { tx.Inputs = append(tx.Inputs, Input{PrevTxID: "d"}) }, nil},
+		{"anyonecanpay with changed output", SigHashAll | SigHashAnyoneCanPay, func(tx *Transaction) { tx.Outputs[1].Value++ }, script.ErrEvalFalse},
+		{"spent output swapped", SigHashAll, func(tx *Transaction) { tx.Inputs[0].OutputIndex = 9 }, script.ErrEvalFalse},
+		{"pubkey swapped", SigHashAll, func(tx *Transaction) {
+			_, other, _ := GenerateKeyPair()
+			tx.Inputs[0].PubKey = crypto.MarshalPublicKey(other)
+		}, script.ErrEqualVerifyFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := sighashTx()
+			if err := SignInput(&tx, 0, priv, prevOut, tt.hashType); err != nil {
+				t.Fatal(err)
+			}
+			if tx.ID != CalculateID(tx) {
+				t.Fatal("SignInput left a stale ID")
+			}
+			if err := VerifyInput(tx, 0, prevOut); err != nil {
+				t.Fatalf("freshly signed input: %v", err)
+			}
+			tt.tamper(&tx)
+			if err := VerifyInput(tx, 0, prevOut); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if err := VerifyInput(sighashTx(), 3, prevOut); !errors.Is(err, ErrInputIndex) {
+		t.Fatalf("input out of range: %v, want %v", err, ErrInputIndex)
+	}
+}
+
+func TestCheckSignatureRejectsHighS(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevOut := Output{Value: 30, ScriptPubKey: PayToPubKeyHash(pub)}
+	for i := 0; i < 16; i++ {
+		tx := sighashTx()
+		if err := SignInput(&tx, 0, priv, prevOut, SigHashAll); err != nil {
+			t.Fatal(err)
+		}
+		sig := tx.Inputs[0].Signature
+		if err := CheckSignature(tx, 0, prevOut, sig, pub); err != nil {
+			t.Fatalf("signature %d: %v", i, err)
+		}
+		// Negating s gives the other valid signature, which must not pass.
+		r, s, hashType, _ := decodeSignature(sig)
+		high := encodeSignature(r, new(big.Int).Sub(pub.Curve.Params().N, s), hashType)
+		if err := CheckSignature(tx, 0, prevOut, high, pub); !errors.Is(err, ErrHighS) {
+			t.Fatalf("high-S signature %d: got %v, want %v", i, err, ErrHighS)
+		}
+	}
+	tx := sighashTx()
+	if err := CheckSignature(tx, 0, prevOut, []byte{1, 2, 3}, pub); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("short signature: got %v, want %v", err, ErrBadSignature)
+	}
+}
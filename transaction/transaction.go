@@ -7,12 +7,16 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"math/big"
+
+	"blockchain-hello-golang/codec"
 )
 
 type Input struct {
 	PrevTxID    string
 	OutputIndex int
 	ScriptSig   string
+	Signature   []byte
+	PubKey      []byte
 }
 
 type Output struct {
@@ -21,14 +25,38 @@ type Output struct {
 }
 
 type Transaction struct {
-	ID      string
-	Inputs  []Input
-	Outputs []Output
+	ID       string
+	Inputs   []Input
+	Outputs  []Output
+	LockTime int64
+}
+
+// MaxMoney bounds every amount: no output, and no sum of outputs or
+// inputs, may exceed it. It is the total the block subsidy ever pays.
+const MaxMoney = 21000000
+
+// MoneyRange reports whether value is a valid amount.
+func MoneyRange(value int) bool {
+	return value >= 0 && value <= MaxMoney
+}
+
+// LockTimeThreshold separates lock times given as block heights (below)
+// from ones given as unix timestamps.
+const LockTimeThreshold = 500000000
+
+func IsFinal(tx Transaction, height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < LockTimeThreshold {
+		return tx.LockTime < int64(height)
+	}
+	return tx.LockTime < blockTime
 }
 
 func createTransaction(inputs []Input, outputs []Output) Transaction {
 	tx := Transaction{Inputs: inputs, Outputs: outputs}
-	tx.ID = calculateTransactionID(tx)
+	tx.ID = CalculateID(tx)
 	return tx
 }
 
@@ -36,11 +64,14 @@ func validateTransaction(tx Transaction, utxoSet map[string]Output) bool {
 	inputSum := 0
 	outputSum := 0
 
-	for _, input := range tx.Inputs {
+	for i, input := range tx.Inputs {
 		utxo, exists := utxoSet[input.PrevTxID]
 		if !exists || utxo.Value <= 0 {
 			return false
 		}
+		if VerifyInput(tx, i, utxo) != nil {
+			return false
+		}
 		inputSum += utxo.Value
 	}
 
@@ -51,25 +82,15 @@ func validateTransaction(tx Transaction, utxoSet map[string]Output) bool {
 	return inputSum >= outputSum
 }
 
-func calculateTransactionID(tx Transaction) string {
-	record := ""
-	for _, input := range tx.Inputs {
-		record += input.PrevTxID + string(input.OutputIndex) + input.ScriptSig
-	}
-	for _, output := range tx.Outputs {
-		record += string(output.Value) + output.ScriptPubKey
-	}
-	h := sha256.New()
-	h.Write([]byte(record))
-	hashed := h.Sum(nil)
+// CalculateID hashes everything in tx but the ID itself.
+func CalculateID(tx Transaction) string {
+	w := codec.NewWriter()
+	w.WriteUint8(EncodingVersion)
+	encodeBody(w, tx)
+	hashed := sha256.Sum256(w.Bytes())
 	return fmt.Sprintf("%x", hashed)
 }
 
-func validateScript(scriptSig, scriptPubKey string) bool {
-	// Simplified script validation for now
-	return scriptSig == scriptPubKey
-}
-
 func GenerateKeyPair() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -0,0 +1,605 @@
+package utxo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/codec"
+	"blockchain-hello-golang/transaction"
+)
+
+const dbVersion = 2
+const snapshotFileName = "utxo.dat"
+const logFileName = "utxo.log"
+const undoDirName = "undo"
+
+// compactInterval is how many block deltas the log holds before they are
+// folded into a new snapshot.
+const compactInterval = 1000
+
+var ErrMissingInput = errors.New("utxo: input spends unknown or spent output")
+var ErrCorrupt = errors.New("utxo: database failed consistency check")
+var ErrNotTip = errors.New("utxo: block is not the current tip")
+
+type OutPoint struct {
+	TxID  string
+	Index int
+}
+
+type Entry struct {
+	Output   transaction.Output
+	Height   int
+	Coinbase bool
+}
+
+type SpentOutput struct {
+	OutPoint OutPoint
+	Entry    Entry
+}
+
+type Undo struct {
+	BlockHash string
+	PrevHash  string
+	Spent     []SpentOutput
+}
+
+// DB keeps the UTXO set in memory. On disk it is a snapshot plus a log of
+// per-block deltas appended after it; the log is compacted into a new
+// snapshot every compactInterval blocks.
+type DB struct {
+	dir    string
+	mu     sync.Mutex
+	coins  map[OutPoint]Entry
+	tip    string
+	height int
+	// seq numbers log records so that records already folded into the
+	// snapshot are skipped if a crash leaves them in the log.
+	seq        uint64
+	logSize    int64
+	logRecords int
+}
+
+// delta is the change one block makes to the UTXO set.
+type delta struct {
+	seq     uint64
+	prevTip string
+	tip     string
+	height  int
+	removed []OutPoint
+	added   map[OutPoint]Entry
+}
+
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Join(dir, undoDirName), 0o755); err != nil {
+		return nil, err
+	}
+	db := &DB{dir: dir, coins: make(map[OutPoint]Entry), height: -1}
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := db.load(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.replayLog(); err != nil {
+		return nil, err
+	}
+	if db.tip != "" {
+		if _, err := os.Stat(db.undoPath(db.tip)); err != nil {
+			return nil, ErrCorrupt
+		}
+	}
+	return db, nil
+}
+
+func (db *DB) Tip() (string, int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.tip, db.height
+}
+
+func (db *DB) Get(op OutPoint) (Entry, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	entry, exists := db.coins[op]
+	return entry, exists
+}
+
+func (db *DB) Size() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.coins)
+}
+
+// ApplyBlock spends the block's inputs and adds its outputs. Either every
+// change lands on disk together with the undo record or none of them do.
+func (db *DB) ApplyBlock(blk block.Block) (Undo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if blk.PrevHash != db.tip && db.height >= 0 {
+		return Undo{}, fmt.Errorf("utxo: block %s does not extend tip %s", blk.Hash, db.tip)
+	}
+	undo := Undo{BlockHash: blk.Hash, PrevHash: blk.PrevHash}
+	added := make(map[OutPoint]Entry)
+	spent := make(map[OutPoint]bool)
+	for _, tx := range blk.Transactions {
+		for _, input := range tx.Inputs {
+			op := OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex}
+			if spent[op] {
+				return Undo{}, ErrMissingInput
+			}
+			if entry, exists := added[op]; exists {
+				delete(added, op)
+				spent[op] = true
+				undo.Spent = append(undo.Spent, SpentOutput{OutPoint: op, Entry: entry})
+				continue
+			}
+			entry, exists := db.coins[op]
+			if !exists {
+				return Undo{}, ErrMissingInput
+			}
+			spent[op] = true
+			undo.Spent = append(undo.Spent, SpentOutput{OutPoint: op, Entry: entry})
+		}
+		for index, output := range tx.Outputs {
+			added[OutPoint{TxID: tx.ID, Index: index}] = Entry{Output: output, Height: db.height + 1, Coinbase: len(tx.Inputs) == 0}
+		}
+	}
+
+	if err := writeFileAtomic(db.undoPath(blk.Hash), encodeUndo(undo)); err != nil {
+		return Undo{}, err
+	}
+	d := delta{prevTip: db.tip, tip: blk.Hash, height: db.height + 1, added: added}
+	for op := range spent {
+		d.removed = append(d.removed, op)
+	}
+	if err := db.commit(d); err != nil {
+		return Undo{}, err
+	}
+	return undo, nil
+}
+
+// DisconnectBlock reverts the tip block using its stored undo record.
+func (db *DB) DisconnectBlock(blk block.Block) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if blk.Hash != db.tip {
+		return ErrNotTip
+	}
+	data, err := os.ReadFile(db.undoPath(blk.Hash))
+	if err != nil {
+		return err
+	}
+	undo, err := decodeUndo(data)
+	if err != nil {
+		return err
+	}
+	if undo.BlockHash != blk.Hash {
+		return ErrCorrupt
+	}
+	// Outputs both created and spent inside blk are in undo.Spent too, so
+	// restore first and then remove everything blk created.
+	created := make(map[OutPoint]bool)
+	for _, tx := range blk.Transactions {
+		for index := range tx.Outputs {
+			created[OutPoint{TxID: tx.ID, Index: index}] = true
+		}
+	}
+	tip := undo.PrevHash
+	if db.height == 0 {
+		tip = ""
+	}
+	d := delta{prevTip: db.tip, tip: tip, height: db.height - 1, added: make(map[OutPoint]Entry)}
+	for _, so := range undo.Spent {
+		if !created[so.OutPoint] {
+			d.added[so.OutPoint] = so.Entry
+		}
+	}
+	for op := range created {
+		d.removed = append(d.removed, op)
+	}
+	if err := db.commit(d); err != nil {
+		return err
+	}
+	return os.Remove(db.undoPath(blk.Hash))
+}
+
+// commit appends d to the log and only then applies it in memory.
+func (db *DB) commit(d delta) error {
+	d.seq = db.seq + 1
+	if err := db.appendLog(d); err != nil {
+		return err
+	}
+	db.apply(d)
+	if db.logRecords >= compactInterval {
+		// The delta is already durable, so a failed compaction only means
+		// the log keeps growing until the next attempt.
+		if err := db.compact(); err != nil {
+			log.Printf("utxo: compact: %v", err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) apply(d delta) {
+	for _, op := range d.removed {
+		delete(db.coins, op)
+	}
+	for op, entry := range d.added {
+		db.coins[op] = entry
+	}
+	db.seq, db.tip, db.height = d.seq, d.tip, d.height
+}
+
+func (db *DB) ValidateTransaction(tx transaction.Transaction) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	inputSum := 0
+	outputSum := 0
+
+	for _, input := range tx.Inputs {
+		entry, exists := db.coins[OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex}]
+		if !exists || entry.Output.Value <= 0 {
+			return false
+		}
+		inputSum += entry.Output.Value
+	}
+
+	for _, output := range tx.Outputs {
+		outputSum += output.Value
+	}
+
+	return inputSum >= outputSum
+}
+
+func (db *DB) undoPath(hash string) string {
+	return filepath.Join(db.dir, undoDirName, hash+".dat")
+}
+
+func (db *DB) logPath() string {
+	return filepath.Join(db.dir, logFileName)
+}
+
+// appendLog writes one checksummed record. A failed write is cut off again
+// so the next record does not land after a torn one.
+func (db *DB) appendLog(d delta) error {
+	f, err := os.OpenFile(db.logPath(), os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	payload := encodeDelta(d)
+	checksum := sha256.Sum256(payload)
+	w := codec.NewWriter()
+	w.WriteUint32(uint32(len(payload)))
+	record := append(append(w.Bytes(), payload...), checksum[:4]...)
+	if _, err := f.WriteAt(record, db.logSize); err != nil {
+		f.Truncate(db.logSize)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Truncate(db.logSize)
+		return err
+	}
+	if db.logSize == 0 {
+		if err := syncDir(db.dir); err != nil {
+			return err
+		}
+	}
+	db.logSize += int64(len(record))
+	db.logRecords++
+	return nil
+}
+
+// replayLog applies the log on top of the snapshot. Records the snapshot
+// already covers are skipped, and a torn record at the end, left by a crash
+// mid-append, is dropped.
+func (db *DB) replayLog() error {
+	data, err := os.ReadFile(db.logPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	offset := 0
+	for offset < len(data) {
+		d, n, err := decodeRecord(data[offset:])
+		if err != nil {
+			break
+		}
+		if d.seq > db.seq {
+			if d.seq != db.seq+1 || d.prevTip != db.tip {
+				return ErrCorrupt
+			}
+			db.apply(d)
+		}
+		offset += n
+		db.logRecords++
+	}
+	if offset < len(data) {
+		if err := os.Truncate(db.logPath(), int64(offset)); err != nil {
+			return err
+		}
+	}
+	db.logSize = int64(offset)
+	return nil
+}
+
+// compact writes the current set as a new snapshot and empties the log.
+func (db *DB) compact() error {
+	if err := db.persist(); err != nil {
+		return err
+	}
+	if err := os.Truncate(db.logPath(), 0); err != nil {
+		return err
+	}
+	db.logSize, db.logRecords = 0, 0
+	return nil
+}
+
+func (db *DB) persist() error {
+	w := codec.NewWriter()
+	w.WriteUint8(dbVersion)
+	w.WriteUint64(db.seq)
+	w.WriteString(db.tip)
+	w.WriteInt64(int64(db.height))
+	w.WriteVarInt(uint64(len(db.coins)))
+	for op, entry := range db.coins {
+		encodeCoin(w, op, entry)
+	}
+	payload := w.Bytes()
+	checksum := sha256.Sum256(payload)
+	return writeFileAtomic(filepath.Join(db.dir, snapshotFileName), append(payload, checksum[:]...))
+}
+
+// load verifies the snapshot checksum and that the stored coin count matches
+// what was decoded before trusting any of it.
+func (db *DB) load(data []byte) error {
+	if len(data) < sha256.Size {
+		return ErrCorrupt
+	}
+	payload, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	checksum := sha256.Sum256(payload)
+	if !bytes.Equal(checksum[:], sum) {
+		return ErrCorrupt
+	}
+	r := codec.NewReader(payload)
+	version, err := r.ReadUint8()
+	if err != nil || version != dbVersion {
+		return ErrCorrupt
+	}
+	if db.seq, err = r.ReadUint64(); err != nil {
+		return ErrCorrupt
+	}
+	if db.tip, err = r.ReadString(); err != nil {
+		return ErrCorrupt
+	}
+	height, err := r.ReadInt64()
+	if err != nil {
+		return ErrCorrupt
+	}
+	db.height = int(height)
+	n, err := r.ReadLength()
+	if err != nil {
+		return ErrCorrupt
+	}
+	for i := 0; i < n; i++ {
+		op, entry, err := decodeCoin(r)
+		if err != nil {
+			return ErrCorrupt
+		}
+		db.coins[op] = entry
+	}
+	if r.Done() != nil || len(db.coins) != n {
+		return ErrCorrupt
+	}
+	if (db.height < 0) != (db.tip == "") {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func encodeCoin(w *codec.Writer, op OutPoint, entry Entry) {
+	w.WriteString(op.TxID)
+	w.WriteVarInt(uint64(op.Index))
+	w.WriteBytes(transaction.EncodeOutput(entry.Output))
+	w.WriteVarInt(uint64(entry.Height))
+	if entry.Coinbase {
+		w.WriteUint8(1)
+	} else {
+		w.WriteUint8(0)
+	}
+}
+
+func decodeCoin(r *codec.Reader) (OutPoint, Entry, error) {
+	var op OutPoint
+	var entry Entry
+	var err error
+	if op.TxID, err = r.ReadString(); err != nil {
+		return op, entry, err
+	}
+	index, err := r.ReadVarInt()
+	if err != nil {
+		return op, entry, err
+	}
+	op.Index = int(index)
+	data, err := r.ReadBytes()
+	if err != nil {
+		return op, entry, err
+	}
+	if entry.Output, err = transaction.DecodeOutput(data); err != nil {
+		return op, entry, err
+	}
+	height, err := r.ReadVarInt()
+	if err != nil {
+		return op, entry, err
+	}
+	entry.Height = int(height)
+	coinbase, err := r.ReadUint8()
+	entry.Coinbase = coinbase == 1
+	return op, entry, err
+}
+
+func encodeDelta(d delta) []byte {
+	w := codec.NewWriter()
+	w.WriteUint64(d.seq)
+	w.WriteString(d.prevTip)
+	w.WriteString(d.tip)
+	w.WriteInt64(int64(d.height))
+	w.WriteVarInt(uint64(len(d.removed)))
+	for _, op := range d.removed {
+		w.WriteString(op.TxID)
+		w.WriteVarInt(uint64(op.Index))
+	}
+	w.WriteVarInt(uint64(len(d.added)))
+	for op, entry := range d.added {
+		encodeCoin(w, op, entry)
+	}
+	return w.Bytes()
+}
+
+// decodeRecord reads one framed log record and returns its length.
+func decodeRecord(data []byte) (delta, int, error) {
+	var d delta
+	r := codec.NewReader(data)
+	size, err := r.ReadUint32()
+	if err != nil {
+		return d, 0, err
+	}
+	end := 4 + int(size)
+	if int(size) > len(data) || end+4 > len(data) {
+		return d, 0, io.ErrUnexpectedEOF
+	}
+	payload := data[4:end]
+	checksum := sha256.Sum256(payload)
+	if !bytes.Equal(checksum[:4], data[end:end+4]) {
+		return d, 0, ErrCorrupt
+	}
+	r = codec.NewReader(payload)
+	if d.seq, err = r.ReadUint64(); err != nil {
+		return d, 0, err
+	}
+	if d.prevTip, err = r.ReadString(); err != nil {
+		return d, 0, err
+	}
+	if d.tip, err = r.ReadString(); err != nil {
+		return d, 0, err
+	}
+	height, err := r.ReadInt64()
+	if err != nil {
+		return d, 0, err
+	}
+	d.height = int(height)
+	n, err := r.ReadLength()
+	if err != nil {
+		return d, 0, err
+	}
+	for i := 0; i < n; i++ {
+		var op OutPoint
+		if op.TxID, err = r.ReadString(); err != nil {
+			return d, 0, err
+		}
+		index, err := r.ReadVarInt()
+		if err != nil {
+			return d, 0, err
+		}
+		op.Index = int(index)
+		d.removed = append(d.removed, op)
+	}
+	if n, err = r.ReadLength(); err != nil {
+		return d, 0, err
+	}
+	d.added = make(map[OutPoint]Entry, n)
+	for i := 0; i < n; i++ {
+		op, entry, err := decodeCoin(r)
+		if err != nil {
+			return d, 0, err
+		}
+		d.added[op] = entry
+	}
+	return d, end + 4, r.Done()
+}
+
+func encodeUndo(undo Undo) []byte {
+	w := codec.NewWriter()
+	w.WriteUint8(dbVersion)
+	w.WriteString(undo.BlockHash)
+	w.WriteString(undo.PrevHash)
+	w.WriteVarInt(uint64(len(undo.Spent)))
+	for _, so := range undo.Spent {
+		encodeCoin(w, so.OutPoint, so.Entry)
+	}
+	return w.Bytes()
+}
+
+func decodeUndo(data []byte) (Undo, error) {
+	var undo Undo
+	r := codec.NewReader(data)
+	version, err := r.ReadUint8()
+	if err != nil || version != dbVersion {
+		return undo, ErrCorrupt
+	}
+	if undo.BlockHash, err = r.ReadString(); err != nil {
+		return undo, err
+	}
+	if undo.PrevHash, err = r.ReadString(); err != nil {
+		return undo, err
+	}
+	n, err := r.ReadLength()
+	if err != nil {
+		return undo, err
+	}
+	for i := 0; i < n; i++ {
+		op, entry, err := decodeCoin(r)
+		if err != nil {
+			return undo, err
+		}
+		undo.Spent = append(undo.Spent, SpentOutput{OutPoint: op, Entry: entry})
+	}
+	return undo, r.Done()
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename or file creation in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,145 @@
+package utxo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/transaction"
+)
+
+// testChain builds n blocks where each block's coinbase is spent by a
+// transaction in the next one.
+func testChain(n int) []block.Block {
+	var blocks []block.Block
+	prev, prevCoinbase := "", ""
+	for i := 0; i < n; i++ {
+		coinbase := transaction.Transaction{ID: fmt.Sprint("coinbase", i), Outputs: []transaction.Output{{Value: 50}}}
+		txs := []transaction.Transaction{coinbase}
+		if prevCoinbase != "" {
+			txs = append(txs, transaction.Transaction{
+				ID:      fmt.Sprint("spend", i),
+				Inputs:  []transaction.Input{{PrevTxID: prevCoinbase}},
+				Outputs: []transaction.Output{{Value: 50}},
+			})
+		}
+		blk := block.Block{Index: i, PrevHash: prev, Hash: fmt.Sprint("block", i), Transactions: txs}
+		blocks = append(blocks, blk)
+		prev, prevCoinbase = blk.Hash, coinbase.ID
+	}
+	return blocks
+}
+
+func applyAll(t *testing.T, db *DB, blocks []block.Block) {
+	t.Helper()
+	for _, blk := range blocks {
+		if _, err := db.ApplyBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReopen(t *testing.T) {
+	blocks := testChain(5)
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, dir string, db *DB)
+		wantTip int
+	}{
+		{"log only", func(*testing.T, string, *DB) {}, 4},
+		{"compacted", func(t *testing.T, _ string, db *DB) {
+			if err := db.compact(); err != nil {
+				t.Fatal(err)
+			}
+		}, 4},
+		{"crash between snapshot and log truncate", func(t *testing.T, _ string, db *DB) {
+			if err := db.persist(); err != nil {
+				t.Fatal(err)
+			}
+		}, 4},
+		{"torn last record", func(t *testing.T, dir string, _ *DB) {
+			path := filepath.Join(dir, logFileName)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, info.Size()-3); err != nil {
+				t.Fatal(err)
+			}
+		}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applyAll(t, db, blocks)
+			tt.damage(t, dir, db)
+
+			db, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash, height := db.Tip(); hash != blocks[tt.wantTip].Hash || height != tt.wantTip {
+				t.Fatalf("tip %s at %d, want %s", hash, height, blocks[tt.wantTip].Hash)
+			}
+			// The tip's coinbase plus one spend output per block after genesis.
+			if db.Size() != tt.wantTip+1 {
+				t.Fatalf("size %d, want %d", db.Size(), tt.wantTip+1)
+			}
+			// Whatever was lost can be applied again.
+			applyAll(t, db, blocks[tt.wantTip+1:])
+			if db.Size() != len(blocks) {
+				t.Fatalf("size %d after catching up, want %d", db.Size(), len(blocks))
+			}
+		})
+	}
+}
+
+func TestDisconnectBlock(t *testing.T) {
+	blocks := testChain(3)
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyAll(t, db, blocks)
+	if err := db.DisconnectBlock(blocks[1]); err != ErrNotTip {
+		t.Fatalf("disconnecting non-tip: %v, want %v", err, ErrNotTip)
+	}
+	// An undo record for another block under the tip's name is refused.
+	data, err := os.ReadFile(db.undoPath(blocks[1].Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(db.undoPath(blocks[2].Hash), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DisconnectBlock(blocks[2]); err != ErrCorrupt {
+		t.Fatalf("mismatched undo: %v, want %v", err, ErrCorrupt)
+	}
+
+	dir = t.TempDir()
+	if db, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	applyAll(t, db, blocks)
+	for i := len(blocks) - 1; i > 0; i-- {
+		if err := db.DisconnectBlock(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := db.Tip(); hash != blocks[0].Hash || db.Size() != 1 {
+		t.Fatalf("tip %s with %d coins after disconnecting", hash, db.Size())
+	}
+	if _, exists := db.Get(OutPoint{TxID: blocks[0].Transactions[0].ID}); !exists {
+		t.Fatal("coinbase spent by a disconnected block was not restored")
+	}
+}
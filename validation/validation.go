@@ -0,0 +1,281 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+)
+
+const MaxBlockSize = 1 << 20
+const maxFutureBlockTime = 2 * time.Hour
+const medianTimeSpan = 11
+
+// CoinbaseMaturity is how many blocks deep a coinbase must be before its
+// outputs can be spent, so that a reorg can't invalidate their spenders.
+const CoinbaseMaturity = 100
+
+const initialSubsidy = 50
+const halvingInterval = 210000
+
+// BlockSubsidy is the new money a coinbase at height may create on top of
+// the fees of its block.
+func BlockSubsidy(height int) int {
+	halvings := height / halvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return initialSubsidy >> halvings
+}
+
+var (
+	ErrBadProofOfWork    = errors.New("validation: bad proof of work")
+	ErrBadMerkleRoot     = errors.New("validation: bad merkle root")
+	ErrBlockTooLarge     = errors.New("validation: block too large")
+	ErrNoTransactions    = errors.New("validation: block has no transactions")
+	ErrNoCoinbase        = errors.New("validation: first transaction is not a coinbase")
+	ErrMultipleCoinbase  = errors.New("validation: more than one coinbase")
+	ErrDuplicateTx       = errors.New("validation: duplicate transaction")
+	ErrBadOutputValue    = errors.New("validation: output value out of range")
+	ErrTimeTooNew        = errors.New("validation: block timestamp too far in the future")
+	ErrTimeTooOld        = errors.New("validation: block timestamp not after median time past")
+	ErrBadPrevHash       = errors.New("validation: previous block hash mismatch")
+	ErrBadHeight         = errors.New("validation: block height mismatch")
+	ErrBadDifficulty     = errors.New("validation: unexpected difficulty bits")
+	ErrMissingInput      = errors.New("validation: input spends unknown output")
+	ErrDoubleSpend       = errors.New("validation: output spent twice")
+	ErrInsufficientFunds = errors.New("validation: outputs exceed inputs")
+	ErrBadSignature      = errors.New("validation: input signature invalid")
+	ErrNonFinalTx        = errors.New("validation: transaction lock time not reached")
+	ErrBadTxID           = errors.New("validation: transaction ID does not match its contents")
+	ErrBadCoinbaseValue  = errors.New("validation: coinbase pays more than subsidy and fees")
+	ErrBadCoinbaseHeight = errors.New("validation: coinbase lock time does not commit to block height")
+	ErrOutputExists      = errors.New("validation: transaction overwrites an unspent output")
+	ErrImmatureSpend     = errors.New("validation: coinbase output spent before maturity")
+)
+
+// IsRuleError reports whether err breaks a consensus rule, as opposed to a
+// local failure such as a storage error. A block too far in the future
+// does not count since it may become valid later.
+func IsRuleError(err error) bool {
+	for _, rule := range []error{
+		ErrBadProofOfWork, ErrBadMerkleRoot, ErrBlockTooLarge, ErrNoTransactions,
+		ErrNoCoinbase, ErrMultipleCoinbase, ErrDuplicateTx, ErrBadOutputValue,
+		ErrTimeTooOld, ErrBadPrevHash, ErrBadHeight, ErrBadDifficulty,
+		ErrMissingInput, ErrDoubleSpend, ErrInsufficientFunds, ErrBadSignature,
+		ErrNonFinalTx, ErrBadTxID, ErrBadCoinbaseValue, ErrBadCoinbaseHeight,
+		ErrOutputExists, ErrImmatureSpend,
+	} {
+		if errors.Is(err, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderChain gives access to the headers of the branch a block extends.
+type HeaderChain interface {
+	Ancestor(height int) (block.Block, bool)
+}
+
+type UTXOView interface {
+	Get(op utxo.OutPoint) (utxo.Entry, bool)
+}
+
+// ChainSlice adapts a plain slice of blocks ordered by height.
+type ChainSlice []block.Block
+
+func (c ChainSlice) Ancestor(height int) (block.Block, bool) {
+	if height < 0 || height >= len(c) {
+		return block.Block{}, false
+	}
+	return c[height], true
+}
+
+func ruleError(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+func CheckBlockHeader(header block.Block, now time.Time) error {
+	if err := concensus.CheckProofOfWork(header); err != nil {
+		return ruleError(ErrBadProofOfWork, "%v", err)
+	}
+	if header.Timestamp > now.Add(maxFutureBlockTime).Unix() {
+		return ruleError(ErrTimeTooNew, "timestamp %d", header.Timestamp)
+	}
+	return nil
+}
+
+// CheckBlock runs every rule that needs nothing but the block itself.
+func CheckBlock(blk block.Block, now time.Time) error {
+	if err := CheckBlockHeader(blk, now); err != nil {
+		return err
+	}
+	if len(blk.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+	if size := len(block.Encode(blk)); size > MaxBlockSize {
+		return ruleError(ErrBlockTooLarge, "%d bytes", size)
+	}
+	if root := block.CalculateMerkleRoot(blk.Transactions); root != blk.MerkleRoot {
+		return ruleError(ErrBadMerkleRoot, "got %s, header has %s", root, blk.MerkleRoot)
+	}
+	if !IsCoinbase(blk.Transactions[0]) {
+		return ErrNoCoinbase
+	}
+	if lockTime := blk.Transactions[0].LockTime; blk.Index > 0 && lockTime != CoinbaseLockTime(blk.Index) {
+		return ruleError(ErrBadCoinbaseHeight, "lock time %d at height %d", lockTime, blk.Index)
+	}
+	seen := make(map[string]bool)
+	for i, tx := range blk.Transactions {
+		if i > 0 && IsCoinbase(tx) {
+			return ruleError(ErrMultipleCoinbase, "transaction %d", i)
+		}
+		if seen[tx.ID] {
+			return ruleError(ErrDuplicateTx, "%s", tx.ID)
+		}
+		seen[tx.ID] = true
+		if err := CheckTransaction(tx); err != nil {
+			return err
+		}
+		if !transaction.IsFinal(tx, blk.Index, blk.Timestamp) {
+			return ruleError(ErrNonFinalTx, "%s: lock time %d", tx.ID, tx.LockTime)
+		}
+	}
+	return nil
+}
+
+// CheckTransaction runs the rules that need nothing but tx itself: its ID
+// must hash its contents and its outputs must stay within MaxMoney.
+func CheckTransaction(tx transaction.Transaction) error {
+	if id := transaction.CalculateID(tx); id != tx.ID {
+		return ruleError(ErrBadTxID, "got %s, contents hash to %s", tx.ID, id)
+	}
+	total := 0
+	for _, output := range tx.Outputs {
+		if !transaction.MoneyRange(output.Value) {
+			return ruleError(ErrBadOutputValue, "%s: %d", tx.ID, output.Value)
+		}
+		total += output.Value
+		if !transaction.MoneyRange(total) {
+			return ruleError(ErrBadOutputValue, "%s: outputs total more than %d", tx.ID, transaction.MaxMoney)
+		}
+	}
+	return nil
+}
+
+// CheckBlockHeaderContext checks header against parent, the tip of the
+// branch it extends. Genesis has no parent and is not checked here.
+func CheckBlockHeaderContext(header, parent block.Block, chain HeaderChain) error {
+	if parent.Hash != header.PrevHash {
+		return ruleError(ErrBadPrevHash, "got %s, want %s", header.PrevHash, parent.Hash)
+	}
+	if header.Index != parent.Index+1 {
+		return ruleError(ErrBadHeight, "got %d, want %d", header.Index, parent.Index+1)
+	}
+	if bits := concensus.NextBits(parent, chain.Ancestor); header.Bits != bits {
+		return ruleError(ErrBadDifficulty, "got %08x, want %08x", header.Bits, bits)
+	}
+	if mtp := MedianTimePast(parent, chain); header.Timestamp <= mtp {
+		return ruleError(ErrTimeTooOld, "timestamp %d, median %d", header.Timestamp, mtp)
+	}
+	return nil
+}
+
+// CheckTransactionInputs checks every spend in blk against view, which must
+// reflect the chain state right before blk, and that the coinbase claims no
+// more than the subsidy plus the fees. No output may replace one that is
+// still unspent and coinbase outputs may only be spent once mature. Output
+// sums are already bounded by CheckBlock.
+func CheckTransactionInputs(blk block.Block, view UTXOView) error {
+	created := make(map[utxo.OutPoint]utxo.Entry)
+	spent := make(map[utxo.OutPoint]bool)
+	fees := 0
+	for _, tx := range blk.Transactions {
+		inputSum := 0
+		for i, input := range tx.Inputs {
+			op := utxo.OutPoint{TxID: input.PrevTxID, Index: input.OutputIndex}
+			if spent[op] {
+				return ruleError(ErrDoubleSpend, "%s:%d", op.TxID, op.Index)
+			}
+			entry, exists := created[op]
+			if !exists {
+				if entry, exists = view.Get(op); !exists {
+					return ruleError(ErrMissingInput, "%s:%d", op.TxID, op.Index)
+				}
+			}
+			if !IsMature(entry, blk.Index) {
+				return ruleError(ErrImmatureSpend, "%s:%d from height %d at height %d", op.TxID, op.Index, entry.Height, blk.Index)
+			}
+			if err := transaction.VerifyInput(tx, i, entry.Output); err != nil {
+				return ruleError(ErrBadSignature, "%s input %d: %v", tx.ID, i, err)
+			}
+			spent[op] = true
+			inputSum += entry.Output.Value
+			if !transaction.MoneyRange(entry.Output.Value) || !transaction.MoneyRange(inputSum) {
+				return ruleError(ErrBadOutputValue, "%s: inputs total more than %d", tx.ID, transaction.MaxMoney)
+			}
+		}
+		outputSum := 0
+		for index, output := range tx.Outputs {
+			op := utxo.OutPoint{TxID: tx.ID, Index: index}
+			if _, exists := view.Get(op); exists {
+				return ruleError(ErrOutputExists, "%s:%d", op.TxID, op.Index)
+			}
+			outputSum += output.Value
+			created[op] = utxo.Entry{Output: output, Height: blk.Index, Coinbase: IsCoinbase(tx)}
+		}
+		if IsCoinbase(tx) {
+			continue
+		}
+		if outputSum > inputSum {
+			return ruleError(ErrInsufficientFunds, "%s: in %d, out %d", tx.ID, inputSum, outputSum)
+		}
+		fees += inputSum - outputSum
+		if !transaction.MoneyRange(fees) {
+			return ruleError(ErrBadOutputValue, "fees total more than %d", transaction.MaxMoney)
+		}
+	}
+	coinbaseValue := 0
+	for _, output := range blk.Transactions[0].Outputs {
+		coinbaseValue += output.Value
+	}
+	if limit := BlockSubsidy(blk.Index) + fees; coinbaseValue > limit {
+		return ruleError(ErrBadCoinbaseValue, "pays %d, limit %d", coinbaseValue, limit)
+	}
+	return nil
+}
+
+func MedianTimePast(parent block.Block, chain HeaderChain) int64 {
+	timestamps := []int64{parent.Timestamp}
+	for h := parent.Index - 1; h >= 0 && len(timestamps) < medianTimeSpan; h-- {
+		header, ok := chain.Ancestor(h)
+		if !ok {
+			break
+		}
+		timestamps = append(timestamps, header.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// CoinbaseLockTime is the lock time a coinbase at height must carry. With
+// no inputs to tell them apart, it keeps coinbase IDs unique across blocks;
+// height-1 is always final at height.
+func CoinbaseLockTime(height int) int64 {
+	return int64(height - 1)
+}
+
+// IsMature reports whether entry may be spent by a block at height.
+func IsMature(entry utxo.Entry, height int) bool {
+	return !entry.Coinbase || height-entry.Height >= CoinbaseMaturity
+}
+
+func IsCoinbase(tx transaction.Transaction) bool {
+	return len(tx.Inputs) == 0
+}
@@ -0,0 +1,225 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/consensus"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+)
+
+const testEpoch = 1700000000
+
+type testView map[utxo.OutPoint]utxo.Entry
+
+func (v testView) Get(op utxo.OutPoint) (utxo.Entry, bool) {
+	entry, ok := v[op]
+	return entry, ok
+}
+
+func withID(tx transaction.Transaction) transaction.Transaction {
+	tx.ID = transaction.CalculateID(tx)
+	return tx
+}
+
+func coinbaseTx(height, value int) transaction.Transaction {
+	return withID(transaction.Transaction{
+		Inputs:   []transaction.Input{},
+		Outputs:  []transaction.Output{{Value: value}},
+		LockTime: int64(height - 1),
+	})
+}
+
+// testChain solves n blocks a minute apart starting at genesis.
+func testChain(n int) ChainSlice {
+	var chain ChainSlice
+	prev := "0"
+	for i := 0; i < n; i++ {
+		blk := block.Block{
+			Index:        i,
+			PrevHash:     prev,
+			Timestamp:    testEpoch + int64(60*i),
+			Transactions: []transaction.Transaction{coinbaseTx(i, 0)},
+			Bits:         concensus.PowLimitBits,
+		}
+		blk.MerkleRoot = block.CalculateMerkleRoot(blk.Transactions)
+		blk = concensus.SolveBlock(blk)
+		chain = append(chain, blk)
+		prev = blk.Hash
+	}
+	return chain
+}
+
+func TestCheckBlockHeaderContext(t *testing.T) {
+	chain := testChain(4)
+	parent := chain[3]
+	next := func(mutate func(h *block.Block)) block.Block {
+		header := block.Block{Index: parent.Index + 1, PrevHash: parent.Hash, Timestamp: parent.Timestamp + 60, Bits: parent.Bits}
+		mutate(&header)
+		return header
+	}
+	tests := []struct {
+		name   string
+		header block.Block
+		want   error
+	}{
+		{"extends parent", next(func(*block.Block) {}), nil},
+		{"height zero on a parent", next(func(h *block.Block) { h.Index, h.Timestamp = 0, 1 }), ErrBadHeight},
+		{"skips a height", next(func(h *block.Block) { h.Index++ }), ErrBadHeight},
+		{"wrong parent", next(func(h *block.Block) { h.PrevHash = chain[2].Hash }), ErrBadPrevHash},
+		{"difficulty changed", next(func(h *block.Block) { h.Bits = 0x1d00ffff }), ErrBadDifficulty},
+		{"not after median time past", next(func(h *block.Block) { h.Timestamp = chain[2].Timestamp }), ErrTimeTooOld},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckBlockHeaderContext(tt.header, parent, chain); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckBlock(t *testing.T) {
+	now := time.Unix(testEpoch, 0)
+	coinbase := coinbaseTx(1, BlockSubsidy(1))
+	spend := withID(transaction.Transaction{
+		Inputs:  []transaction.Input{{PrevTxID: "funding"}},
+		Outputs: []transaction.Output{{Value: 1}},
+	})
+	spendWith := func(mutate func(tx *transaction.Transaction)) transaction.Transaction {
+		tx := spend
+		tx.Outputs = append([]transaction.Output(nil), spend.Outputs...)
+		mutate(&tx)
+		return withID(tx)
+	}
+	build := func(txs []transaction.Transaction, mutate func(blk *block.Block)) block.Block {
+		blk := block.Block{Index: 1, PrevHash: "0", Timestamp: testEpoch, Transactions: txs, Bits: concensus.PowLimitBits}
+		blk.MerkleRoot = block.CalculateMerkleRoot(txs)
+		mutate(&blk)
+		return concensus.SolveBlock(blk)
+	}
+	nop := func(*block.Block) {}
+	tampered := build([]transaction.Transaction{coinbase, spend}, nop)
+	tampered.Nonce++
+	badID := spend
+	badID.ID = "not-the-hash"
+
+	tests := []struct {
+		name string
+		blk  block.Block
+		want error
+	}{
+		{"valid", build([]transaction.Transaction{coinbase, spend}, nop), nil},
+		{"hash does not match header", tampered, ErrBadProofOfWork},
+		{"target above limit", build([]transaction.Transaction{coinbase}, func(b *block.Block) { b.Bits = 0x2100ffff }), ErrBadProofOfWork},
+		{"too far in the future", build([]transaction.Transaction{coinbase}, func(b *block.Block) { b.Timestamp += 3 * 60 * 60 }), ErrTimeTooNew},
+		{"no transactions", build(nil, nop), ErrNoTransactions},
+		{"too large", build([]transaction.Transaction{coinbase, spendWith(func(tx *transaction.Transaction) {
+			tx.Outputs[0].ScriptPubKey = strings.Repeat("00", MaxBlockSize/2)
+		})}, nop), ErrBlockTooLarge},
+		{"bad merkle root", build([]transaction.Transaction{coinbase}, func(b *block.Block) { b.MerkleRoot = "00" }), ErrBadMerkleRoot},
+		{"first is not a coinbase", build([]transaction.Transaction{spend}, nop), ErrNoCoinbase},
+		{"coinbase lock time not height-1", build([]transaction.Transaction{withID(transaction.Transaction{
+			Inputs: []transaction.Input{}, Outputs: coinbase.Outputs, LockTime: 5,
+		})}, nop), ErrBadCoinbaseHeight},
+		{"second coinbase", build([]transaction.Transaction{coinbase, coinbaseTx(2, 0)}, nop), ErrMultipleCoinbase},
+		{"duplicate transaction", build([]transaction.Transaction{coinbase, spend, spend}, nop), ErrDuplicateTx},
+		{"negative output", build([]transaction.Transaction{coinbase, spendWith(func(tx *transaction.Transaction) {
+			tx.Outputs[0].Value = -1
+		})}, nop), ErrBadOutputValue},
+		{"outputs overflow MaxMoney", build([]transaction.Transaction{coinbase, spendWith(func(tx *transaction.Transaction) {
+			tx.Outputs = []transaction.Output{{Value: transaction.MaxMoney}, {Value: 1}}
+		})}, nop), ErrBadOutputValue},
+		{"ID does not match contents", build([]transaction.Transaction{coinbase, badID}, nop), ErrBadTxID},
+		{"lock time not reached", build([]transaction.Transaction{coinbase, spendWith(func(tx *transaction.Transaction) {
+			tx.LockTime = 1
+		})}, nop), ErrNonFinalTx},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBlock(tt.blk, now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want != nil && tt.want != ErrTimeTooNew && !IsRuleError(err) {
+				t.Fatalf("%v is not a rule error", err)
+			}
+		})
+	}
+}
+
+func TestCheckTransactionInputs(t *testing.T) {
+	priv, pub, err := transaction.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding := transaction.Output{Value: 30, ScriptPubKey: transaction.PayToPubKeyHash(pub)}
+	huge := transaction.Output{Value: transaction.MaxMoney + 1, ScriptPubKey: funding.ScriptPubKey}
+	// Blocks are checked at the first height where a coinbase can mature.
+	const height = CoinbaseMaturity + 1
+	subsidy := BlockSubsidy(height)
+	unspent := coinbaseTx(height, subsidy-1)
+	view := testView{
+		{TxID: "funding"}:  {Output: funding},
+		{TxID: "huge"}:     {Output: huge},
+		{TxID: "mature"}:   {Output: funding, Height: 1, Coinbase: true},
+		{TxID: "immature"}: {Output: funding, Height: 2, Coinbase: true},
+		{TxID: unspent.ID}: {Output: unspent.Outputs[0], Height: 1},
+	}
+	// pay spends ops, all locked to pub, and signs every input.
+	pay := func(ops []utxo.OutPoint, prevOuts []transaction.Output, values ...int) transaction.Transaction {
+		tx := transaction.Transaction{}
+		for _, op := range ops {
+			tx.Inputs = append(tx.Inputs, transaction.Input{PrevTxID: op.TxID, OutputIndex: op.Index})
+		}
+		for _, value := range values {
+			tx.Outputs = append(tx.Outputs, transaction.Output{Value: value, ScriptPubKey: funding.ScriptPubKey})
+		}
+		for i := range ops {
+			if err := transaction.SignInput(&tx, i, priv, prevOuts[i], transaction.SigHashAll); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tx
+	}
+	fromFunding := func(values ...int) transaction.Transaction {
+		return pay([]utxo.OutPoint{{TxID: "funding"}}, []transaction.Output{funding}, values...)
+	}
+	spend := fromFunding(20)
+	child := pay([]utxo.OutPoint{{TxID: spend.ID}}, spend.Outputs, 15)
+	forged := spend
+	forged.Outputs = []transaction.Output{{Value: 29, ScriptPubKey: funding.ScriptPubKey}}
+	forged = withID(forged)
+	coinbase := coinbaseTx(height, subsidy)
+
+	tests := []struct {
+		name string
+		txs  []transaction.Transaction
+		want error
+	}{
+		{"valid", []transaction.Transaction{coinbaseTx(height, subsidy+10), spend}, nil},
+		{"spends output created earlier in block", []transaction.Transaction{coinbaseTx(height, subsidy+15), spend, child}, nil},
+		{"unknown output", []transaction.Transaction{coinbaseTx(height, subsidy), pay([]utxo.OutPoint{{TxID: "unknown"}}, []transaction.Output{funding}, 1)}, ErrMissingInput},
+		{"output spent twice", []transaction.Transaction{coinbaseTx(height, subsidy), spend, fromFunding(19)}, ErrDoubleSpend},
+		{"outputs exceed inputs", []transaction.Transaction{coinbaseTx(height, subsidy), fromFunding(31)}, ErrInsufficientFunds},
+		{"signature does not cover outputs", []transaction.Transaction{coinbaseTx(height, subsidy), forged}, ErrBadSignature},
+		{"input value out of range", []transaction.Transaction{coinbaseTx(height, subsidy), pay([]utxo.OutPoint{{TxID: "huge"}}, []transaction.Output{huge}, 1)}, ErrBadOutputValue},
+		{"coinbase claims more than subsidy and fees", []transaction.Transaction{coinbaseTx(height, subsidy+11), spend}, ErrBadCoinbaseValue},
+		{"mature coinbase", []transaction.Transaction{coinbase, pay([]utxo.OutPoint{{TxID: "mature"}}, []transaction.Output{funding}, 30)}, nil},
+		{"immature coinbase", []transaction.Transaction{coinbase, pay([]utxo.OutPoint{{TxID: "immature"}}, []transaction.Output{funding}, 30)}, ErrImmatureSpend},
+		{"coinbase spent in its own block", []transaction.Transaction{coinbase, pay([]utxo.OutPoint{{TxID: coinbase.ID}}, coinbase.Outputs, 1)}, ErrImmatureSpend},
+		{"overwrites unspent output", []transaction.Transaction{unspent}, ErrOutputExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blk := block.Block{Index: height, Transactions: tt.txs}
+			if err := CheckTransactionInputs(blk, view); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
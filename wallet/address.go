@@ -0,0 +1,28 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"blockchain-hello-golang/crypto"
+	"blockchain-hello-golang/script"
+)
+
+// addressVersion prefixes the key hash in an address. It is nonzero so the
+// version byte survives base58 encoding.
+const addressVersion = 0x6f
+
+var ErrBadAddress = errors.New("wallet: invalid address")
+
+func encodeAddress(pubKeyHash []byte) string {
+	return crypto.EncodeBase58Check(append([]byte{addressVersion}, pubKeyHash...))
+}
+
+// DecodeAddress returns the hex ScriptPubKey that pays to addr.
+func DecodeAddress(addr string) (string, error) {
+	data, err := crypto.DecodeBase58Check(addr)
+	if err != nil || len(data) != 21 || data[0] != addressVersion {
+		return "", ErrBadAddress
+	}
+	return hex.EncodeToString(script.PayToPubKeyHash(data[1:])), nil
+}
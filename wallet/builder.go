@@ -0,0 +1,242 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+
+	"blockchain-hello-golang/script"
+	"blockchain-hello-golang/transaction"
+)
+
+// DefaultFeeRate is the fee per 1000 bytes paid when none is given and
+// there is no estimate.
+const DefaultFeeRate = 1
+
+// DefaultConfTarget is how many blocks a transaction should take to confirm
+// when its fee rate comes from the estimator.
+const DefaultConfTarget = 6
+
+// Signatures and uncompressed public keys have fixed sizes, so the size of
+// a signed transaction is known before signing.
+const (
+	signatureSize = 65
+	publicKeySize = 65
+)
+
+var ErrNoRecipients = errors.New("wallet: transaction has no recipients")
+var ErrBadAmount = errors.New("wallet: amount must be positive and at most MaxMoney")
+
+type Recipient struct {
+	Address string
+	Value   int
+}
+
+// FeeEstimator suggests the fee rate per 1000 bytes that gets a
+// transaction confirmed within target blocks.
+type FeeEstimator interface {
+	EstimateFee(target int) (int, error)
+}
+
+type TxOptions struct {
+	Selection CoinSelection
+	// FeeRate is the fee per 1000 bytes. Zero asks the fee estimator for a
+	// rate that confirms within ConfTarget blocks, or DefaultConfTarget if
+	// that is zero too, and falls back to DefaultFeeRate.
+	FeeRate    int
+	ConfTarget int
+}
+
+// Spend is a signed transaction ready to broadcast.
+type Spend struct {
+	Tx      transaction.Transaction
+	Fee     int
+	FeeRate int
+	Inputs  []Coin
+	// Change is the index of the change output, or -1 without one.
+	Change int
+}
+
+// CreateTransaction pays recipients from coins, which are normally the
+// wallet's coins not already spent by pending transactions. It selects
+// inputs with opts.Selection, sends any worthwhile excess to the next change
+// address at a random position and signs every input, so the wallet must
+// be unlocked. The change address is only handed out by CommitSpend.
+func (w *Wallet) CreateTransaction(coins []Coin, recipients []Recipient, opts TxOptions) (*Spend, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	feeRate := opts.FeeRate
+	if feeRate == 0 {
+		feeRate = w.estimateFee(opts.ConfTarget)
+	}
+	var outputs []transaction.Output
+	paid := 0
+	for _, r := range recipients {
+		if r.Value <= 0 || !transaction.MoneyRange(r.Value) {
+			return nil, ErrBadAmount
+		}
+		scriptPubKey, err := DecodeAddress(r.Address)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, transaction.Output{Value: r.Value, ScriptPubKey: scriptPubKey})
+		paid += r.Value
+		if !transaction.MoneyRange(paid) {
+			return nil, ErrBadAmount
+		}
+	}
+	if w.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	changeOut := transaction.Output{ScriptPubKey: hex.EncodeToString(script.PayToPubKeyHash(make([]byte, 20)))}
+	costs := selectionCosts{
+		target:     paid + fee(feeRate, txSize(nil, outputs)),
+		changeCost: fee(feeRate, outputSize(changeOut)) + fee(feeRate, inputSize(Coin{})),
+		inputFee:   func(c Coin) int { return fee(feeRate, inputSize(c)) },
+	}
+	// The per-part sizes can be a byte or two short of the whole when a
+	// count needs a longer varint, so check the real fee and retry.
+	for {
+		selected, err := selectCoins(opts.Selection, coins, costs)
+		if err != nil {
+			return nil, err
+		}
+		total := 0
+		for _, c := range selected {
+			total += c.Output.Value
+		}
+		required := fee(feeRate, txSize(selected, outputs))
+		if short := paid + required - total; short > 0 {
+			costs.target += short
+			continue
+		}
+		spendOutputs := outputs
+		change, changeValue := -1, 0
+		withChange := append(append([]transaction.Output{}, outputs...), changeOut)
+		changeFee := fee(feeRate, txSize(selected, withChange))
+		if total-paid-required > costs.changeCost && total-paid-changeFee > 0 {
+			addr := w.peek(ChangeChain)
+			if change, err = randomIndex(len(outputs) + 1); err != nil {
+				return nil, err
+			}
+			changeValue = total - paid - changeFee
+			spendOutputs = make([]transaction.Output, 0, len(outputs)+1)
+			spendOutputs = append(spendOutputs, outputs[:change]...)
+			spendOutputs = append(spendOutputs, transaction.Output{Value: changeValue, ScriptPubKey: addr.ScriptPubKey})
+			spendOutputs = append(spendOutputs, outputs[change:]...)
+		}
+		tx, err := w.sign(selected, spendOutputs)
+		if err != nil {
+			return nil, err
+		}
+		return &Spend{Tx: tx, Fee: total - paid - changeValue, FeeRate: feeRate, Inputs: selected, Change: change}, nil
+	}
+}
+
+// CommitSpend hands out the change address spend pays to. Call it once the
+// transaction has been accepted, so that failed attempts do not use up
+// change addresses.
+func (w *Wallet) CommitSpend(spend *Spend) {
+	if spend.Change < 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, ok := w.byScript[spend.Tx.Outputs[spend.Change].ScriptPubKey]
+	if !ok {
+		return
+	}
+	chain := w.chains[a.Chain]
+	if chain.issued <= int(a.Index) {
+		chain.issued = int(a.Index) + 1
+		w.extend(chain)
+	}
+}
+
+// SetFeeEstimator makes transactions without an explicit fee rate pay what
+// e suggests.
+func (w *Wallet) SetFeeEstimator(e FeeEstimator) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fees = e
+}
+
+func (w *Wallet) estimateFee(target int) int {
+	w.mu.Lock()
+	e := w.fees
+	w.mu.Unlock()
+	if target == 0 {
+		target = DefaultConfTarget
+	}
+	if e != nil {
+		if rate, err := e.EstimateFee(target); err == nil && rate > 0 {
+			return rate
+		}
+	}
+	return DefaultFeeRate
+}
+
+func (w *Wallet) sign(coins []Coin, outputs []transaction.Output) (transaction.Transaction, error) {
+	tx := transaction.Transaction{Outputs: outputs}
+	for _, c := range coins {
+		tx.Inputs = append(tx.Inputs, transaction.Input{PrevTxID: c.OutPoint.TxID, OutputIndex: c.OutPoint.Index})
+	}
+	for i, c := range coins {
+		key, err := w.PrivateKey(c.Output.ScriptPubKey)
+		if err != nil {
+			return tx, err
+		}
+		if err := transaction.SignInput(&tx, i, key, c.Output, transaction.SigHashAll); err != nil {
+			return tx, err
+		}
+	}
+	return tx, nil
+}
+
+// fee is what size bytes cost at rate per 1000 bytes, rounded up.
+func fee(rate, size int) int {
+	return (rate*size + 999) / 1000
+}
+
+// txSize is the encoded size of a transaction spending coins to outputs
+// once signed.
+func txSize(coins []Coin, outputs []transaction.Output) int {
+	tx := transaction.Transaction{ID: strings.Repeat("0", 64), Outputs: outputs}
+	for _, c := range coins {
+		tx.Inputs = append(tx.Inputs, signedInput(c))
+	}
+	return len(transaction.Encode(tx))
+}
+
+func inputSize(c Coin) int {
+	return len(transaction.EncodeInput(signedInput(c))) - 1
+}
+
+func outputSize(out transaction.Output) int {
+	return len(transaction.EncodeOutput(out)) - 1
+}
+
+func signedInput(c Coin) transaction.Input {
+	txID := c.OutPoint.TxID
+	if txID == "" {
+		txID = strings.Repeat("0", 64)
+	}
+	return transaction.Input{
+		PrevTxID:    txID,
+		OutputIndex: c.OutPoint.Index,
+		Signature:   make([]byte, signatureSize),
+		PubKey:      make([]byte, publicKeySize),
+	}
+}
+
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
@@ -0,0 +1,66 @@
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+)
+
+func newTestWallet(t *testing.T) *Wallet {
+	t.Helper()
+	w, _, err := Create(filepath.Join(t.TempDir(), "wallet.dat"), DefaultAccountPath, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Unlock("secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestCreateTransaction(t *testing.T) {
+	w := newTestWallet(t)
+	to := w.NewAddress()
+	coins := []Coin{{
+		OutPoint: utxo.OutPoint{TxID: "funding", Index: 0},
+		Output:   transaction.Output{Value: 1000000, ScriptPubKey: w.NewAddress().ScriptPubKey},
+	}}
+	tests := []struct {
+		name       string
+		recipients []Recipient
+		wantErr    error
+	}{
+		{"no recipients", nil, ErrNoRecipients},
+		{"zero", []Recipient{{to.Address, 0}}, ErrBadAmount},
+		{"over MaxMoney", []Recipient{{to.Address, transaction.MaxMoney + 1}}, ErrBadAmount},
+		{"total over MaxMoney", []Recipient{{to.Address, transaction.MaxMoney}, {to.Address, 1}}, ErrBadAmount},
+		{"insufficient funds", []Recipient{{to.Address, transaction.MaxMoney}}, ErrInsufficientFunds},
+		{"with change", []Recipient{{to.Address, 1000}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spend, err := w.CreateTransaction(coins, tt.recipients, TxOptions{FeeRate: 1})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if n := len(w.Addresses(ChangeChain)); n != 0 {
+				t.Fatalf("%d change addresses handed out before CommitSpend", n)
+			}
+			if err != nil {
+				return
+			}
+			if spend.Change < 0 {
+				t.Fatal("no change output")
+			}
+			w.CommitSpend(spend)
+			change := w.Addresses(ChangeChain)
+			if len(change) != 1 || change[0].ScriptPubKey != spend.Tx.Outputs[spend.Change].ScriptPubKey {
+				t.Fatalf("change addresses %v after CommitSpend", change)
+			}
+		})
+	}
+}
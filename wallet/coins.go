@@ -0,0 +1,86 @@
+package wallet
+
+import (
+	"sort"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+	"blockchain-hello-golang/validation"
+)
+
+// Coin is an unspent output paying to the wallet.
+type Coin struct {
+	OutPoint utxo.OutPoint
+	Output   transaction.Output
+	Height   int
+	Coinbase bool
+}
+
+// Mature reports whether c may be spent by a block at height.
+func (c Coin) Mature(height int) bool {
+	return validation.IsMature(utxo.Entry{Height: c.Height, Coinbase: c.Coinbase}, height)
+}
+
+// UTXOView is the chain's unspent outputs, as seen after a block has been
+// disconnected.
+type UTXOView interface {
+	Get(op utxo.OutPoint) (utxo.Entry, bool)
+}
+
+// DisconnectBlock undoes ScanBlock for a block leaving the main chain:
+// coins it created are forgotten and the wallet's coins it spent, which
+// view has again, come back.
+func (w *Wallet) DisconnectBlock(blk block.Block, view UTXOView) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		for n := range tx.Outputs {
+			delete(w.coins, utxo.OutPoint{TxID: tx.ID, Index: n})
+		}
+		for _, in := range tx.Inputs {
+			op := utxo.OutPoint{TxID: in.PrevTxID, Index: in.OutputIndex}
+			entry, ok := view.Get(op)
+			if !ok {
+				continue
+			}
+			if _, mine := w.byScript[entry.Output.ScriptPubKey]; mine {
+				w.coins[op] = Coin{OutPoint: op, Output: entry.Output, Height: entry.Height, Coinbase: entry.Coinbase}
+			}
+		}
+	}
+}
+
+// Coins lists the wallet's confirmed coins, oldest first.
+func (w *Wallet) Coins() []Coin {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sortedCoins()
+}
+
+// Balance is the value of the wallet's confirmed coins.
+func (w *Wallet) Balance() int {
+	total := 0
+	for _, coin := range w.Coins() {
+		total += coin.Output.Value
+	}
+	return total
+}
+
+func (w *Wallet) sortedCoins() []Coin {
+	coins := make([]Coin, 0, len(w.coins))
+	for _, coin := range w.coins {
+		coins = append(coins, coin)
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		a, b := coins[i], coins[j]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if a.OutPoint.TxID != b.OutPoint.TxID {
+			return a.OutPoint.TxID < b.OutPoint.TxID
+		}
+		return a.OutPoint.Index < b.OutPoint.Index
+	})
+	return coins
+}
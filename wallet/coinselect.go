@@ -0,0 +1,175 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CoinSelection names a strategy for choosing the coins a transaction
+// spends.
+type CoinSelection string
+
+const (
+	// SelectLargestFirst spends the biggest coins first, which keeps
+	// transactions small but tends to produce change.
+	SelectLargestFirst CoinSelection = "largest-first"
+	// SelectBranchAndBound searches for a set of coins that pays the target
+	// closely enough to need no change output, and falls back to
+	// largest-first when there is none.
+	SelectBranchAndBound CoinSelection = "branch-and-bound"
+	// SelectPrivacy spends whole addresses at a time and merges as few
+	// addresses as it can, so fewer of them are linked on chain.
+	SelectPrivacy CoinSelection = "privacy"
+)
+
+// bnbMaxTries bounds the branch-and-bound search like bitcoind does.
+const bnbMaxTries = 100000
+
+var ErrInsufficientFunds = errors.New("wallet: insufficient funds")
+var ErrUnknownSelection = errors.New("wallet: unknown coin selection strategy")
+
+// selectionCosts is what a strategy needs to know to judge a set of coins.
+type selectionCosts struct {
+	// target is what the inputs must cover after their own fees: the
+	// recipients plus the fee for the rest of the transaction.
+	target int
+	// changeCost is the fee for a change output plus the fee to spend it
+	// later; an excess below it is better left to the miner.
+	changeCost int
+	inputFee   func(Coin) int
+}
+
+func (c selectionCosts) effective(coin Coin) int {
+	return coin.Output.Value - c.inputFee(coin)
+}
+
+func selectCoins(strategy CoinSelection, coins []Coin, costs selectionCosts) ([]Coin, error) {
+	// Coins worth less than the fee to spend them only make things worse.
+	var usable []Coin
+	for _, coin := range coins {
+		if costs.effective(coin) > 0 {
+			usable = append(usable, coin)
+		}
+	}
+	sort.SliceStable(usable, func(i, j int) bool { return costs.effective(usable[i]) > costs.effective(usable[j]) })
+
+	switch strategy {
+	case SelectLargestFirst:
+		return largestFirst(usable, costs)
+	case SelectBranchAndBound, "":
+		if selected, ok := branchAndBound(usable, costs); ok {
+			return selected, nil
+		}
+		return largestFirst(usable, costs)
+	case SelectPrivacy:
+		return privacyFirst(usable, costs)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownSelection, strategy)
+}
+
+// largestFirst takes coins in descending order of effective value until
+// the target is met. coins must already be sorted that way.
+func largestFirst(coins []Coin, costs selectionCosts) ([]Coin, error) {
+	total := 0
+	for i, coin := range coins {
+		total += costs.effective(coin)
+		if total >= costs.target {
+			return coins[:i+1], nil
+		}
+	}
+	return nil, ErrInsufficientFunds
+}
+
+// branchAndBound does a depth-first search over including or excluding
+// each coin, largest first, for a set whose effective value lands within
+// changeCost above the target, and keeps the one that wastes least.
+func branchAndBound(coins []Coin, costs selectionCosts) ([]Coin, bool) {
+	values := make([]int, len(coins))
+	remaining := make([]int, len(coins)+1)
+	for i := len(coins) - 1; i >= 0; i-- {
+		values[i] = costs.effective(coins[i])
+		remaining[i] = remaining[i+1] + values[i]
+	}
+	upper := costs.target + costs.changeCost
+
+	var best []int
+	bestWaste := -1
+	var current []int
+	tries := 0
+	var search func(i, total int)
+	search = func(i, total int) {
+		tries++
+		if tries > bnbMaxTries || total > upper || total+remaining[i] < costs.target {
+			return
+		}
+		if total >= costs.target {
+			if waste := total - costs.target; bestWaste < 0 || waste < bestWaste {
+				best = append(best[:0], current...)
+				bestWaste = waste
+			}
+			return
+		}
+		if i == len(coins) {
+			return
+		}
+		current = append(current, i)
+		search(i+1, total+values[i])
+		current = current[:len(current)-1]
+		// Having excluded this coin, taking an equal one instead would only
+		// repeat sets the include branch already tried.
+		j := i + 1
+		for j < len(coins) && values[j] == values[i] {
+			j++
+		}
+		search(j, total)
+	}
+	search(0, 0)
+	if bestWaste < 0 {
+		return nil, false
+	}
+	selected := make([]Coin, len(best))
+	for k, i := range best {
+		selected[k] = coins[i]
+	}
+	return selected, true
+}
+
+// privacyFirst groups coins by address and spends whole groups. A single
+// address that covers the target is preferred, the smallest such one;
+// otherwise the largest groups are merged until the target is met.
+func privacyFirst(coins []Coin, costs selectionCosts) ([]Coin, error) {
+	type group struct {
+		coins []Coin
+		value int
+	}
+	var groups []*group
+	byScript := make(map[string]*group)
+	for _, coin := range coins {
+		g, ok := byScript[coin.Output.ScriptPubKey]
+		if !ok {
+			g = &group{}
+			byScript[coin.Output.ScriptPubKey] = g
+			groups = append(groups, g)
+		}
+		g.coins = append(g.coins, coin)
+		g.value += costs.effective(coin)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].value > groups[j].value })
+
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i].value >= costs.target {
+			return groups[i].coins, nil
+		}
+	}
+	var selected []Coin
+	total := 0
+	for _, g := range groups {
+		selected = append(selected, g.coins...)
+		total += g.value
+		if total >= costs.target {
+			return selected, nil
+		}
+	}
+	return nil, ErrInsufficientFunds
+}
@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+)
+
+// testCoins makes one coin per value, paid to the matching address, or to
+// "a" when addresses runs out.
+func testCoins(values []int, addresses ...string) []Coin {
+	coins := make([]Coin, len(values))
+	for i, value := range values {
+		address := "a"
+		if i < len(addresses) {
+			address = addresses[i]
+		}
+		coins[i] = Coin{
+			OutPoint: utxo.OutPoint{TxID: fmt.Sprint("coin", i)},
+			Output:   transaction.Output{Value: value, ScriptPubKey: address},
+		}
+	}
+	return coins
+}
+
+func values(coins []Coin) []int {
+	out := make([]int, len(coins))
+	for i, coin := range coins {
+		out[i] = coin.Output.Value
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(out)))
+	return out
+}
+
+// selectionTest is shared by the strategy table and the privacy cases
+// generated for it.
+type selectionTest struct {
+	name     string
+	strategy CoinSelection
+	coins    []Coin
+	costs    selectionCosts
+	want     []int
+	wantErr  error
+}
+
+func TestSelectCoins(t *testing.T) {
+	free := func(Coin) int { return 0 }
+	perInput := func(Coin) int { return 2 }
+	tests := []selectionTest{
+		{"largest first", SelectLargestFirst, testCoins([]int{3, 10, 5, 7}), selectionCosts{12, 0, free}, []int{10, 7}, nil},
+		{"branch and bound exact match", SelectBranchAndBound, testCoins([]int{3, 10, 5, 7}), selectionCosts{8, 0, free}, []int{5, 3}, nil},
+		{"branch and bound is the default", "", testCoins([]int{3, 10, 5, 7}), selectionCosts{8, 0, free}, []int{5, 3}, nil},
+		{"branch and bound least waste", SelectBranchAndBound, testCoins([]int{10, 9, 4}), selectionCosts{8, 2, free}, []int{9}, nil},
+		{"branch and bound counts input fees", SelectBranchAndBound, testCoins([]int{12, 7, 5}), selectionCosts{8, 0, perInput}, []int{7, 5}, nil},
+		{"branch and bound falls back to largest first", SelectBranchAndBound, testCoins([]int{10, 20}), selectionCosts{8, 1, free}, []int{20}, nil},
+		{"uneconomical coins dropped", SelectPrivacy, testCoins([]int{10, 2, 1}), selectionCosts{8, 0, perInput}, []int{10}, nil},
+		{"insufficient funds", SelectBranchAndBound, testCoins([]int{3, 4}), selectionCosts{8, 0, free}, nil, ErrInsufficientFunds},
+		{"insufficient after input fees", SelectLargestFirst, testCoins([]int{5, 5}), selectionCosts{8, 0, perInput}, nil, ErrInsufficientFunds},
+		{"unknown strategy", "random", testCoins([]int{10}), selectionCosts{8, 0, free}, nil, ErrUnknownSelection},
+	}
+	// Two small coins on a, one on b and one large coin on c.
+	grouped := testCoins([]int{5, 5, 12, 30}, "a", "a", "b", "c")
+	privacy := []struct {
+		target int
+		want   []int
+	}{
+		{9, []int{5, 5}},
+		{11, []int{12}},
+		{20, []int{30}},
+		{40, []int{30, 12}},
+		{45, []int{30, 12, 5, 5}},
+	}
+	for _, p := range privacy {
+		name := fmt.Sprint("privacy for ", p.target)
+		tests = append(tests, selectionTest{name, SelectPrivacy, grouped, selectionCosts{p.target, 0, free}, p.want, nil})
+	}
+	tests = append(tests, selectionTest{"privacy insufficient funds", SelectPrivacy, grouped, selectionCosts{53, 0, free}, nil, ErrInsufficientFunds})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectCoins(tt.strategy, tt.coins, tt.costs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := values(selected); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters for new wallets: about 100ms and 32MB per unlock. They
+// are stored with the ciphertext so they can be raised later.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Limits on the scrypt parameters read back from a wallet file, so that an
+// edited file cannot make unlocking take unbounded time or memory. scrypt
+// needs 128*N*R bytes.
+const (
+	maxScryptN      = 1 << 20
+	maxScryptP      = 16
+	maxScryptMemory = 1 << 30
+)
+
+const saltSize = 16
+
+var ErrWrongPassphrase = errors.New("wallet: wrong passphrase")
+var ErrEmptyPassphrase = errors.New("wallet: passphrase must not be empty")
+
+// sealedBox is secret data encrypted with AES-256-GCM under a key
+// stretched from the passphrase with scrypt.
+type sealedBox struct {
+	KDF        string
+	Salt       []byte
+	N, R, P    int
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+func seal(passphrase string, plaintext []byte) (*sealedBox, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	box := &sealedBox{KDF: "scrypt", Salt: make([]byte, saltSize), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := rand.Read(box.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := box.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	box.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(box.Nonce); err != nil {
+		return nil, err
+	}
+	box.Ciphertext = aead.Seal(nil, box.Nonce, plaintext, nil)
+	return box, nil
+}
+
+// open decrypts the box. GCM authenticates the ciphertext, so a wrong
+// passphrase and a tampered file both fail here.
+func (box *sealedBox) open(passphrase string) ([]byte, error) {
+	if !box.valid() {
+		return nil, ErrCorrupt
+	}
+	aead, err := box.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(box.Nonce) != aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	plaintext, err := aead.Open(nil, box.Nonce, box.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+// valid checks the KDF and its cost before any work is done with them.
+func (box *sealedBox) valid() bool {
+	if box.KDF != "scrypt" || box.N < 2 || box.N&(box.N-1) != 0 || box.N > maxScryptN {
+		return false
+	}
+	if box.R < 1 || box.P < 1 || box.P > maxScryptP {
+		return false
+	}
+	return box.R <= maxScryptMemory/128/box.N
+}
+
+func (box *sealedBox) cipher(passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), box.Salt, box.N, box.R, box.P, 32)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,211 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HardenedKeyStart is added to a child index to derive a hardened child,
+// written with a trailing ' in paths.
+const HardenedKeyStart = 0x80000000
+
+// masterKeySalt is the SLIP-10 HMAC key for NIST P-256, the curve the rest
+// of the chain signs with.
+const masterKeySalt = "Nist256p1 seed"
+
+var ErrBadPath = errors.New("wallet: bad derivation path")
+var ErrHardenedPublic = errors.New("wallet: cannot derive a hardened child from a public key")
+var ErrNoPrivateKey = errors.New("wallet: extended key has no private key")
+var ErrBadPublicKey = errors.New("wallet: bad public key")
+
+// ExtendedKey is a key and chain code in a SLIP-10 hierarchy, the P-256
+// generalization of BIP32. A public extended key has no private half but
+// can still derive non-hardened children, which is how a locked wallet
+// hands out addresses.
+type ExtendedKey struct {
+	key       []byte
+	x, y      *big.Int
+	chainCode []byte
+	depth     int
+	index     uint32
+}
+
+// NewMaster derives the root of the hierarchy from a BIP39 seed.
+func NewMaster(seed []byte) *ExtendedKey {
+	data := seed
+	for {
+		sum := hmacSHA512([]byte(masterKeySalt), data)
+		if validScalar(sum[:32]) {
+			return newPrivate(sum[:32], sum[32:], 0, 0)
+		}
+		data = sum
+	}
+}
+
+// NewPublicKey makes a public extended key from a compressed public key and
+// chain code, as saved by a wallet.
+func NewPublicKey(pubKey, chainCode []byte) (*ExtendedKey, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+	if x == nil || len(chainCode) != 32 {
+		return nil, ErrBadPublicKey
+	}
+	return &ExtendedKey{x: x, y: y, chainCode: chainCode}, nil
+}
+
+func newPrivate(key, chainCode []byte, depth int, index uint32) *ExtendedKey {
+	x, y := elliptic.P256().ScalarBaseMult(key)
+	return &ExtendedKey{key: key, x: x, y: y, chainCode: chainCode, depth: depth, index: index}
+}
+
+// Child derives child index i. Hardened children commit to the private key
+// so knowing this key's public half and chain code reveals nothing about
+// them.
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	var data []byte
+	switch {
+	case i < HardenedKeyStart:
+		data = k.PublicKeyBytes()
+	case k.key != nil:
+		data = append([]byte{0}, k.key...)
+	default:
+		return nil, ErrHardenedPublic
+	}
+	data = binary.BigEndian.AppendUint32(data, i)
+	curve := elliptic.P256()
+	for {
+		sum := hmacSHA512(k.chainCode, data)
+		if validScalar(sum[:32]) {
+			if k.key != nil {
+				child := new(big.Int).SetBytes(sum[:32])
+				child.Add(child, new(big.Int).SetBytes(k.key))
+				child.Mod(child, curve.Params().N)
+				if child.Sign() != 0 {
+					return newPrivate(child.FillBytes(make([]byte, 32)), sum[32:], k.depth+1, i), nil
+				}
+			} else {
+				x, y := curve.ScalarBaseMult(sum[:32])
+				x, y = curve.Add(x, y, k.x, k.y)
+				if x.Sign() != 0 || y.Sign() != 0 {
+					return &ExtendedKey{x: x, y: y, chainCode: sum[32:], depth: k.depth + 1, index: i}, nil
+				}
+			}
+		}
+		// SLIP-10: on an unusable result retry with 0x01 || IR || i.
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, sum[32:]...), i)
+	}
+}
+
+// Derive follows path from k, which is normally the master key.
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	for _, i := range path {
+		var err error
+		if k, err = k.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Public returns k without its private key.
+func (k *ExtendedKey) Public() *ExtendedKey {
+	return &ExtendedKey{x: k.x, y: k.y, chainCode: k.chainCode, depth: k.depth, index: k.index}
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.key != nil
+}
+
+func (k *ExtendedKey) Depth() int {
+	return k.depth
+}
+
+func (k *ExtendedKey) Index() uint32 {
+	return k.index
+}
+
+func (k *ExtendedKey) ChainCode() []byte {
+	return k.chainCode
+}
+
+func (k *ExtendedKey) PrivateKey() (*ecdsa.PrivateKey, error) {
+	if k.key == nil {
+		return nil, ErrNoPrivateKey
+	}
+	return &ecdsa.PrivateKey{PublicKey: *k.PublicKey(), D: new(big.Int).SetBytes(k.key)}, nil
+}
+
+func (k *ExtendedKey) PublicKey() *ecdsa.PublicKey {
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: k.x, Y: k.y}
+}
+
+// PublicKeyBytes is the compressed public key.
+func (k *ExtendedKey) PublicKeyBytes() []byte {
+	return elliptic.MarshalCompressed(elliptic.P256(), k.x, k.y)
+}
+
+// zero overwrites the private key so it does not linger in memory once the
+// wallet is locked.
+func (k *ExtendedKey) zero() {
+	for i := range k.key {
+		k.key[i] = 0
+	}
+	k.key = nil
+}
+
+// ParsePath parses a derivation path such as "m/44'/1'/0'". Hardened
+// indexes are marked with ' or h.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q must start with m", ErrBadPath, path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		i, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBadPath, path)
+		}
+		if hardened {
+			i += HardenedKeyStart
+		}
+		indexes = append(indexes, uint32(i))
+	}
+	return indexes, nil
+}
+
+// FormatPath is the inverse of ParsePath.
+func FormatPath(path []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, i := range path {
+		if i >= HardenedKeyStart {
+			fmt.Fprintf(&b, "/%d'", i-HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&b, "/%d", i)
+		}
+	}
+	return b.String()
+}
+
+func validScalar(b []byte) bool {
+	v := new(big.Int).SetBytes(b)
+	return v.Sign() != 0 && v.Cmp(elliptic.P256().Params().N) < 0
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
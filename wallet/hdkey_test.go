@@ -0,0 +1,168 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// SLIP-10 nist256p1 reference vectors. The last two of the first seed and
+// the second seed exercise the retry when a derived key is out of range.
+var slip10Vectors = []struct {
+	seed      string
+	path      string
+	chainCode string
+	key       string
+	pubKey    string
+}{
+	{"000102030405060708090a0b0c0d0e0f", "m",
+		"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+		"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+		"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0H",
+		"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+		"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+		"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0H/1",
+		"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+		"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+		"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0H/1/2H",
+		"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+		"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+		"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0"},
+	{"000102030405060708090a0b0c0d0e0f", "m/0H/1/2H/2",
+		"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+		"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+		"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20"},
+	{"000102030405060708090a0b0c0d0e0f", "m/28578H",
+		"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+		"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669",
+		"02519b5554a4872e8c9c1c847115363051ec43e93400e030ba3c36b52a3e70a5b7"},
+	{"000102030405060708090a0b0c0d0e0f", "m/28578H/33941",
+		"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+		"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a",
+		"0235bfee614c0d5b2cae260000bb1d0d84b270099ad790022c1ae0b2e782efe120"},
+	{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", "m",
+		"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+		"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f",
+		"0383619fadcde31063d8c5cb00dbfe1713f3e6fa169d8541a798752a1c1ca0cb20"},
+}
+
+func TestSLIP10Vectors(t *testing.T) {
+	for _, v := range slip10Vectors {
+		t.Run(v.seed[:8]+" "+v.path, func(t *testing.T) {
+			seed, _ := hex.DecodeString(v.seed)
+			// The vectors mark hardened indexes with H, which ParsePath
+			// does not take.
+			path, err := ParsePath(strings.ReplaceAll(v.path, "H", "h"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := NewMaster(seed).Derive(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(key.ChainCode()); got != v.chainCode {
+				t.Fatalf("chain code %s, want %s", got, v.chainCode)
+			}
+			priv, err := key.PrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(priv.D.FillBytes(make([]byte, 32))); got != v.key {
+				t.Fatalf("private key %s, want %s", got, v.key)
+			}
+			if got := hex.EncodeToString(key.PublicKeyBytes()); got != v.pubKey {
+				t.Fatalf("public key %s, want %s", got, v.pubKey)
+			}
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path      string
+		want      []uint32
+		formatted string
+	}{
+		{"m", []uint32{}, "m"},
+		{"m/0", []uint32{0}, "m/0"},
+		{"m/44'/1'/0'", []uint32{44 + HardenedKeyStart, 1 + HardenedKeyStart, HardenedKeyStart}, "m/44'/1'/0'"},
+		{"m/0h/1/2H", nil, ""},
+		{"m/0h/1/2h", []uint32{HardenedKeyStart, 1, 2 + HardenedKeyStart}, "m/0'/1/2'"},
+		{"m/2147483647'", []uint32{HardenedKeyStart - 1 + HardenedKeyStart}, "m/2147483647'"},
+		{"", nil, ""},
+		{"44'/0", nil, ""},
+		{"m/", nil, ""},
+		{"m/x", nil, ""},
+		{"m/-1", nil, ""},
+		{"m/1''", nil, ""},
+		{"m/2147483648", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParsePath(tt.path)
+			if tt.want == nil {
+				if !errors.Is(err, ErrBadPath) {
+					t.Fatalf("got %v, %v; want %v", got, err, ErrBadPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if s := FormatPath(got); s != tt.formatted {
+				t.Fatalf("formatted as %q, want %q", s, tt.formatted)
+			}
+			if again, err := ParsePath(FormatPath(got)); err != nil || !reflect.DeepEqual(again, got) {
+				t.Fatalf("round trip gave %v, %v", again, err)
+			}
+		})
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	seed, _ := hex.DecodeString(slip10Vectors[0].seed)
+	account, err := NewMaster(seed).Derive([]uint32{44 + HardenedKeyStart, 1 + HardenedKeyStart, HardenedKeyStart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := NewPublicKey(account.PublicKeyBytes(), account.ChainCode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watch.IsPrivate() {
+		t.Fatal("public key reports a private key")
+	}
+	if _, err := watch.PrivateKey(); !errors.Is(err, ErrNoPrivateKey) {
+		t.Fatalf("private key of a public key: %v, want %v", err, ErrNoPrivateKey)
+	}
+	for _, path := range [][]uint32{{0}, {1}, {0, 7}, {1, 0, HardenedKeyStart - 1}} {
+		private, err := account.Derive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, public := range []*ExtendedKey{account.Public(), watch} {
+			derived, err := public.Derive(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(derived.PublicKeyBytes(), private.PublicKeyBytes()) || !bytes.Equal(derived.ChainCode(), private.ChainCode()) {
+				t.Fatalf("%s: public derivation differs from private", FormatPath(path))
+			}
+		}
+	}
+	if _, err := watch.Child(HardenedKeyStart); !errors.Is(err, ErrHardenedPublic) {
+		t.Fatalf("hardened child of a public key: %v, want %v", err, ErrHardenedPublic)
+	}
+	if _, err := NewPublicKey([]byte{2, 1, 2, 3}, account.ChainCode()); !errors.Is(err, ErrBadPublicKey) {
+		t.Fatalf("bad public key: %v, want %v", err, ErrBadPublicKey)
+	}
+}
@@ -0,0 +1,110 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// english.txt is the BIP39 English wordlist.
+//
+//go:embed english.txt
+var englishWords string
+
+var wordList = strings.Fields(englishWords)
+var wordIndex = func() map[string]int {
+	index := make(map[string]int, len(wordList))
+	for i, w := range wordList {
+		index[w] = i
+	}
+	return index
+}()
+
+var ErrEntropySize = errors.New("wallet: entropy must be 128 to 256 bits in steps of 32")
+var ErrInvalidMnemonic = errors.New("wallet: invalid mnemonic")
+
+// NewMnemonic returns a BIP39 phrase encoding bits of fresh entropy: 12
+// words for 128 bits up to 24 words for 256.
+func NewMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrEntropySize
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic appends the BIP39 checksum to entropy and spells it out
+// eleven bits per word.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrEntropySize
+	}
+	sum := sha256.Sum256(entropy)
+	checksumBits := bits / 32
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, uint(checksumBits))
+	n.Or(n, big.NewInt(int64(sum[0]>>(8-checksumBits))))
+
+	words := make([]string, (bits+checksumBits)/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy checks the words and checksum of mnemonic and returns
+// the entropy it encodes.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+	n := new(big.Int)
+	for _, w := range words {
+		i, ok := wordIndex[w]
+		if !ok {
+			return nil, ErrInvalidMnemonic
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(i)))
+	}
+	checksumBits := len(words) * 11 / 33
+	checksum := byte(new(big.Int).And(n, big.NewInt(1<<checksumBits-1)).Int64())
+	n.Rsh(n, uint(checksumBits))
+
+	entropy := make([]byte, checksumBits*4)
+	n.FillBytes(entropy)
+	sum := sha256.Sum256(entropy)
+	if sum[0]>>(8-checksumBits) != checksum {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicToSeed stretches a valid mnemonic and optional passphrase into
+// the 64-byte BIP39 seed. Neither is NFKD-normalized, so phrases and
+// passphrases outside ASCII may not match other wallets.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if !ValidateMnemonic(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
@@ -0,0 +1,106 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// BIP39 reference vectors, all with the passphrase "TREZOR".
+var mnemonicVectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	for _, v := range mnemonicVectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Fatalf("mnemonic for %s:\n got %s\nwant %s", v.entropy, mnemonic, v.mnemonic)
+		}
+		back, err := MnemonicToEntropy(mnemonic)
+		if err != nil || !bytes.Equal(back, entropy) {
+			t.Fatalf("entropy for %q: %x, %v", mnemonic, back, err)
+		}
+		seed, err := MnemonicToSeed(mnemonic, "TREZOR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(seed); got != v.seed {
+			t.Fatalf("seed for %q:\n got %s\nwant %s", mnemonic, got, v.seed)
+		}
+	}
+}
+
+func TestMnemonicErrors(t *testing.T) {
+	for _, n := range []int{0, 15, 17, 33} {
+		if _, err := EntropyToMnemonic(make([]byte, n)); !errors.Is(err, ErrEntropySize) {
+			t.Fatalf("%d bytes of entropy: %v, want %v", n, err, ErrEntropySize)
+		}
+	}
+	if _, err := NewMnemonic(100); !errors.Is(err, ErrEntropySize) {
+		t.Fatalf("100 bits: %v, want %v", err, ErrEntropySize)
+	}
+
+	valid := mnemonicVectors[0].mnemonic
+	tests := []struct {
+		name     string
+		mnemonic string
+	}{
+		{"bad checksum", strings.Repeat("abandon ", 12)},
+		{"unknown word", strings.Replace(valid, "about", "aboot", 1)},
+		{"too few words", strings.Repeat("abandon ", 8) + "about"},
+		{"word count not a multiple of three", valid + " abandon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ValidateMnemonic(tt.mnemonic) {
+				t.Fatal("accepted")
+			}
+			if _, err := MnemonicToSeed(tt.mnemonic, ""); !errors.Is(err, ErrInvalidMnemonic) {
+				t.Fatalf("got %v, want %v", err, ErrInvalidMnemonic)
+			}
+		})
+	}
+
+	fresh, err := NewMnemonic(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := strings.Fields(fresh); len(words) != 24 || !ValidateMnemonic(fresh) {
+		t.Fatalf("fresh mnemonic %q", fresh)
+	}
+}
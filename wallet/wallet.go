@@ -0,0 +1,601 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"blockchain-hello-golang/block"
+	"blockchain-hello-golang/crypto"
+	"blockchain-hello-golang/fork"
+	"blockchain-hello-golang/transaction"
+	"blockchain-hello-golang/utxo"
+)
+
+// DefaultAccountPath is the first BIP44 account with coin type 1, the one
+// reserved for test networks.
+const DefaultAccountPath = "m/44'/1'/0'"
+
+// GapLimit is how many unused addresses past the last used one are watched
+// on each chain. Restoring from a mnemonic finds every address handed out
+// unless more than this many in a row never received anything.
+const GapLimit = 20
+
+// maxAddressIndex bounds the issued and used indexes read from a wallet
+// file, since every address up to them is derived on load.
+const maxAddressIndex = 100000
+
+// MnemonicBits is the entropy of mnemonics made for new wallets, giving 24
+// words.
+const MnemonicBits = 256
+
+// The two BIP44 chains below an account.
+const (
+	ReceiveChain uint32 = 0
+	ChangeChain  uint32 = 1
+)
+
+var ErrExists = errors.New("wallet: wallet file already exists")
+var ErrCorrupt = errors.New("wallet: corrupt wallet file")
+var ErrLocked = errors.New("wallet: wallet is locked")
+var ErrUnknownAddress = errors.New("wallet: address does not belong to the wallet")
+
+// Address is a key the wallet has derived.
+type Address struct {
+	Path         string
+	Chain        uint32
+	Index        uint32
+	Address      string
+	ScriptPubKey string
+	PublicKey    *ecdsa.PublicKey
+	Used         bool
+}
+
+type keyChain struct {
+	key    *ExtendedKey
+	path   []uint32
+	addrs  []*Address
+	issued int
+	// private is the chain's private key while the wallet is unlocked.
+	private *ExtendedKey
+}
+
+// Wallet derives every key from a BIP39 mnemonic along one BIP44 account.
+// The file keeps the mnemonic encrypted under the wallet passphrase next to
+// the public keys of the receive and change chains, so addresses can be
+// handed out and watched while the wallet is locked. Private keys exist
+// only between Unlock and Lock.
+type Wallet struct {
+	mu            sync.Mutex
+	path          string
+	accountPath   string
+	chains        [2]*keyChain
+	byScript      map[string]*Address
+	secret        *sealedBox
+	mnemonic      string
+	unlockedUntil time.Time
+	lockTimer     *time.Timer
+	coins         map[utxo.OutPoint]Coin
+	fees          FeeEstimator
+}
+
+type savedWallet struct {
+	AccountPath string
+	Chains      [2]savedChain
+	Secret      *sealedBox
+	Coins       []Coin
+}
+
+type savedChain struct {
+	PublicKey string
+	ChainCode string
+	Issued    int
+	Used      []uint32
+}
+
+// secrets is what gets encrypted.
+type secrets struct {
+	Mnemonic       string
+	SeedPassphrase string
+}
+
+// Create makes a wallet with a fresh mnemonic, encrypted with passphrase,
+// and saves it at path. The mnemonic is returned so it can be written
+// down; it is the only way to recover the keys without the file.
+func Create(path, accountPath, passphrase string) (*Wallet, string, error) {
+	mnemonic, err := NewMnemonic(MnemonicBits)
+	if err != nil {
+		return nil, "", err
+	}
+	w, err := Restore(path, mnemonic, "", accountPath, passphrase)
+	return w, mnemonic, err
+}
+
+// Restore recreates a wallet from its mnemonic and the optional BIP39 seed
+// passphrase, encrypts it with passphrase and saves it at path. Which
+// addresses were used is rediscovered with Rescan.
+func Restore(path, mnemonic, seedPassphrase, accountPath, passphrase string) (*Wallet, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrExists
+	}
+	account, err := ParsePath(accountPath)
+	if err != nil {
+		return nil, err
+	}
+	chains, err := deriveChains(secrets{Mnemonic: mnemonic, SeedPassphrase: seedPassphrase}, account)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(secrets{Mnemonic: mnemonic, SeedPassphrase: seedPassphrase})
+	if err != nil {
+		return nil, err
+	}
+	box, err := seal(passphrase, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	w := newWallet(path, FormatPath(account), box)
+	for c := range w.chains {
+		w.chains[c] = &keyChain{key: chains[c].Public(), path: append(append([]uint32{}, account...), uint32(c))}
+		w.extend(w.chains[c])
+	}
+	return w, w.Save()
+}
+
+// RestoreBackup copies a backup made with Backup to path and opens it.
+func RestoreBackup(backup, path string) (*Wallet, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrExists
+	}
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := load(path, data); err != nil {
+		return nil, err
+	}
+	if err := writeFile(path, data); err != nil {
+		return nil, err
+	}
+	return Open(path)
+}
+
+// Open loads the wallet saved at path. It starts out locked.
+func Open(path string) (*Wallet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return load(path, data)
+}
+
+func load(path string, data []byte) (*Wallet, error) {
+	var saved savedWallet
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Secret == nil || !saved.Secret.valid() {
+		return nil, ErrCorrupt
+	}
+	account, err := ParsePath(saved.AccountPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	w := newWallet(path, FormatPath(account), saved.Secret)
+	for c, sc := range saved.Chains {
+		pubKey, err1 := hex.DecodeString(sc.PublicKey)
+		chainCode, err2 := hex.DecodeString(sc.ChainCode)
+		if err1 != nil || err2 != nil || sc.Issued < 0 || sc.Issued > maxAddressIndex {
+			return nil, ErrCorrupt
+		}
+		for _, i := range sc.Used {
+			if i > maxAddressIndex {
+				return nil, ErrCorrupt
+			}
+		}
+		key, err := NewPublicKey(pubKey, chainCode)
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		chain := &keyChain{key: key, path: append(append([]uint32{}, account...), uint32(c)), issued: sc.Issued}
+		w.chains[c] = chain
+		for _, i := range sc.Used {
+			w.markUsed(chain, int(i))
+		}
+		w.extend(chain)
+	}
+	for _, coin := range saved.Coins {
+		if _, ok := w.byScript[coin.Output.ScriptPubKey]; !ok {
+			return nil, ErrCorrupt
+		}
+		w.coins[coin.OutPoint] = coin
+	}
+	return w, nil
+}
+
+func newWallet(path, accountPath string, secret *sealedBox) *Wallet {
+	return &Wallet{
+		path:        path,
+		accountPath: accountPath,
+		secret:      secret,
+		byScript:    make(map[string]*Address),
+		coins:       make(map[utxo.OutPoint]Coin),
+	}
+}
+
+// deriveChains regenerates the private keys of the receive and change
+// chains from the mnemonic.
+func deriveChains(s secrets, account []uint32) ([2]*ExtendedKey, error) {
+	var chains [2]*ExtendedKey
+	seed, err := MnemonicToSeed(s.Mnemonic, s.SeedPassphrase)
+	if err != nil {
+		return chains, err
+	}
+	accountKey, err := NewMaster(seed).Derive(account)
+	if err != nil {
+		return chains, err
+	}
+	for c := range chains {
+		if chains[c], err = accountKey.Child(uint32(c)); err != nil {
+			return chains, err
+		}
+	}
+	return chains, nil
+}
+
+// Save writes the wallet to disk, readable only by its owner.
+func (w *Wallet) Save() error {
+	data, err := w.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFile(w.path, data)
+}
+
+// Backup writes a copy of the wallet file to dest. The copy stays
+// encrypted with the current passphrase.
+func (w *Wallet) Backup(dest string) error {
+	src, err1 := filepath.Abs(w.path)
+	dst, err2 := filepath.Abs(dest)
+	if err1 == nil && err2 == nil && src == dst {
+		return fmt.Errorf("wallet: backup destination is the wallet file itself")
+	}
+	data, err := w.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFile(dest, data)
+}
+
+func (w *Wallet) marshal() ([]byte, error) {
+	w.mu.Lock()
+	saved := savedWallet{AccountPath: w.accountPath, Secret: w.secret}
+	for c, chain := range w.chains {
+		sc := savedChain{
+			PublicKey: hex.EncodeToString(chain.key.PublicKeyBytes()),
+			ChainCode: hex.EncodeToString(chain.key.ChainCode()),
+			Issued:    chain.issued,
+		}
+		for _, a := range chain.addrs {
+			if a.Used {
+				sc.Used = append(sc.Used, a.Index)
+			}
+		}
+		saved.Chains[c] = sc
+	}
+	saved.Coins = w.sortedCoins()
+	w.mu.Unlock()
+	return json.MarshalIndent(saved, "", "  ")
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (w *Wallet) Path() string {
+	return w.path
+}
+
+func (w *Wallet) AccountPath() string {
+	return w.accountPath
+}
+
+// Unlock decrypts the keys for timeout, or until Lock if timeout is zero.
+// Unlocking an unlocked wallet resets the timeout.
+func (w *Wallet) Unlock(passphrase string, timeout time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, err := w.openSecret(passphrase)
+	if err != nil {
+		return err
+	}
+	account, _ := ParsePath(w.accountPath)
+	chains, err := deriveChains(s, account)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	for c, chain := range w.chains {
+		if chains[c].x.Cmp(chain.key.x) != 0 || chains[c].y.Cmp(chain.key.y) != 0 {
+			return fmt.Errorf("%w: mnemonic does not match the chain keys", ErrCorrupt)
+		}
+	}
+	w.lock()
+	for c, chain := range w.chains {
+		chain.private = chains[c]
+	}
+	w.mnemonic = s.Mnemonic
+	if timeout > 0 {
+		w.unlockedUntil = time.Now().Add(timeout)
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if w.lockTimer == timer {
+				w.lock()
+			}
+		})
+		w.lockTimer = timer
+	}
+	return nil
+}
+
+// Lock forgets the decrypted keys.
+func (w *Wallet) Lock() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lock()
+}
+
+func (w *Wallet) lock() {
+	if w.lockTimer != nil {
+		w.lockTimer.Stop()
+		w.lockTimer = nil
+	}
+	for _, chain := range w.chains {
+		if chain.private != nil {
+			chain.private.zero()
+			chain.private = nil
+		}
+	}
+	w.mnemonic = ""
+	w.unlockedUntil = time.Time{}
+}
+
+func (w *Wallet) IsLocked() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.chains[0].private == nil
+}
+
+// UnlockedUntil is when the wallet locks itself again; zero if it is locked
+// or stays unlocked until Lock.
+func (w *Wallet) UnlockedUntil() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.unlockedUntil
+}
+
+// ChangePassphrase re-encrypts the wallet under a new passphrase and saves
+// it. Backups keep the passphrase they were made with.
+func (w *Wallet) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	w.mu.Lock()
+	s, err := w.openSecret(oldPassphrase)
+	if err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	plaintext, err := json.Marshal(s)
+	if err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	box, err := seal(newPassphrase, plaintext)
+	if err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	w.secret = box
+	w.mu.Unlock()
+	return w.Save()
+}
+
+func (w *Wallet) openSecret(passphrase string) (secrets, error) {
+	var s secrets
+	plaintext, err := w.secret.open(passphrase)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(plaintext, &s); err != nil {
+		return s, ErrCorrupt
+	}
+	return s, nil
+}
+
+// Mnemonic is the seed phrase every key can be regenerated from. The wallet
+// must be unlocked.
+func (w *Wallet) Mnemonic() (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.mnemonic == "" {
+		return "", ErrLocked
+	}
+	return w.mnemonic, nil
+}
+
+// PrivateKey returns the key for an address of the wallet, which must be
+// unlocked.
+func (w *Wallet) PrivateKey(scriptPubKey string) (*ecdsa.PrivateKey, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, ok := w.byScript[scriptPubKey]
+	if !ok {
+		return nil, ErrUnknownAddress
+	}
+	private := w.chains[a.Chain].private
+	if private == nil {
+		return nil, ErrLocked
+	}
+	key, err := private.Child(a.Index)
+	if err != nil {
+		return nil, err
+	}
+	return key.PrivateKey()
+}
+
+// NewAddress hands out the next receive address.
+func (w *Wallet) NewAddress() Address {
+	return w.next(ReceiveChain)
+}
+
+// ChangeAddress hands out the next change address.
+func (w *Wallet) ChangeAddress() Address {
+	return w.next(ChangeChain)
+}
+
+// peek returns the address next would hand out on chain c without issuing
+// it.
+func (w *Wallet) peek(c uint32) Address {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	chain := w.chains[c]
+	return *chain.addrs[chain.issued]
+}
+
+func (w *Wallet) next(c uint32) Address {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	chain := w.chains[c]
+	a := chain.addrs[chain.issued]
+	chain.issued++
+	w.extend(chain)
+	return *a
+}
+
+// Addresses lists the addresses handed out on chain c, used or not.
+func (w *Wallet) Addresses(c uint32) []Address {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	chain := w.chains[c]
+	addrs := make([]Address, chain.issued)
+	for i := range addrs {
+		addrs[i] = *chain.addrs[i]
+	}
+	return addrs
+}
+
+// Unused lists the addresses handed out on chain c that have not received
+// anything yet.
+func (w *Wallet) Unused(c uint32) []Address {
+	var unused []Address
+	for _, a := range w.Addresses(c) {
+		if !a.Used {
+			unused = append(unused, a)
+		}
+	}
+	return unused
+}
+
+// Lookup finds the watched address that scriptPubKey pays to.
+func (w *Wallet) Lookup(scriptPubKey string) (Address, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a, ok := w.byScript[scriptPubKey]
+	if !ok {
+		return Address{}, false
+	}
+	return *a, true
+}
+
+// ScanBlock records the outputs blk pays to the wallet, marking their
+// addresses used, and forgets the coins it spends. It reports how many
+// outputs and spent coins were ours.
+func (w *Wallet) ScanBlock(blk block.Block) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	found := 0
+	for i, tx := range blk.Transactions {
+		for _, in := range tx.Inputs {
+			op := utxo.OutPoint{TxID: in.PrevTxID, Index: in.OutputIndex}
+			if _, ok := w.coins[op]; ok {
+				delete(w.coins, op)
+				found++
+			}
+		}
+		for n, out := range tx.Outputs {
+			a, ok := w.byScript[out.ScriptPubKey]
+			if !ok {
+				continue
+			}
+			found++
+			chain := w.chains[a.Chain]
+			w.markUsed(chain, int(a.Index))
+			w.extend(chain)
+			op := utxo.OutPoint{TxID: tx.ID, Index: n}
+			w.coins[op] = Coin{OutPoint: op, Output: out, Height: blk.Index, Coinbase: i == 0}
+		}
+	}
+	return found
+}
+
+// Rescan scans the main chain from genesis, which after Restore finds the
+// addresses that were in use.
+func (w *Wallet) Rescan(chain *fork.ChainManager) error {
+	_, height := chain.Tip()
+	for h := 0; h <= height; h++ {
+		hash, ok := chain.HashAtHeight(h)
+		if !ok {
+			break
+		}
+		blk, err := chain.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		w.ScanBlock(blk)
+	}
+	return nil
+}
+
+// markUsed marks index i used; an address used before it was handed out,
+// as after a restore, counts as handed out along with those before it.
+func (w *Wallet) markUsed(chain *keyChain, i int) {
+	for len(chain.addrs) <= i {
+		w.derive(chain)
+	}
+	chain.addrs[i].Used = true
+	if chain.issued <= i {
+		chain.issued = i + 1
+	}
+}
+
+// extend derives keys until GapLimit addresses past the last issued one
+// are watched.
+func (w *Wallet) extend(chain *keyChain) {
+	for len(chain.addrs) < chain.issued+GapLimit {
+		w.derive(chain)
+	}
+}
+
+func (w *Wallet) derive(chain *keyChain) {
+	i := uint32(len(chain.addrs))
+	// Non-hardened children of a public key always exist.
+	key, _ := chain.key.Child(i)
+	pubKey := key.PublicKey()
+	hash := crypto.Hash160(crypto.MarshalPublicKey(pubKey))
+	a := &Address{
+		Path:         FormatPath(append(append([]uint32{}, chain.path...), i)),
+		Chain:        chain.path[len(chain.path)-1],
+		Index:        i,
+		Address:      encodeAddress(hash),
+		ScriptPubKey: transaction.PayToPubKeyHash(pubKey),
+		PublicKey:    pubKey,
+	}
+	chain.addrs = append(chain.addrs, a)
+	w.byScript[a.ScriptPubKey] = a
+}
@@ -0,0 +1,52 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestOpenRejectsExcessiveCosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		edit   func(saved *savedWallet)
+		wantOK bool
+	}{
+		{"unchanged", func(*savedWallet) {}, true},
+		{"scrypt N too large", func(s *savedWallet) { s.Secret.N = maxScryptN << 1 }, false},
+		{"scrypt N not a power of two", func(s *savedWallet) { s.Secret.N = scryptN + 1 }, false},
+		{"scrypt memory too large", func(s *savedWallet) { s.Secret.N, s.Secret.R = maxScryptN, 16 }, false},
+		{"scrypt P too large", func(s *savedWallet) { s.Secret.P = maxScryptP + 1 }, false},
+		{"scrypt R zero", func(s *savedWallet) { s.Secret.R = 0 }, false},
+		{"issued too large", func(s *savedWallet) { s.Chains[ReceiveChain].Issued = maxAddressIndex + 1 }, false},
+		{"used index too large", func(s *savedWallet) { s.Chains[ChangeChain].Used = []uint32{1 << 31} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWallet(t)
+			data, err := os.ReadFile(w.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var saved savedWallet
+			if err := json.Unmarshal(data, &saved); err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(&saved)
+			if data, err = json.Marshal(saved); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(w.Path(), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err = Open(w.Path())
+			if tt.wantOK && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantOK && !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("Open: %v, want %v", err, ErrCorrupt)
+			}
+		})
+	}
+}